@@ -0,0 +1,308 @@
+// Package cbordiscrim applies the discriminated unions of package
+// jsondiscrim to CBOR data, such as the binary payloads sent by
+// devices whose messages mirror a JSON schema.
+//
+// CBOR data is unmarshaled by converting it to the equivalent JSON,
+// keeping the order of map keys, and unmarshaling that with the
+// unmarshalers of a union, so [jsondiscrim.Const] fields and all the
+// options of [jsondiscrim.StructsWithOptions] work just as they do for
+// JSON. For the same reason, the names of fields are given by their
+// json tags rather than their cbor tags. For example:
+//
+//	var r Reading
+//	err := cbordiscrim.Unmarshal(data, &r, json.WithUnmarshalers(
+//		jsondiscrim.Structs[Sensor]((*Thermometer)(nil), (*Hygrometer)(nil)),
+//	))
+//
+// Because [Unmarshal] takes the encoded data, it can also be called
+// from the UnmarshalCBOR method of a type decoded with
+// github.com/fxamacker/cbor.
+package cbordiscrim
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+)
+
+// CBOR major types.
+const (
+	majorArray = 4
+	majorMap   = 5
+	majorTag   = 6
+)
+
+// breakCode ends an item of indefinite length.
+const breakCode = 0xff
+
+// Unmarshal unmarshals the CBOR data item in data into v. It is like
+// [json.Unmarshal] with the given options applied to the JSON
+// equivalent of the data item, as returned by [ToJSON], except that
+// errors about a particular value report the offset in data at which
+// it is encoded.
+func Unmarshal(data []byte, v any, opts ...json.Options) error {
+	jv, err := ToJSON(data)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(jv, v, opts...); err != nil {
+		return itemError(data, err)
+	}
+	return nil
+}
+
+// ToJSON returns the JSON equivalent of the CBOR data item in data.
+// Map keys are written in order, and integer map keys are written as
+// the strings of their decimal representation. Byte strings are written
+// as base64-encoded strings, times as RFC 3339 strings, and undefined as
+// null. Tags other than those for times and bignums are ignored, so
+// their content is written as if it were not tagged.
+//
+// It returns an error if data is not a single well-formed data item,
+// for map keys that are not text strings or integers, and for values
+// that JSON cannot represent, such as infinite numbers and simple
+// values other than false, true, null and undefined.
+func ToJSON(data []byte) (jsontext.Value, error) {
+	if err := cbor.Wellformed(data); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	e := jsontext.NewEncoder(&buf)
+	if _, err := encodeItem(e, data, 0); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(buf.Bytes()), nil
+}
+
+// encodeItem writes the JSON equivalent of the well-formed data item
+// at data[off:] to e, and returns the offset just after it.
+func encodeItem(e *jsontext.Encoder, data []byte, off int) (int, error) {
+	off = untag(data, off)
+	h := readHead(data, off)
+	switch h.major {
+	case majorArray:
+		if err := e.WriteToken(jsontext.BeginArray); err != nil {
+			return 0, err
+		}
+		off, err := eachItem(data, off, h, func(off int) (int, error) {
+			return encodeItem(e, data, off)
+		})
+		if err != nil {
+			return 0, err
+		}
+		return off, e.WriteToken(jsontext.EndArray)
+	case majorMap:
+		if err := e.WriteToken(jsontext.BeginObject); err != nil {
+			return 0, err
+		}
+		off, err := eachItem(data, off, h, func(off int) (int, error) {
+			name, next, err := mapKey(data, off)
+			if err != nil {
+				return 0, err
+			}
+			if err := e.WriteToken(jsontext.String(name)); err != nil {
+				return 0, fmt.Errorf("offset %d: %w", off, err)
+			}
+			return encodeItem(e, data, next)
+		})
+		if err != nil {
+			return 0, err
+		}
+		return off, e.WriteToken(jsontext.EndObject)
+	}
+	var v any
+	rest, err := cbor.UnmarshalFirst(data[off:], &v)
+	if err != nil {
+		return 0, fmt.Errorf("offset %d: %w", off, err)
+	}
+	next := len(data) - len(rest)
+	switch v := v.(type) {
+	case nil:
+		return next, e.WriteToken(jsontext.Null)
+	case bool:
+		return next, e.WriteToken(jsontext.Bool(v))
+	case uint64:
+		return next, e.WriteToken(jsontext.Uint(v))
+	case int64:
+		return next, e.WriteToken(jsontext.Int(v))
+	case big.Int:
+		return next, e.WriteValue(jsontext.Value(v.String()))
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return 0, fmt.Errorf("offset %d: number %v cannot be represented in JSON", off, v)
+		}
+		return next, e.WriteToken(jsontext.Float(v))
+	case string:
+		return next, e.WriteToken(jsontext.String(v))
+	case []byte:
+		return next, e.WriteToken(jsontext.String(base64.StdEncoding.EncodeToString(v)))
+	case time.Time:
+		return next, e.WriteToken(jsontext.String(v.Format(time.RFC3339Nano)))
+	}
+	return 0, fmt.Errorf("offset %d: CBOR value %v cannot be represented in JSON", off, v)
+}
+
+// mapKey returns the JSON member name for the map key at data[off:],
+// and the offset just after it.
+func mapKey(data []byte, off int) (string, int, error) {
+	var key any
+	rest, err := cbor.UnmarshalFirst(data[off:], &key)
+	if err != nil {
+		return "", 0, fmt.Errorf("offset %d: %w", off, err)
+	}
+	next := len(data) - len(rest)
+	switch key := key.(type) {
+	case string:
+		return key, next, nil
+	case uint64:
+		return strconv.FormatUint(key, 10), next, nil
+	case int64:
+		return strconv.FormatInt(key, 10), next, nil
+	}
+	return "", 0, fmt.Errorf("offset %d: map key is not a text string or integer", off)
+}
+
+// head holds the head of a CBOR data item.
+type head struct {
+	major      byte
+	arg        uint64
+	indefinite bool
+	// size holds the number of bytes in the head.
+	size int
+}
+
+// readHead returns the head of the well-formed data item at data[off:].
+func readHead(data []byte, off int) head {
+	h := head{major: data[off] >> 5, size: 1}
+	switch info := data[off] & 0x1f; {
+	case info < 24:
+		h.arg = uint64(info)
+	case info == 31:
+		h.indefinite = true
+	default:
+		n := 1 << (info - 24)
+		for _, b := range data[off+1 : off+1+n] {
+			h.arg = h.arg<<8 | uint64(b)
+		}
+		h.size += n
+	}
+	return h
+}
+
+// eachItem calls f with the offset of each element of the well-formed
+// array or map with head h at data[off:], where the elements of a map
+// are its keys followed by their values. Each call returns the offset
+// just after its element. It returns the offset just after the array
+// or map.
+func eachItem(data []byte, off int, h head, f func(off int) (int, error)) (int, error) {
+	off += h.size
+	for i := uint64(0); h.indefinite || i < h.arg; i++ {
+		if h.indefinite && data[off] == breakCode {
+			return off + 1, nil
+		}
+		var err error
+		if off, err = f(off); err != nil {
+			return 0, err
+		}
+	}
+	return off, nil
+}
+
+// skipItem returns the offset just after the well-formed data item at
+// data[off:].
+func skipItem(data []byte, off int) int {
+	var raw cbor.RawMessage
+	rest, _ := cbor.UnmarshalFirst(data[off:], &raw)
+	return len(data) - len(rest)
+}
+
+// itemError returns err annotated with the offset of the data item
+// within data that it applies to, if that can be found.
+func itemError(data []byte, err error) error {
+	var ptr jsontext.Pointer
+	var semErr *json.SemanticError
+	var synErr *jsontext.SyntacticError
+	switch {
+	case errors.As(err, &semErr):
+		ptr = semErr.JSONPointer
+	case errors.As(err, &synErr):
+		ptr = synErr.JSONPointer
+	default:
+		return err
+	}
+	if off, ok := itemAt(data, ptr); ok {
+		return fmt.Errorf("offset %d: %w", off, err)
+	}
+	return err
+}
+
+// itemAt returns the offset of the data item within data found by the
+// JSON pointer ptr into the JSON equivalent of data, and reports
+// whether there is one.
+func itemAt(data []byte, ptr jsontext.Pointer) (int, bool) {
+	off := 0
+	for tok := range ptr.Tokens() {
+		off = untag(data, off)
+		h := readHead(data, off)
+		found := -1
+		switch h.major {
+		case majorArray:
+			want, err := strconv.Atoi(tok)
+			if err != nil {
+				return 0, false
+			}
+			i := 0
+			eachItem(data, off, h, func(off int) (int, error) {
+				if i == want {
+					found = off
+				}
+				i++
+				return skipItem(data, off), nil
+			})
+		case majorMap:
+			eachItem(data, off, h, func(off int) (int, error) {
+				name, next, err := mapKey(data, off)
+				if err != nil {
+					return 0, err
+				}
+				if name == tok && found < 0 {
+					found = next
+				}
+				return skipItem(data, next), nil
+			})
+		}
+		if found < 0 {
+			return 0, false
+		}
+		off = found
+	}
+	return off, true
+}
+
+// untag returns the offset of the content of any tags at data[off:]
+// that [ToJSON] ignores.
+func untag(data []byte, off int) int {
+	for {
+		h := readHead(data, off)
+		if h.major != majorTag || isKnownTag(h.arg) {
+			return off
+		}
+		off += h.size
+	}
+}
+
+// isKnownTag reports whether n is the number of a tag for times or
+// bignums, which are decoded along with their content.
+func isKnownTag(n uint64) bool {
+	return n <= 3
+}
@@ -0,0 +1,208 @@
+package cbordiscrim
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-quicktest/qt"
+
+	"github.com/cue-exp/jsondiscrim"
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+)
+
+type Sensor interface {
+	isSensor()
+}
+
+type Thermometer struct {
+	Kind jsondiscrim.Const[string, struct {
+		string `const:"temp"`
+	}] `json:"kind"`
+	Celsius float64 `json:"celsius"`
+}
+
+func (*Thermometer) isSensor() {}
+
+type Hygrometer struct {
+	Kind jsondiscrim.Const[string, struct {
+		string `const:"humidity"`
+	}] `json:"kind"`
+	Percent int    `json:"percent"`
+	Raw     []byte `json:"raw,omitempty"`
+}
+
+func (*Hygrometer) isSensor() {}
+
+type Reading struct {
+	Device  string   `json:"device"`
+	Sensors []Sensor `json:"sensors"`
+}
+
+var unmarshalers = json.WithUnmarshalers(jsondiscrim.Structs[Sensor]((*Thermometer)(nil), (*Hygrometer)(nil)))
+
+// pair is a member of an ordered CBOR map in test data.
+type pair struct {
+	key, value any
+}
+
+// orderedMap is marshaled as a CBOR map with its members in order.
+type orderedMap []pair
+
+func (m orderedMap) MarshalCBOR() ([]byte, error) {
+	data := []byte{0xb8, byte(len(m))}
+	for _, p := range m {
+		for _, v := range []any{p.key, p.value} {
+			b, err := cbor.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, b...)
+		}
+	}
+	return data, nil
+}
+
+func mustMarshal(t *testing.T, v any) []byte {
+	data, err := cbor.Marshal(v)
+	qt.Assert(t, qt.IsNil(err))
+	return data
+}
+
+func TestUnmarshal(t *testing.T) {
+	data := mustMarshal(t, orderedMap{
+		{"device", "d1"},
+		{"sensors", []any{
+			orderedMap{{"kind", "temp"}, {"celsius", 21.5}},
+			orderedMap{{"percent", 40}, {"raw", []byte{1, 2}}, {"kind", "humidity"}},
+		}},
+	})
+	var got Reading
+	err := Unmarshal(data, &got, unmarshalers)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, Reading{
+		Device: "d1",
+		Sensors: []Sensor{
+			&Thermometer{Celsius: 21.5},
+			&Hygrometer{Percent: 40, Raw: []byte{1, 2}},
+		},
+	}))
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    any
+		wantErr string
+	}{
+		{
+			name: "unknown discriminator value",
+			data: orderedMap{
+				{"device", "d1"},
+				{"sensors", []any{
+					orderedMap{{"kind", "temp"}},
+					orderedMap{{"kind", "pressure"}},
+				}},
+			},
+			wantErr: `offset 33: .*unknown discriminator value "pressure".*`,
+		},
+		{
+			name: "wrong type in choice",
+			data: orderedMap{
+				{"sensors", []any{
+					orderedMap{{"kind", "humidity"}, {"percent", "high"}},
+				}},
+			},
+			wantErr: `offset 35: .*(cannot|unable to) unmarshal JSON string into Go int.*`,
+		},
+		{
+			name: "missing discriminator",
+			data: orderedMap{
+				{"sensors", []any{
+					orderedMap{{"celsius", 20}},
+				}},
+			},
+			wantErr: `offset 11: .*discriminator field "kind" not found`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Reading
+			err := Unmarshal(mustMarshal(t, tt.data), &got, unmarshalers)
+			qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+		})
+	}
+}
+
+// Device decodes its sensor itself from its CBOR encoding,
+// as it might when it is decoded by other code.
+type Device struct {
+	Sensor Sensor
+}
+
+func (d *Device) UnmarshalCBOR(data []byte) error {
+	var raw struct {
+		Sensor Sensor `json:"sensor"`
+	}
+	if err := Unmarshal(data, &raw, unmarshalers); err != nil {
+		return err
+	}
+	d.Sensor = raw.Sensor
+	return nil
+}
+
+func TestUnmarshalCBOR(t *testing.T) {
+	data := mustMarshal(t, []any{
+		orderedMap{{"sensor", orderedMap{{"kind", "temp"}, {"celsius", 1}}}},
+		orderedMap{{"sensor", orderedMap{{"kind", "humidity"}, {"percent", 2}}}},
+	})
+	var got []Device
+	err := cbor.Unmarshal(data, &got)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, []Device{
+		{Sensor: &Thermometer{Celsius: 1}},
+		{Sensor: &Hygrometer{Percent: 2}},
+	}))
+}
+
+func TestToJSON(t *testing.T) {
+	bigNum, _ := new(big.Int).SetString("-100000000000000000000", 10)
+	tests := []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr string
+	}{
+		{"scalars", mustMarshal(t, []any{
+			"a", uint64(math.MaxUint64), -2, 1.5, true, false, nil, []byte("hi"), *bigNum,
+		}), `["a", 18446744073709551615, -2, 1.5, true, false, null, "aGk=", -100000000000000000000]`, ""},
+		{"undefined", []byte{0xf7}, `null`, ""},
+		{"time", mustMarshal(t, cbor.Tag{Number: 1, Content: 0}), `"1970-01-01T00:00:00Z"`, ""},
+		{"key order", mustMarshal(t, orderedMap{{"b", 1}, {"a", 2}, {"c", orderedMap{{"z", 1}, {"y", 2}}}}), `{"b":1,"a":2,"c":{"z":1,"y":2}}`, ""},
+		{"integer keys", mustMarshal(t, orderedMap{{1, "a"}, {-1, "b"}}), `{"1":"a","-1":"b"}`, ""},
+		{"tag", mustMarshal(t, cbor.Tag{Number: 55799, Content: cbor.Tag{Number: 100, Content: []any{1}}}), `[1]`, ""},
+		// ["a", {_ "b": 1}] with indefinite-length containers.
+		{"indefinite length", []byte{0x9f, 0x61, 'a', 0xbf, 0x61, 'b', 0x01, 0xff, 0xff}, `["a",{"b":1}]`, ""},
+		{"infinity", mustMarshal(t, []any{math.Inf(1)}), ``, `offset 1: number \+Inf cannot be represented in JSON`},
+		{"simple value", []byte{0x81, 0xe0}, ``, `offset 1: CBOR value 0 cannot be represented in JSON`},
+		{"boolean key", mustMarshal(t, orderedMap{{true, 1}}), ``, `offset 2: map key is not a text string or integer`},
+		{"duplicate key", mustMarshal(t, orderedMap{{"a", 1}, {"a", 2}}), ``, `offset 5: jsontext: duplicate object member name "a"`},
+		{"truncated", []byte{0x82, 0x01}, ``, `unexpected EOF`},
+		{"extraneous data", []byte{0x01, 0x02}, ``, `cbor: 1 bytes of extraneous data starting at index 1`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToJSON(tt.data)
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			want := jsontext.Value(tt.want)
+			qt.Assert(t, qt.IsNil(want.Compact()))
+			qt.Assert(t, qt.Equals(string(got), string(want)))
+		})
+	}
+}
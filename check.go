@@ -0,0 +1,164 @@
+package jsondiscrim
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Severity represents the severity of a [Diagnostic].
+type Severity int
+
+const (
+	// SeverityError is used for problems that prevent the union
+	// from being used at all.
+	SeverityError Severity = iota
+
+	// SeverityWarning is used for problems that are likely to be
+	// mistakes, but do not prevent the union from being used.
+	SeverityWarning
+
+	// SeverityInfo is used for observations that are not problems
+	// in themselves but may help to understand the union.
+	SeverityInfo
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Diagnostic describes a problem found by [Union.Check].
+type Diagnostic struct {
+	Severity Severity
+	Message  string
+
+	// Types holds the types of the choices involved, if any.
+	Types []reflect.Type
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%v: %s", d.Severity, d.Message)
+}
+
+// CheckUnion is like [Union.Check] for a union with the given choices
+// and no fallback or options.
+func CheckUnion[T any](choices ...T) []Diagnostic {
+	return Union[T]{Choices: choices}.Check()
+}
+
+// Check returns diagnostics describing any problems with the union.
+// As well as an error when [Union.Build] would panic, it reports
+// warnings for definitions that are valid but probably mistaken, such
+// as the fallback type also being one of the choices or discriminator
+// values that differ only in case. When the discriminator field is
+// given by [WithDiscriminatorField], it also reports, for information,
+// any other [Const] fields that could discriminate between the choices,
+// as they would make the discriminator ambiguous without that option.
+//
+// It is intended to be called from tests, which can assert that none
+// of a program's unions have any diagnostics. Use [Validate] to list
+// all the problems that cause an error.
+func (u Union[T]) Check() []Diagnostic {
+	typ := reflect.TypeFor[T]()
+	if typ.Kind() != reflect.Interface {
+		return []Diagnostic{{
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("type %v is not an interface type", typ),
+		}}
+	}
+	un, err := newUnion(applyOptions(u.Options), typ, u.Fallback, u.Choices)
+	if err != nil {
+		return []Diagnostic{{
+			Severity: SeverityError,
+			Message:  err.Error(),
+		}}
+	}
+	var diags []Diagnostic
+	warn := func(types []reflect.Type, format string, args ...any) {
+		diags = append(diags, Diagnostic{
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf(format, args...),
+			Types:    types,
+		})
+	}
+	for _, choice := range u.Choices {
+		if t := reflect.TypeOf(choice); t == un.fallbackType {
+			warn([]reflect.Type{t}, "fallback type %v is also a choice", t)
+		}
+	}
+	var values []string
+	for v := range un.discrimByValue {
+		if s, ok := v.(string); ok {
+			values = append(values, s)
+		}
+	}
+	slices.Sort(values)
+	byFolded := make(map[string][]string)
+	for _, s := range values {
+		if strings.TrimSpace(s) != s {
+			t := un.discrimByValue[s]
+			warn([]reflect.Type{t}, "discriminator value %q of %v has leading or trailing white space", s, t)
+		}
+		folded := strings.ToLower(s)
+		byFolded[folded] = append(byFolded[folded], s)
+	}
+	for _, folded := range slices.Sorted(maps.Keys(byFolded)) {
+		same := byFolded[folded]
+		if len(same) < 2 {
+			continue
+		}
+		var types []reflect.Type
+		for _, s := range same {
+			types = append(types, un.discrimByValue[s])
+		}
+		warn(types, "discriminator values %q of %v differ only in case", same, types)
+	}
+	if o := applyOptions(u.Options); o.discrimField != "" {
+		for _, field := range otherDiscriminators(o, u.Choices) {
+			diags = append(diags, Diagnostic{
+				Severity: SeverityInfo,
+				Message:  fmt.Sprintf("Const field %q could also be the discriminator; %q is used because it is given by WithDiscriminatorField", field, o.discrimField),
+			})
+		}
+	}
+	return diags
+}
+
+// otherDiscriminators returns the JSON names of the Const fields,
+// other than the one given by [WithDiscriminatorField], that hold a
+// different value in each of the choices.
+func otherDiscriminators[T any](o *options, choices []T) []string {
+	if len(choices) < 2 {
+		return nil
+	}
+	values := make(map[string]map[any]bool)
+	for _, choice := range choices {
+		if isNil(choice) {
+			return nil
+		}
+		for field, v := range mappedConstFields(reflect.TypeOf(choice), o.mapFieldName) {
+			if values[field] == nil {
+				values[field] = make(map[any]bool)
+			}
+			values[field][canonicalValue(v)] = true
+		}
+	}
+	var fields []string
+	for field, vs := range values {
+		if field != o.discrimField && len(vs) == len(choices) {
+			fields = append(fields, field)
+		}
+	}
+	slices.Sort(fields)
+	return fields
+}
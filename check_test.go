@@ -0,0 +1,118 @@
+package jsondiscrim
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+	"github.com/google/go-cmp/cmp"
+)
+
+type UpperDog struct {
+	BaseAnimal[struct {
+		string `const:"Dog"`
+	}]
+}
+
+func (*UpperDog) isAnimal() {}
+
+type SpacedCat struct {
+	BaseAnimal[struct {
+		string `const:"cat "`
+	}]
+}
+
+func (*SpacedCat) isAnimal() {}
+
+func TestCheckUnion(t *testing.T) {
+	tests := []struct {
+		name  string
+		union Union[Animal]
+		want  []Diagnostic
+	}{{
+		name: "ok",
+		union: Union[Animal]{
+			Choices:  []Animal{(*Dog)(nil), (*Cat)(nil)},
+			Fallback: (*OtherAnimal)(nil),
+		},
+	}, {
+		name: "invalid",
+		union: Union[Animal]{
+			Choices: []Animal{(*Dog)(nil), (*Dog)(nil)},
+		},
+		want: []Diagnostic{{
+			Severity: SeverityError,
+			Message:  "choices 0 and 1 have the same type *jsondiscrim.Dog",
+		}},
+	}, {
+		name: "fallback is choice",
+		union: Union[Animal]{
+			Choices:  []Animal{(*Dog)(nil), (*Cat)(nil)},
+			Fallback: (*Cat)(nil),
+		},
+		want: []Diagnostic{{
+			Severity: SeverityWarning,
+			Message:  "fallback type *jsondiscrim.Cat is also a choice",
+			Types:    []reflect.Type{reflect.TypeFor[*Cat]()},
+		}},
+	}, {
+		name: "repeated choice",
+		union: Union[Animal]{
+			Choices: []Animal{(*Dog)(nil), (*Cat)(nil), (*Dog)(nil)},
+			Options: []Option{WithFirstMatch()},
+		},
+		want: []Diagnostic{{
+			Severity: SeverityError,
+			Message:  "choices 0 and 2 have the same type *jsondiscrim.Dog",
+		}},
+	}, {
+		name: "case and space",
+		union: Union[Animal]{
+			Choices: []Animal{(*Dog)(nil), (*UpperDog)(nil), (*SpacedCat)(nil)},
+		},
+		want: []Diagnostic{{
+			Severity: SeverityWarning,
+			Message:  `discriminator value "cat " of *jsondiscrim.SpacedCat has leading or trailing white space`,
+			Types:    []reflect.Type{reflect.TypeFor[*SpacedCat]()},
+		}, {
+			Severity: SeverityWarning,
+			Message:  `discriminator values ["Dog" "dog"] of [*jsondiscrim.UpperDog *jsondiscrim.Dog] differ only in case`,
+			Types:    []reflect.Type{reflect.TypeFor[*UpperDog](), reflect.TypeFor[*Dog]()},
+		}},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qt.Assert(t, qt.CmpEquals(tt.union.Check(), tt.want, cmp.Comparer(cmpWithEqual[reflect.Type])))
+		})
+	}
+}
+
+func TestCheckUnionFunc(t *testing.T) {
+	qt.Assert(t, qt.IsNil(CheckUnion[Animal]((*Dog)(nil), (*Cat)(nil))))
+	qt.Assert(t, qt.Equals(CheckUnion[Animal]((*Dog)(nil), (*UpperDog)(nil))[0].String(),
+		`warning: discriminator values ["Dog" "dog"] of [*jsondiscrim.UpperDog *jsondiscrim.Dog] differ only in case`))
+}
+
+func TestCheckUnionOtherDiscriminators(t *testing.T) {
+	diags := Union[any]{
+		Choices: []any{(*Tri1)(nil), (*Tri2)(nil)},
+		Options: []Option{WithDiscriminatorField("b")},
+	}.Check()
+	qt.Assert(t, qt.DeepEquals(diags, []Diagnostic{{
+		Severity: SeverityInfo,
+		Message:  `Const field "a" could also be the discriminator; "b" is used because it is given by WithDiscriminatorField`,
+	}, {
+		Severity: SeverityInfo,
+		Message:  `Const field "c" could also be the discriminator; "b" is used because it is given by WithDiscriminatorField`,
+	}}))
+	qt.Assert(t, qt.Equals(diags[0].String(), `info: Const field "a" could also be the discriminator; "b" is used because it is given by WithDiscriminatorField`))
+
+	// Without the option, the ambiguity is an error.
+	diags = Union[any]{
+		Choices: []any{(*Tri1)(nil), (*Tri2)(nil)},
+	}.Check()
+	qt.Assert(t, qt.DeepEquals(diags, []Diagnostic{{
+		Severity: SeverityError,
+		Message:  `ambiguous discriminator fields [a b c]; disambiguate with WithDiscriminatorField`,
+	}}))
+}
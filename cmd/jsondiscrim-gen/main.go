@@ -0,0 +1,173 @@
+// The jsondiscrim-gen command generates a function that unmarshals a
+// discriminated union without using reflection to choose the concrete
+// type, using [jsondiscrim.Generate]. It is intended to be run by go
+// generate in the package that defines the union. For example:
+//
+//	//go:generate go run github.com/cue-exp/jsondiscrim/cmd/jsondiscrim-gen -type Animal -choices *Dog,*Cat -fallback *OtherAnimal
+//
+// writes animal_unmarshal.go holding the function unmarshalAnimal,
+// which can be used as follows:
+//
+//	json.WithUnmarshalers(json.UnmarshalFromFunc(unmarshalAnimal))
+//
+// The command works by writing a program that imports the package in
+// the current directory and calls [jsondiscrim.Generate], so the union
+// and choice types must be exported and that package must compile. If
+// an out of date generated file prevents it from compiling, remove the
+// file and run the command again.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+var (
+	typeFlag     = flag.String("type", "", "name of the union interface type (required)")
+	choicesFlag  = flag.String("choices", "", "comma-separated choice types, such as *Dog,Cat (required)")
+	fallbackFlag = flag.String("fallback", "", "fallback choice type, if any")
+	funcFlag     = flag.String("func", "", "name of the generated function (default unmarshal<Type>)")
+	outFlag      = flag.String("o", "", "output file (default <type>_unmarshal.go)")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: jsondiscrim-gen -type T -choices C1,C2... [flags]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *typeFlag == "" || *choicesFlag == "" || flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "jsondiscrim-gen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	fn := *funcFlag
+	if fn == "" {
+		fn = "unmarshal" + *typeFlag
+	}
+	out := *outFlag
+	if out == "" {
+		out = strings.ToLower(*typeFlag) + "_unmarshal.go"
+	}
+	out, err := filepath.Abs(out)
+	if err != nil {
+		return err
+	}
+	list, err := exec.Command("go", "list", "-f", "{{.ImportPath}} {{.Name}}", ".").Output()
+	if err != nil {
+		return fmt.Errorf("cannot determine current package: %v", exitError(err))
+	}
+	importPath, pkgName, ok := strings.Cut(strings.TrimSpace(string(list)), " ")
+	if !ok {
+		return fmt.Errorf("unexpected go list output %q", list)
+	}
+	var choices []string
+	for _, c := range strings.Split(*choicesFlag, ",") {
+		expr, err := choiceExpr(c)
+		if err != nil {
+			return err
+		}
+		choices = append(choices, expr)
+	}
+	fallback := "nil"
+	if *fallbackFlag != "" {
+		if fallback, err = choiceExpr(*fallbackFlag); err != nil {
+			return err
+		}
+	}
+
+	// The program must be within the current module so that it
+	// can import the package, even if that package is internal.
+	// Directories starting with an underscore are ignored by
+	// patterns such as ./..., so it does not affect concurrent
+	// builds.
+	dir, err := os.MkdirTemp(".", "_jsondiscrim-gen")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	var prog bytes.Buffer
+	fmt.Fprintf(&prog, `package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/cue-exp/jsondiscrim"
+
+	pkg %q
+)
+
+func main() {
+	var buf bytes.Buffer
+	err := jsondiscrim.Generate[pkg.%s](&buf, jsondiscrim.GenerateConfig{
+		Package:     %q,
+		PackageName: %q,
+		Func:        %q,
+	}, %s, %s)
+	if err == nil {
+		err = os.WriteFile(%q, buf.Bytes(), 0o666)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+`, importPath, *typeFlag, importPath, pkgName, fn, fallback, strings.Join(choices, ", "), out)
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), prog.Bytes(), 0o666); err != nil {
+		return err
+	}
+	cmd := exec.Command("go", "run", "./"+filepath.ToSlash(dir))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("generator failed: %v", err)
+	}
+	return nil
+}
+
+// choiceExpr returns a Go expression for a nil value of the named
+// choice type, such as (*pkg.Dog)(nil) for *Dog and pkg.Dog{} for Dog.
+func choiceExpr(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	ptr := strings.HasPrefix(name, "*")
+	ident := strings.TrimPrefix(name, "*")
+	if !isIdentifier(ident) {
+		return "", fmt.Errorf("invalid type name %q", name)
+	}
+	if ptr {
+		return fmt.Sprintf("(*pkg.%s)(nil)", ident), nil
+	}
+	return fmt.Sprintf("pkg.%s{}", ident), nil
+}
+
+func isIdentifier(s string) bool {
+	for i, r := range s {
+		if !(r == '_' || unicode.IsLetter(r) || i > 0 && unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// exitError returns err with the standard error output
+// of the command included, if there is any.
+func exitError(err error) error {
+	if err, ok := err.(*exec.ExitError); ok && len(err.Stderr) > 0 {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(err.Stderr))
+	}
+	return err
+}
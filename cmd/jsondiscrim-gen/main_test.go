@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+)
+
+// zooSource holds a package defining a union, with a go:generate
+// directive that runs this command.
+const zooSource = `package zoo
+
+import "github.com/cue-exp/jsondiscrim"
+
+//go:generate go run github.com/cue-exp/jsondiscrim/cmd/jsondiscrim-gen -type Animal -choices *Dog,Cat -fallback *OtherAnimal
+
+type Animal interface {
+	isAnimal()
+}
+
+type Dog struct {
+	Kind jsondiscrim.Const[string, struct {
+		string ` + "`const:\"dog\"`" + `
+	}] ` + "`json:\"kind\"`" + `
+	Bark string ` + "`json:\"bark\"`" + `
+}
+
+func (*Dog) isAnimal() {}
+
+type Cat struct {
+	Kind jsondiscrim.Const[string, struct {
+		string ` + "`const:\"cat\"`" + `
+	}] ` + "`json:\"kind\"`" + `
+	Meow string ` + "`json:\"meow\"`" + `
+}
+
+func (Cat) isAnimal() {}
+
+type OtherAnimal struct {
+	Kind string ` + "`json:\"kind\"`" + `
+}
+
+func (*OtherAnimal) isAnimal() {}
+`
+
+// zooTestSource holds a test of the generated function.
+const zooTestSource = `package zoo
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-json-experiment/json"
+)
+
+func TestUnmarshalAnimal(t *testing.T) {
+	var got []Animal
+	err := json.Unmarshal([]byte(` + "`" + `[{"kind":"dog","bark":"woof"},{"meow":"purr","kind":"cat"},{"kind":"bird"}]` + "`" + `), &got,
+		json.WithUnmarshalers(json.UnmarshalFromFunc(unmarshalAnimal)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Animal{&Dog{Bark: "woof"}, Cat{Meow: "purr"}, &OtherAnimal{Kind: "bird"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+`
+
+func TestGoGenerate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test that runs the go command in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	root, err := filepath.Abs(filepath.Join("..", ".."))
+	qt.Assert(t, qt.IsNil(err))
+
+	// The module requires whatever this one does, with this
+	// module replaced by the source being tested.
+	goMod, err := os.ReadFile(filepath.Join(root, "go.mod"))
+	qt.Assert(t, qt.IsNil(err))
+	goMod = regexp.MustCompile(`(?m)^module .*$`).ReplaceAll(goMod, []byte("module example.com/zoo"))
+	goMod = append(goMod, "\nrequire github.com/cue-exp/jsondiscrim v0.0.0\n\nreplace github.com/cue-exp/jsondiscrim => "+root+"\n"...)
+	goSum, err := os.ReadFile(filepath.Join(root, "go.sum"))
+	qt.Assert(t, qt.IsNil(err))
+
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"go.mod":      goMod,
+		"go.sum":      goSum,
+		"zoo.go":      []byte(zooSource),
+		"zoo_test.go": []byte(zooTestSource),
+	} {
+		err := os.WriteFile(filepath.Join(dir, name), data, 0o666)
+		qt.Assert(t, qt.IsNil(err))
+	}
+
+	goCmd := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("go", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOEXPERIMENT=nojsonv2")
+		out, err := cmd.CombinedOutput()
+		qt.Assert(t, qt.IsNil(err), qt.Commentf("go %v:\n%s", args, out))
+	}
+	goCmd("generate", "./...")
+	src, err := os.ReadFile(filepath.Join(dir, "animal_unmarshal.go"))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.IsTrue(bytes.HasPrefix(src, []byte("// Code generated by jsondiscrim-gen. DO NOT EDIT."))))
+	qt.Assert(t, qt.StringContains(string(src), "func unmarshalAnimal(d *jsontext.Decoder, dst *Animal) error {"))
+
+	// The generated function behaves as expected.
+	goCmd("test", "./...")
+}
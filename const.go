@@ -1,16 +1,23 @@
 package jsondiscrim
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"reflect"
+	"slices"
+	"strconv"
 	"sync"
 
-	"github.com/go-json-experiment/json"
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
 )
 
 type constInfo[T any] struct {
 	valueType reflect.Type
 	value     T
+	// aliases holds the values given by any further const tags.
+	aliases []T
 }
 
 func (c *constInfo[T]) getValueType() reflect.Type {
@@ -30,9 +37,38 @@ func (c *constInfo[T]) getValueType() reflect.Type {
 //
 // represents the constant value "foo bar".
 //
+// For string constants, the tag value is used literally, so any white
+// space in it is significant. See [WithTrimDiscriminator] for a way
+// to tolerate white space in discriminator values in the JSON.
+//
+// For floating point constants, the tag value must be a finite JSON
+// number; NaN is not allowed because it is not equal to any value,
+// including itself, so it could never match. Negative zero is treated
+// as zero, so either matches both 0 and -0 in the JSON.
+//
+// For integer constants, the tag value may also use Go's hexadecimal,
+// octal or binary literal syntax (for example 0xff), or exponent
+// syntax (for example 1e3) as long as the value is integral.
+//
+// The tag may hold further "const" keys giving aliases, other values
+// that are accepted in place of the constant's value when unmarshaling
+// and that also select the choice when the Const field is used as a
+// discriminator. For example:
+//
+//	Const[string, struct{string `const:"dog" const:"hound"`}]
+//
+// represents the constant value "dog", which may also be given as
+// "hound".
+//
 // A Const value always marshals to JSON as the constant's value, and
 // when unmarshaling, requires the unmarshaled value to be equal to the
-// constant's value.
+// constant's value or one of its aliases. As a Const holds no data, a
+// value constructed in Go cannot hold the wrong constant, so marshaling
+// is always consistent with unmarshaling.
+//
+// A Const must not be embedded in a struct, as its methods would then
+// be promoted so that the whole struct marshals as the constant.
+// [Structs] panics if a choice embeds one.
 type Const[T comparable, S any] struct{}
 
 func (v Const[T, S]) MarshalJSON() ([]byte, error) {
@@ -40,43 +76,116 @@ func (v Const[T, S]) MarshalJSON() ([]byte, error) {
 }
 
 func (v *Const[T, S]) UnmarshalJSON(data []byte) error {
-	val := v.Value()
+	info := v.info()
+	if jsontext.Value(data).Kind() == '0' && isNumber(reflect.TypeFor[T]()) {
+		// Compare numbers by their canonical values, as is done
+		// when choosing the type, so that for example 1.0 and
+		// 1e0 are accepted for an integer constant 1.
+		got := parseNumber(string(data))
+		if got == canonicalValue(info.value) || slices.ContainsFunc(info.aliases, func(a T) bool {
+			return got == canonicalValue(a)
+		}) {
+			return nil
+		}
+		return info.unexpectedError(string(data))
+	}
 	var got T
 	if err := json.Unmarshal(data, &got); err != nil {
 		return err
 	}
-
-	if got != val {
-		return fmt.Errorf("unexpected const value; got %#v but want %#v", got, val)
+	if got != info.value && !slices.Contains(info.aliases, got) {
+		return info.unexpectedError(fmt.Sprintf("%#v", got))
 	}
 	return nil
 }
 
-var constByType sync.Map // typeCmp[S]{}-> *constInfo
+// unexpectedError returns the error for an unmarshaled value, given
+// in its printed form, that does not match the constant.
+func (c *constInfo[T]) unexpectedError(got string) error {
+	if len(c.aliases) > 0 {
+		return fmt.Errorf("unexpected const value; got %s but want one of %#v", got, append([]T{c.value}, c.aliases...))
+	}
+	return fmt.Errorf("unexpected const value; got %s but want %#v", got, c.value)
+}
+
+var constByType sync.Map // typeCmp[S]{} -> func() any returning *constInfo
 
 type typeCmp[T any] struct{}
 
 // Value returns the constant value for v.
 func (v Const[T, S]) Value() T {
+	return v.info().value
+}
+
+// info returns the resolved information for the constant, panicking
+// if its type arguments are not well formed.
+func (v Const[T, S]) info() *constInfo[T] {
 	structType := typeCmp[S]{}
-	// Ensure we only do the reflection work once.
-	info0, ok := constByType.Load(structType)
+	// Ensure we only do the reflection work once, even when
+	// many goroutines use the constant for the first time at once.
+	get, ok := constByType.Load(structType)
 	if !ok {
-		constByType.LoadOrStore(structType, v.makeConstInfo())
-		return v.Value()
+		get, _ = constByType.LoadOrStore(structType, sync.OnceValue(func() any {
+			return v.makeConstInfo()
+		}))
 	}
+	info0 := get.(func() any)()
 	info, ok := info0.(*constInfo[T])
 	if !ok {
 		info := info0.(interface{ getValueType() reflect.Type })
 		panic(fmt.Errorf("struct field type %v does not agree with type parameter %v", info.getValueType(), reflect.TypeFor[T]()))
 	}
-	return info.value
+	return info
+}
+
+// MustConst returns the zero value of Const[T, S] after checking that
+// its type arguments are well formed, panicking if not. Normally this
+// check happens when the constant is first used, but calling MustConst
+// at package initialization ensures that mistakes are caught as soon
+// as the program starts:
+//
+//	type dogKind = jsondiscrim.Const[string, struct {
+//		string `const:"dog"`
+//	}]
+//
+//	var _ = jsondiscrim.MustConst[string, struct {
+//		string `const:"dog"`
+//	}]()
+func MustConst[T comparable, S any]() Const[T, S] {
+	var c Const[T, S]
+	c.Value()
+	return c
+}
+
+// ConstInfo returns the value of Const[T, S] as resolved from its
+// const tag, along with its type. It is intended to help with
+// debugging a constant that does not match as expected, by confirming
+// that its tag has been parsed as intended. For example:
+//
+//	v, typ := jsondiscrim.ConstInfo[int, struct {
+//		int `const:"0x2a"`
+//	}]()
+//	fmt.Printf("%#v %v\n", v, typ) // 42 int
+//
+// Like [MustConst], it panics if the type arguments are not well
+// formed.
+func ConstInfo[T comparable, S any]() (value T, typ reflect.Type) {
+	info := Const[T, S]{}.info()
+	return info.value, info.valueType
 }
 
 func (v Const[T, S]) constValue() any {
 	return v.Value()
 }
 
+func (v Const[T, S]) constAliases() []any {
+	var aliases []any
+	for _, a := range v.info().aliases {
+		aliases = append(aliases, a)
+	}
+	return aliases
+}
+
 func (Const[T, S]) makeConstInfo() *constInfo[T] {
 	t := reflect.TypeFor[S]()
 	if t.Kind() != reflect.Struct {
@@ -88,22 +197,191 @@ func (Const[T, S]) makeConstInfo() *constInfo[T] {
 	if t.Field(0).Type != reflect.TypeFor[T]() {
 		panic(fmt.Errorf("struct field type does not agree with type parameter"))
 	}
-	jsonVal, ok := t.Field(0).Tag.Lookup("const")
-	if !ok {
+	tags := tagValues(t.Field(0).Tag, "const")
+	if len(tags) == 0 {
 		panic(fmt.Errorf("const type argument field has no const tag"))
 	}
+	info := &constInfo[T]{
+		valueType: reflect.TypeFor[T](),
+		value:     parseConstTag[T](tags[0]),
+	}
+	for _, tag := range tags[1:] {
+		alias := parseConstTag[T](tag)
+		if alias == info.value || slices.Contains(info.aliases, alias) {
+			panic(fmt.Errorf("const tag value %q for %v is given more than once", tag, info.valueType))
+		}
+		info.aliases = append(info.aliases, alias)
+	}
+	return info
+}
 
+// parseConstTag returns the value of the const tag value jsonVal,
+// panicking if it is not valid for T.
+func parseConstTag[T comparable](jsonVal string) T {
 	var constVal T
 	constValv := reflect.ValueOf(&constVal).Elem()
-	if constValv.Kind() == reflect.String {
+	switch constValv.Kind() {
+	case reflect.String:
 		constValv.SetString(jsonVal)
-	} else {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := parseIntTag(jsonVal, constValv.Type().Bits())
+		if err != nil {
+			panic(intTagError(constValv.Type(), jsonVal, err))
+		}
+		constValv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := parseUintTag(jsonVal, constValv.Type().Bits())
+		if err != nil {
+			panic(intTagError(constValv.Type(), jsonVal, err))
+		}
+		constValv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
 		if err := json.Unmarshal([]byte(jsonVal), &constVal); err != nil {
-			panic(fmt.Errorf("malformed const struct field tag %q", jsonVal))
+			if f, err := strconv.ParseFloat(jsonVal, 64); err == nil && (math.IsNaN(f) || math.IsInf(f, 0)) {
+				panic(fmt.Errorf("float const tag for %v must be finite, got %q", constValv.Type(), jsonVal))
+			}
+			panic(constTagError(constValv.Type(), jsonVal))
+		}
+		switch f := constValv.Float(); {
+		case math.IsNaN(f) || math.IsInf(f, 0):
+			panic(fmt.Errorf("float const tag for %v must be finite, got %q", constValv.Type(), jsonVal))
+		case f == 0:
+			// Use positive zero so that the constant
+			// marshals as 0 rather than -0.
+			constValv.SetFloat(0)
 		}
+	default:
+		if err := json.Unmarshal([]byte(jsonVal), &constVal); err != nil {
+			panic(constTagError(constValv.Type(), jsonVal))
+		}
+	}
+	return constVal
+}
+
+// tagValues returns the values of every occurrence of key in tag,
+// which is parsed in the conventional format described by
+// [reflect.StructTag], which itself only finds the first.
+func tagValues(tag reflect.StructTag, key string) []string {
+	var values []string
+	for tag != "" {
+		// Skip leading space.
+		i := 0
+		for i < len(tag) && tag[i] == ' ' {
+			i++
+		}
+		tag = tag[i:]
+		if tag == "" {
+			break
+		}
+		// Scan to the colon. A space, a quote or a control
+		// character is a syntax error.
+		i = 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			break
+		}
+		name := string(tag[:i])
+		tag = tag[i+1:]
+		// Scan the quoted string to find the value.
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			break
+		}
+		qvalue := string(tag[:i+1])
+		tag = tag[i+1:]
+		if name == key {
+			value, err := strconv.Unquote(qvalue)
+			if err != nil {
+				break
+			}
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+var errNotIntegral = errors.New("not integral")
+
+// parseIntTag parses the const tag value tag for a signed integer type
+// with the given number of bits. As well as decimal, it accepts Go
+// integer literal syntax such as 0xff, 0o17 and 0b101, and exponent
+// syntax such as 1e3 when the value is integral.
+func parseIntTag(tag string, bits int) (int64, error) {
+	i, err := strconv.ParseInt(tag, 0, bits)
+	if err == nil || errors.Is(err, strconv.ErrRange) {
+		return i, err
+	}
+	f, err := parseIntegralFloat(tag)
+	if err != nil {
+		return 0, err
 	}
-	return &constInfo[T]{
-		valueType: constValv.Type(),
-		value:     constVal,
+	if limit := math.Ldexp(1, bits-1); f < -limit || f >= limit {
+		return 0, strconv.ErrRange
+	}
+	return int64(f), nil
+}
+
+// parseUintTag is like [parseIntTag] for unsigned integer types.
+func parseUintTag(tag string, bits int) (uint64, error) {
+	u, err := strconv.ParseUint(tag, 0, bits)
+	if err == nil || errors.Is(err, strconv.ErrRange) {
+		return u, err
+	}
+	f, err := parseIntegralFloat(tag)
+	if err != nil {
+		return 0, err
+	}
+	if f < 0 || f >= math.Ldexp(1, bits) {
+		return 0, strconv.ErrRange
+	}
+	return uint64(f), nil
+}
+
+// parseIntegralFloat parses tag as a JSON number, returning
+// errNotIntegral if it has a fractional part.
+func parseIntegralFloat(tag string) (float64, error) {
+	if !jsontext.Value(tag).IsValid() || jsontext.Value(tag).Kind() != '0' {
+		return 0, strconv.ErrSyntax
+	}
+	f, err := strconv.ParseFloat(tag, 64)
+	if err != nil {
+		return 0, err
+	}
+	if f != math.Trunc(f) {
+		return 0, errNotIntegral
+	}
+	return f, nil
+}
+
+// intTagError returns the error for an integer const tag value tag for
+// type t that failed to parse with err.
+func intTagError(t reflect.Type, tag string, err error) error {
+	if errors.Is(err, errNotIntegral) {
+		return fmt.Errorf("integer const tag for %v must be integral, got %q", t, tag)
+	}
+	return constTagError(t, tag)
+}
+
+// constTagError returns an error describing why the const tag value
+// tag is not valid for a constant of type t.
+func constTagError(t reflect.Type, tag string) error {
+	switch t.Kind() {
+	case reflect.Bool:
+		return fmt.Errorf("bool const tag must be \"true\" or \"false\", got %q", tag)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fmt.Errorf("integer const tag for %v must be an integer in range, got %q", t, tag)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return fmt.Errorf("unsigned integer const tag for %v must be a non-negative integer in range, got %q", t, tag)
+	case reflect.Float32, reflect.Float64:
+		return fmt.Errorf("float const tag for %v must be a JSON number, got %q", t, tag)
 	}
+	return fmt.Errorf("malformed const struct field tag %q", tag)
 }
@@ -4,14 +4,17 @@ package jsondiscrim
 
 import (
 	"bytes"
+	"cmp"
+	"errors"
 	"fmt"
 	"maps"
 	"reflect"
 	"slices"
 	"strings"
+	"sync"
 
-	"github.com/go-json-experiment/json"
-	"github.com/go-json-experiment/json/jsontext"
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
 )
 
 // Structs returns an unmarshaler that unmarshals the given type T
@@ -24,69 +27,1039 @@ import (
 // with a different constant value for each choice. The value of that
 // field is then inspected at unmarshal time to determine which actual
 // type to unmarshal into.
+//
+// It is an error for two choices to have the same type. A type alias,
+// declared as in type B = A, denotes the same type as A, so A and B
+// cannot both be choices. A defined type, declared as in type B A, is
+// distinct from A even though it has the same fields, so both can be
+// choices as long as they have different discriminator values, for
+// example by using [WithTypeNameDiscriminator] or [WithLiteral].
+//
+// T may be a narrower interface than that implemented by other unions
+// sharing some of the same choices. The resulting unmarshalers can be
+// combined with [json.JoinUnmarshalers], in which case each is used
+// for values of its own interface type.
+//
+// Choices, not just the fallback, may have a field with the
+// `json:",unknown"` option (`json:",embed"` with encoding/json/v2) to
+// capture any members of the object that they do not otherwise
+// declare. The discriminator member is not among
+// them, as it is consumed by the Const field.
+//
+// The unmarshalers apply wherever a value of type T is unmarshaled,
+// including within slices and as map values. As JSON object member
+// names are always strings, a map with key type T cannot be
+// unmarshaled using them.
+//
+// The json tag of the discriminator field may hold the case:ignore
+// option, in which case string discriminator values in the JSON match
+// regardless of case, as with [WithUnicodeFold]. It must then be held
+// by the discriminator fields of all the choices. The member name
+// itself is always matched exactly. No other json tag options affect
+// how the discriminator is found or matched.
+//
+// Objects are unmarshaled directly from the decoder when their
+// discriminator member is near the start and already buffered, as it
+// will be when it is the first member. Otherwise, each object is read
+// in full before being unmarshaled, so for large objects it is best
+// to put the discriminator first.
+//
+// There is no fallback choice unless one has been registered for T
+// with [RegisterFallback].
 func Structs[T any](choices ...T) *json.Unmarshalers {
-	return StructsWithFallback(*new(T), choices...)
+	return StructsWithFallback(registeredFallback[T](), choices...)
 }
 
 // StructsWithFallback is like [Structs] except that the concrete type
 // of the first argument is used as a fallback choice for unmarshaling
 // when none of the other choices apply.
 func StructsWithFallback[T any](fallback T, choices ...T) *json.Unmarshalers {
-	if t := reflect.TypeFor[T](); t.Kind() != reflect.Interface {
-		panic(fmt.Errorf("type %v is not an interface type", t))
+	return StructsWithOptions(nil, fallback, choices...)
+}
+
+// StructsWithOptions is like [StructsWithFallback] except that it also
+// takes options that modify the behavior of the returned unmarshalers.
+// The fallback may be nil, in which case there is no fallback choice.
+func StructsWithOptions[T any](opts []Option, fallback T, choices ...T) *json.Unmarshalers {
+	ifaceType := reflect.TypeFor[T]()
+	if ifaceType.Kind() != reflect.Interface {
+		panic(fmt.Errorf("type %v is not an interface type", ifaceType))
+	}
+	u, err := newUnion(applyOptions(opts), ifaceType, fallback, choices)
+	if err != nil {
+		panic(err)
+	}
+	return unionUnmarshalers[T](u)
+}
+
+// StructsWithField is like [Structs] except that the discriminator is
+// the [Const] field with the given JSON name, as for
+// [WithDiscriminatorField], rather than being determined
+// automatically. This is needed when the choices have several Const
+// fields with a different value in every choice. The other Const
+// fields are then not considered, but the given field must still hold
+// a different value in every choice.
+func StructsWithField[T any](field string, choices ...T) *json.Unmarshalers {
+	return StructsWithOptions([]Option{WithDiscriminatorField(field)}, registeredFallback[T](), choices...)
+}
+
+// Values is like [Structs] except that the choices are given by mapping
+// each value of the discriminator field with the given JSON name to a
+// choice of the type to unmarshal into when the field holds that
+// value, as for [WithLiteral]. This lets types that cannot be changed,
+// such as generated ones, take part in a union without [Const] fields.
+// A choice may appear for several values. For example:
+//
+//	jsondiscrim.Values("kind", map[any]Animal{
+//		"dog":   (*Dog)(nil),
+//		"hound": (*Dog)(nil),
+//		"cat":   (*Cat)(nil),
+//	})
+//
+// Like [Structs], it panics if the mapping is not well formed.
+func Values[T any](field string, mapping map[any]T) *json.Unmarshalers {
+	if field == "" {
+		panic("empty field provided to Values")
+	}
+	opts := []Option{WithDiscriminatorField(field)}
+	// Add the choices in a fixed order so that
+	// any error is deterministic.
+	for _, v := range slices.SortedFunc(maps.Keys(mapping), compareValues) {
+		if isNil(mapping[v]) {
+			panic(fmt.Errorf("nil choice provided to Values for value %#v", v))
+		}
+		opts = append(opts, WithVariantValue(mapping[v], v))
+	}
+	return StructsWithOptions(opts, registeredFallback[T]())
+}
+
+// StructsFunc is like [Structs] except that the choices are obtained
+// by calling provide when the first value is unmarshaled rather than
+// when StructsFunc is called. This allows the unmarshalers to be
+// created during package initialization even when referring to the
+// choice types directly would create an import cycle.
+//
+// The function is called only once, even when the unmarshalers are
+// used concurrently. Rather than panicking, StructsFunc reports any
+// problem with the choices it returns as an error from each unmarshal.
+func StructsFunc[T any](provide func() []T) *json.Unmarshalers {
+	ifaceType := reflect.TypeFor[T]()
+	if ifaceType.Kind() != reflect.Interface {
+		panic(fmt.Errorf("type %v is not an interface type", ifaceType))
+	}
+	if provide == nil {
+		panic("nil function provided to StructsFunc")
+	}
+	getUnion := sync.OnceValues(func() (*union, error) {
+		return newUnion(&options{}, ifaceType, registeredFallback[T](), provide())
+	})
+	return json.UnmarshalFromFunc(func(d *jsontext.Decoder, dst *T) error {
+		u, err := getUnion()
+		if err != nil {
+			return err
+		}
+		v, err := u.unmarshal(d)
+		if err != nil {
+			return err
+		}
+		reflect.ValueOf(dst).Elem().Set(v)
+		return nil
+	})
+}
+
+// unionUnmarshalers returns unmarshalers for values of type T
+// that use u.
+func unionUnmarshalers[T any](u *union) *json.Unmarshalers {
+	return json.UnmarshalFromFunc(func(d *jsontext.Decoder, dst *T) error {
+		v, err := u.unmarshal(d)
+		if err != nil {
+			return err
+		}
+		reflect.ValueOf(dst).Elem().Set(v)
+		return nil
+	})
+}
+
+// union holds the information needed to unmarshal a value of a
+// discriminated union.
+type union struct {
+	o *options
+	// typ holds the type being unmarshaled into.
+	typ            reflect.Type
+	fallbackType   reflect.Type
+	discrimField   string
+	discrimByValue map[any]reflect.Type
+	// envelope holds the names of the members allowed
+	// by [WithEnvelopeSchema].
+	envelope map[string]bool
+	// variantUnmarshalers holds the functions added by
+	// [WithVariantUnmarshaler], keyed by discriminator value.
+	variantUnmarshalers map[any]func(jsontext.Value, any) error
+	// deprecated holds the struct types of the deprecated choices.
+	deprecated map[reflect.Type]bool
+	// blocked holds the values given to [WithBlockedValues].
+	blocked map[any]bool
+	// discrimAliases holds the other names under which the
+	// discriminator field is found, as for [WithGoFieldNameAlias].
+	discrimAliases []string
+	// lacksDiscrim holds the struct types of the choices that have
+	// no field for the discriminator member, which must be removed
+	// before unmarshaling into them when unknown members are rejected.
+	lacksDiscrim map[reflect.Type]bool
+}
+
+func newUnion[T any](o *options, typ reflect.Type, fallback T, choices []T) (*union, error) {
+	if o.keyFields != nil && o.discrimPath != nil {
+		return nil, fmt.Errorf("WithCompositeKey and WithDiscriminatorPath cannot be used together")
+	}
+	u := &union{
+		o:   o,
+		typ: typ,
 	}
-	var fallbackType reflect.Type
+	o.innerSyntactic = hasDecodeOptions(o.innerOpts)
 	if !isNil(fallback) {
-		fallbackType = reflect.TypeOf(fallback)
-	} else if len(choices) == 0 {
-		panic("no choices provided to Structs")
+		u.fallbackType = reflect.TypeOf(fallback)
+	} else if len(choices) == 0 && len(o.extraChoices()) == 0 {
+		return nil, fmt.Errorf("no choices provided to Structs")
 	}
-	var discrimField string
-	var discrimByValue map[any]reflect.Type
-	if len(choices) > 0 {
+	firstIndex := make(map[reflect.Type]int)
+	for i, choice := range choices {
+		if isNil(choice) {
+			continue
+		}
+		t := reflect.TypeOf(choice)
+		if j, ok := firstIndex[t]; ok {
+			return nil, fmt.Errorf("choices %d and %d have the same type %v", j, i, t)
+		}
+		firstIndex[t] = i
+	}
+	for k, t := range o.kinds {
+		if t == nil {
+			return nil, fmt.Errorf("WithKindDiscriminator type for JSON %s is nil", kindName(k))
+		}
+	}
+	for _, t := range o.extraChoices() {
+		if !t.AssignableTo(typ) && typ.Kind() == reflect.Interface {
+			return nil, fmt.Errorf("choice %v does not implement %v", t, typ)
+		}
+	}
+	if len(choices) > 0 || len(o.literals) > 0 {
 		var err error
-		discrimField, discrimByValue, err = Discriminator(choices...)
+		u.discrimField, u.discrimByValue, _, err = discriminator(o, choices)
+		if err != nil {
+			return nil, err
+		}
+		// Look up values by their canonical form, so that numbers
+		// in the JSON match regardless of the type of Const field.
+		byValue := make(map[any]reflect.Type)
+		for v, t := range u.discrimByValue {
+			cv := canonicalValue(v)
+			if t1, ok := byValue[cv]; ok && t1 != t {
+				return nil, fmt.Errorf("discriminator value %v of %v is already used by %v", cv, t, t1)
+			}
+			byValue[cv] = t
+		}
+		u.discrimByValue = byValue
+		if t := u.discrimByValue[""]; t != nil && o.emptyAsMissing {
+			return nil, fmt.Errorf("choice %v has empty discriminator value, which cannot be used with WithEmptyAsMissing", t)
+		}
+	}
+	if (o.missingType != nil || o.unknownType != nil) && u.discrimField == "" {
+		return nil, fmt.Errorf("WithMissingVariant and WithUnknownVariant require a discriminator field")
+	}
+	fold := o.unicodeFold
+	if u.discrimField != "" && o.keyFields == nil && o.discrimPath == nil {
+		ignore, err := caseIgnored(choices, u.discrimField, o.mapFieldName)
+		if err != nil {
+			return nil, err
+		}
+		fold = fold || ignore
+	}
+	if fold && o.keyFields == nil {
+		if err := u.foldValues(); err != nil {
+			return nil, err
+		}
+	}
+	if o.lenientNumbers && o.keyFields == nil {
+		o.discrimValues = u.discrimByValue
+	}
+	if o.defaultValue != nil {
+		if u.discrimField == "" {
+			return nil, fmt.Errorf("WithDefaultDiscriminator requires a discriminator field")
+		}
+		if u.discrimByValue[o.defaultValue.value] == nil {
+			return nil, fmt.Errorf("WithDefaultDiscriminator value %#v is not a discriminator value", o.defaultValue.value)
+		}
+	}
+	for _, v := range o.blocked {
+		if err := checkScalar(v, false); err != nil {
+			return nil, fmt.Errorf("blocked value %#v is not a scalar", v)
+		}
+		v = canonicalValue(v)
+		if t := u.discrimByValue[v]; t != nil {
+			return nil, fmt.Errorf("blocked value %#v is the discriminator value of %v", v, t)
+		}
+		if u.blocked == nil {
+			u.blocked = make(map[any]bool)
+		}
+		u.blocked[v] = true
+	}
+	for _, vu := range o.variantUnmarshalers {
+		v := canonicalValue(vu.value)
+		if u.discrimByValue[v] == nil {
+			return nil, fmt.Errorf("WithVariantUnmarshaler value %#v is not a discriminator value", vu.value)
+		}
+		if u.variantUnmarshalers == nil {
+			u.variantUnmarshalers = make(map[any]func(jsontext.Value, any) error)
+		}
+		u.variantUnmarshalers[v] = vu.unmarshal
+	}
+	types := o.extraChoices()
+	if u.fallbackType != nil {
+		types = append(types, u.fallbackType)
+	}
+	for _, choice := range choices {
+		if !isNil(choice) {
+			types = append(types, reflect.TypeOf(choice))
+		}
+	}
+	if o.goNameAlias && u.discrimField != "" && o.keyFields == nil && o.discrimPath == nil {
+		u.discrimAliases = goFieldNames(types, u.discrimField, o.mapFieldName)
+	}
+	if u.discrimField != "" && o.keyFields == nil && o.discrimPath == nil && o.bodyField == "" {
+		for _, t := range types {
+			if !acceptsMember(t, u.discrimField) {
+				if u.lacksDiscrim == nil {
+					u.lacksDiscrim = make(map[reflect.Type]bool)
+				}
+				u.lacksDiscrim[structType(t)] = true
+			}
+		}
+	}
+	if o.envelope != nil {
+		u.envelope = u.envelopeMembers(types)
+	}
+	deprecated, err := deprecatedChoices(o, types)
+	if err != nil {
+		return nil, err
+	}
+	u.deprecated = deprecated
+	if err := u.checkFallback(); err != nil {
+		return nil, err
+	}
+	if o.results != registeredResults {
+		if err := u.convertResults(); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// foldValues fills in u.o.foldedValues for [WithUnicodeFold].
+func (u *union) foldValues() error {
+	var values []string
+	for v := range u.discrimByValue {
+		if s, ok := v.(string); ok {
+			values = append(values, s)
+		}
+	}
+	// Sort the values so that the error is deterministic.
+	slices.Sort(values)
+	u.o.foldedValues = make(map[string]string)
+	for _, s := range values {
+		folded := foldString(s)
+		if s1, ok := u.o.foldedValues[folded]; ok {
+			return fmt.Errorf("discriminator values %q of %v and %q of %v are equal under Unicode case folding", s1, u.discrimByValue[s1], s, u.discrimByValue[s])
+		}
+		u.o.foldedValues[folded] = s
+	}
+	return nil
+}
+
+// caseIgnored reports whether the [Const] fields with the given JSON
+// name in the choices have the case:ignore option in their json tags.
+// It returns an error if only some of them do.
+func caseIgnored[T any](choices []T, jsonName string, mapName func(string) string) (bool, error) {
+	var with, without reflect.Type
+	for _, choice := range choices {
+		if isNil(choice) {
+			continue
+		}
+		t := reflect.TypeOf(choice)
+		for _, f := range reflect.VisibleFields(structType(t)) {
+			if f.PkgPath != "" || !isConst(f.Type) {
+				continue
+			}
+			name := jsonFieldName(f)
+			if mapName != nil && !hasJSONName(f) {
+				name = mapName(f.Name)
+			}
+			if name != jsonName {
+				continue
+			}
+			_, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if slices.Contains(strings.Split(opts, ","), "case:ignore") {
+				with = cmp.Or(with, t)
+			} else {
+				without = cmp.Or(without, t)
+			}
+		}
+	}
+	if with != nil && without != nil {
+		return false, fmt.Errorf("discriminator field %q has the case:ignore option in %v but not in %v", jsonName, with, without)
+	}
+	return with != nil, nil
+}
+
+// goFieldNames returns the Go names, other than jsonName itself, of the
+// [Const] fields with the given JSON name in any of the given types.
+func goFieldNames(types []reflect.Type, jsonName string, mapName func(string) string) []string {
+	var names []string
+	for _, t := range types {
+		t = structType(t)
+		if t.Kind() != reflect.Struct {
+			continue
+		}
+		for _, f := range reflect.VisibleFields(t) {
+			if f.PkgPath != "" || f.Anonymous || !isConst(f.Type) {
+				continue
+			}
+			name := jsonFieldName(f)
+			if mapName != nil && !hasJSONName(f) {
+				name = mapName(f.Name)
+			}
+			if name == jsonName && f.Name != jsonName && !slices.Contains(names, f.Name) {
+				names = append(names, f.Name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// deprecatedChoices returns the struct types of those of the choice
+// types that are deprecated by a struct tag or by [WithDeprecated].
+func deprecatedChoices(o *options, types []reflect.Type) (map[reflect.Type]bool, error) {
+	deprecated := make(map[reflect.Type]bool)
+	isChoice := make(map[reflect.Type]bool)
+	for _, t := range types {
+		t = structType(t)
+		isChoice[t] = true
+		if t.Kind() != reflect.Struct {
+			continue
+		}
+		for _, f := range reflect.VisibleFields(t) {
+			tag, ok := f.Tag.Lookup("jsondiscrim")
+			if !ok || f.PkgPath != "" || !isConst(f.Type) {
+				continue
+			}
+			if tag != "deprecated" {
+				return nil, fmt.Errorf("invalid jsondiscrim tag %q on field %s of %v", tag, f.Name, t)
+			}
+			deprecated[t] = true
+		}
+	}
+	for _, t := range o.deprecated {
+		if !isChoice[structType(t)] {
+			return nil, fmt.Errorf("WithDeprecated type %v is not a choice", t)
+		}
+		deprecated[structType(t)] = true
+	}
+	return deprecated, nil
+}
+
+// structType returns t, or the type it points to if it is a pointer.
+func structType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Pointer {
+		return t.Elem()
+	}
+	return t
+}
+
+// isConst reports whether t is an instance of [Const].
+func isConst(t reflect.Type) bool {
+	_, ok := reflect.Zero(t).Interface().(interface {
+		constValue() any
+	})
+	return ok
+}
+
+// envelopeMembers returns the names of the members allowed in an
+// object by [WithEnvelopeSchema] for a union with choices of the
+// given types.
+func (u *union) envelopeMembers(types []reflect.Type) map[string]bool {
+	o := u.o
+	members := make(map[string]bool)
+	for _, name := range o.envelope {
+		members[name] = true
+	}
+	for _, name := range append(append([]string{u.discrimField, o.bodyField}, o.keyFields...), o.discrimPath...) {
+		members[name] = true
+	}
+	for _, t := range types {
+		names, _ := jsonMembers(t)
+		for _, name := range names {
+			members[name] = true
+		}
+	}
+	delete(members, "")
+	return members
+}
+
+// checkEnvelope checks that the JSON object data holds only members
+// allowed by [WithEnvelopeSchema].
+func (u *union) checkEnvelope(data jsontext.Value) error {
+	d := jsontext.NewDecoder(bytes.NewReader(data))
+	if _, err := d.ReadToken(); err != nil {
+		return err
+	}
+	for d.PeekKind() != '}' {
+		name, err := d.ReadToken()
 		if err != nil {
-			panic(err)
+			return err
+		}
+		if !u.envelope[name.String()] {
+			return fmt.Errorf("unknown member %q not declared by any choice", name.String())
+		}
+		if err := d.SkipValue(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFallback checks that the fallback type does not hold a
+// discriminator value of one of the choices, in which case it could
+// never be used for that value.
+func (u *union) checkFallback() error {
+	if u.fallbackType == nil || u.discrimField == "" {
+		return nil
+	}
+	t := u.fallbackType
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	v, ok := mappedConstFields(u.fallbackType, u.o.mapFieldName)[u.discrimField]
+	if !ok {
+		return nil
+	}
+	if choice := u.discrimByValue[canonicalValue(v)]; choice != nil && choice != u.fallbackType {
+		return fmt.Errorf("fallback type %v declares discriminator value %#v which collides with choice %v", u.fallbackType, v, choice)
+	}
+	return nil
+}
+
+// convertResults converts the types of all the choices to the form
+// requested by [WithValueResults] or [WithPointerResults].
+func (u *union) convertResults() error {
+	convert := func(t reflect.Type) (reflect.Type, error) {
+		if t == nil {
+			return nil, nil
+		}
+		switch {
+		case u.o.results == valueResults && t.Kind() == reflect.Pointer:
+			t = t.Elem()
+		case u.o.results == pointerResults && t.Kind() != reflect.Pointer:
+			t = reflect.PointerTo(t)
+		}
+		if u.typ.Kind() == reflect.Interface && !t.AssignableTo(u.typ) {
+			return nil, fmt.Errorf("result type %v does not implement %v", t, u.typ)
+		}
+		return t, nil
+	}
+	var err error
+	if u.fallbackType, err = convert(u.fallbackType); err != nil {
+		return err
+	}
+	for v, t := range u.discrimByValue {
+		if u.discrimByValue[v], err = convert(t); err != nil {
+			return err
+		}
+	}
+	for i, m := range u.o.matchers {
+		if u.o.matchers[i].typ, err = convert(m.typ); err != nil {
+			return err
+		}
+	}
+	if u.o.missingType, err = convert(u.o.missingType); err != nil {
+		return err
+	}
+	if u.o.unknownType, err = convert(u.o.unknownType); err != nil {
+		return err
+	}
+	if u.o.kinds != nil {
+		kinds := make(map[jsontext.Kind]reflect.Type)
+		for k, t := range u.o.kinds {
+			if kinds[k], err = convert(t); err != nil {
+				return err
+			}
+		}
+		u.o.kinds = kinds
+	}
+	return nil
+}
+
+// unmarshal unmarshals the next value from d, returning a value
+// of the chosen concrete type.
+func (u *union) unmarshal(d *jsontext.Decoder) (reflect.Value, error) {
+	return u.unmarshalDiscrim(d, new(any))
+}
+
+// unmarshalDiscrim is like [union.unmarshal] except that it also
+// stores in *discrim the canonical discriminator value that selected
+// the type, if any.
+func (u *union) unmarshalDiscrim(d *jsontext.Decoder, discrim *any) (reflect.Value, error) {
+	if n := u.o.maxElements; n > 0 {
+		if k, length := d.StackIndex(d.StackDepth()); k == '[' && length >= int64(n) {
+			return reflect.Value{}, fmt.Errorf("array has more than %d elements", n)
+		}
+	}
+	v, err := u.unmarshalValue(d, discrim)
+	var limitErr *ReadLimitError
+	if errors.As(err, &limitErr) {
+		// Return the error itself rather than the I/O error
+		// wrapping it, so that it is reported with the
+		// position of the union value.
+		return reflect.Value{}, limitErr
+	}
+	if err == nil && u.o.onDeprecated != nil && u.deprecated[structType(v.Type())] {
+		u.o.onDeprecated(v.Type())
+	}
+	return v, err
+}
+
+// unmarshalValue is like [union.unmarshalDiscrim] except that it does
+// not report the use of deprecated choices.
+func (u *union) unmarshalValue(d *jsontext.Decoder, discrim *any) (reflect.Value, error) {
+	o := u.o
+	if o.kinds != nil {
+		k := d.PeekKind()
+		if t := o.kindType(k); t != nil {
+			dst := reflect.New(t)
+			if err := json.UnmarshalDecode(d, dst.Interface()); err != nil {
+				return reflect.Value{}, err
+			}
+			return dst.Elem(), nil
+		}
+		objectChoices := u.discrimField != "" || len(o.matchers) > 0
+		if u.fallbackType == nil && (k != '{' || !objectChoices) {
+			return reflect.Value{}, &KindError{Type: u.typ, Kind: k}
 		}
 	}
-	if discrimField == "" {
+	if u.discrimField == "" && len(o.matchers) == 0 && !o.innerSyntactic && u.envelope == nil && o.decodeHook == nil && !o.emptyObjectAsNil {
 		// No discriminator but we do have a fallback.
 		// In this case, we don't have to buffer the value
 		// and can just do the simple direct unmarshal.
-		return json.UnmarshalFromFunc(func(d *jsontext.Decoder, src *T) error {
-			dst := reflect.New(fallbackType)
-			if err := json.UnmarshalDecode(d, dst.Interface()); err != nil {
-				return err
-			}
-			reflect.ValueOf(src).Elem().Set(dst.Elem())
-			return nil
-		})
+		if k := d.PeekKind(); k != '{' && o.objectRequired {
+			return reflect.Value{}, &NotObjectError{Type: u.typ, Kind: k}
+		}
+		dst := reflect.New(u.fallbackType)
+		if err := json.UnmarshalDecode(d, dst.Interface(), o.innerOpts...); err != nil {
+			return reflect.Value{}, err
+		}
+		return dst.Elem(), nil
 	}
-	return json.UnmarshalFromFunc(func(d *jsontext.Decoder, src *T) error {
-		raw, err := d.ReadValue()
+	if t, v := u.peekType(d); t != nil {
+		// We know the type already, so we can unmarshal
+		// directly without buffering the value.
+		*discrim = v
+		dst := reflect.New(t)
+		if err := json.UnmarshalDecode(d, dst.Interface(), o.innerOpts...); err != nil {
+			return reflect.Value{}, err
+		}
+		return dst.Elem(), nil
+	}
+	raw, err := d.ReadValue()
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	// Remember where the value is so that errors from unmarshaling
+	// the buffered value can be reported relative to the whole input.
+	start, ptr := d.InputOffset()-int64(len(raw)), d.StackPointer()
+	if o.decodeHook != nil {
+		if raw, err = o.decodeHook(raw); err != nil {
+			return reflect.Value{}, err
+		}
+	}
+	if o.emptyObjectAsNil && isEmptyObject(raw) {
+		return reflect.Zero(u.typ), nil
+	}
+	dstType := u.fallbackType
+	var variantFn func(jsontext.Value, any) error
+	if raw.Kind() == '"' && o.bareString && u.discrimField != "" && o.keyFields == nil && o.discrimPath == nil {
+		// Treat the string as shorthand for an object holding
+		// only the discriminator field.
+		raw, err = json.Marshal(map[string]jsontext.Value{u.discrimField: raw})
 		if err != nil {
-			return err
+			return reflect.Value{}, err
+		}
+	}
+	if k := raw.Kind(); k != '{' {
+		if u.fallbackType == nil || o.objectRequired {
+			return reflect.Value{}, &NotObjectError{Type: u.typ, Kind: k}
+		}
+	} else {
+		if u.envelope != nil {
+			if err := u.checkEnvelope(raw); err != nil {
+				return reflect.Value{}, err
+			}
+		}
+		if o.discrimFirst && u.discrimField != "" && o.keyFields == nil && o.discrimPath == nil {
+			if err := u.checkFirst(raw); err != nil {
+				return reflect.Value{}, err
+			}
+		}
+		var discrimValue any
+		var normalized bool
+		if u.discrimField != "" {
+			discrimValue, normalized, err = o.discrimValue(raw, u.discrimField)
+		} else {
+			err = &MissingFieldError{}
+		}
+		for _, alias := range u.discrimAliases {
+			if !errors.As(err, new(*MissingFieldError)) {
+				break
+			}
+			var err1 error
+			discrimValue, normalized, err1 = o.discrimValue(raw, alias)
+			if errors.As(err1, new(*MissingFieldError)) {
+				continue
+			}
+			err = err1
+			if err == nil {
+				// Rename the member so that the Const field
+				// checks its value as usual.
+				raw, err = renameField(raw, alias, u.discrimField)
+			}
+		}
+		if o.strictField && o.keyFields == nil && o.discrimPath == nil && errors.As(err, new(*MissingFieldError)) {
+			name, err1 := misspelledField(raw, u.discrimField)
+			if err1 != nil {
+				return reflect.Value{}, err1
+			}
+			if name != "" {
+				return reflect.Value{}, fmt.Errorf("discriminator field %q not found but %q is present; did you mean %q?", u.discrimField, name, u.discrimField)
+			}
+		}
+		if missing := cmp.Or(o.inherited, o.defaultValue); missing != nil && errors.As(err, new(*MissingFieldError)) {
+			discrimValue, err = missing.value, nil
+			// The field may be present but treated as missing,
+			// in which case the Const field must see the value.
+			normalized = normalized || (o.emptyAsMissing || o.nullAsMissing) && o.keyFields == nil && o.discrimPath == nil
 		}
-		discrimValue, err := fieldValue(raw, discrimField)
-		dstType := fallbackType
 		if err == nil {
-			if t := discrimByValue[discrimValue]; t != nil {
+			// Guard against values that cannot be map keys.
+			err = checkScalar(discrimValue, o.keyFields == nil)
+		}
+		if err == nil && u.blocked[discrimValue] {
+			return reflect.Value{}, fmt.Errorf("discriminator value %#v is blocked", discrimValue)
+		}
+		if err == nil && discrimValue == nil {
+			return reflect.Value{}, fmt.Errorf("discriminator field %q is null", o.discrimName(u.discrimField))
+		}
+		switch {
+		case err == nil:
+			*discrim = discrimValue
+			if t := u.discrimByValue[discrimValue]; t == nil {
+				if o.unknownType != nil {
+					dstType = o.unknownType
+				}
+			} else {
 				dstType = t
+				variantFn = u.variantUnmarshalers[discrimValue]
+				if normalized {
+					raw, err = replaceFieldValue(raw, u.discrimField, discrimValue)
+					if err != nil {
+						return reflect.Value{}, err
+					}
+				}
+				if o.bodyField != "" {
+					body, offset, err := fieldRawValue(raw, o.bodyField)
+					switch {
+					case err == nil:
+						raw, start, ptr = body, start+offset, ptr.AppendToken(o.bodyField)
+					case errors.As(err, new(*MissingFieldError)):
+						if !o.optionalBody {
+							return reflect.Value{}, fmt.Errorf("body field %q not found", o.bodyField)
+						}
+						raw = jsontext.Value("{}")
+					default:
+						return reflect.Value{}, err
+					}
+				}
 			}
-		} else if fallbackType == nil {
-			return err
+		case errors.As(err, new(*MissingFieldError)):
+			if t := o.match(raw); t != nil {
+				dstType = t
+			} else if o.missingType != nil {
+				dstType = o.missingType
+			} else if u.fallbackType == nil {
+				return reflect.Value{}, err
+			}
+		case u.fallbackType == nil:
+			return reflect.Value{}, err
 		}
 		if dstType == nil {
-			return fmt.Errorf("unknown discriminator value %q (valid values are %v)", discrimValue, slices.Collect(maps.Keys(discrimByValue)))
+			return reflect.Value{}, &UnknownValueError{
+				Field: o.discrimName(u.discrimField),
+				Got:   discrimValue,
+				Valid: slices.SortedFunc(maps.Keys(u.discrimByValue), compareValues),
+			}
 		}
-		dst := reflect.New(dstType)
-		if err := json.Unmarshal(raw, dst.Interface(), d.Options()); err != nil {
-			return err
+	}
+	if variantFn != nil {
+		return unmarshalVariant(variantFn, raw, dstType, start, ptr)
+	}
+	if u.mustRemoveDiscrim(d, dstType) && raw.Kind() == '{' {
+		raw, err = removeField(raw, u.discrimField)
+		if err != nil {
+			return reflect.Value{}, err
 		}
-		reflect.ValueOf(src).Elem().Set(dst.Elem())
+	}
+	dst := reflect.New(dstType)
+	if err := json.Unmarshal(raw, dst.Interface(), append([]json.Options{d.Options()}, o.innerOpts...)...); err != nil {
+		return reflect.Value{}, relocateError(err, start, ptr)
+	}
+	return dst.Elem(), nil
+}
+
+// checkScalar returns a [*NotScalarError] if the discriminator value
+// v cannot be looked up because it is not comparable, as is the case
+// for JSON objects and arrays. If fromJSON is false, v was computed
+// rather than unmarshaled from JSON, so it has no JSON kind.
+func checkScalar(v any, fromJSON bool) error {
+	if v == nil || reflect.TypeOf(v).Comparable() {
 		return nil
-	})
+	}
+	var k jsontext.Kind
+	if fromJSON {
+		switch v.(type) {
+		case map[string]any:
+			k = '{'
+		case []any:
+			k = '['
+		}
+	}
+	return &NotScalarError{Kind: k}
+}
+
+// unmarshalVariant unmarshals the JSON value raw into a value of type
+// t using a function added with [WithVariantUnmarshaler].
+func unmarshalVariant(fn func(jsontext.Value, any) error, raw jsontext.Value, t reflect.Type, start int64, ptr jsontext.Pointer) (reflect.Value, error) {
+	if t.Kind() == reflect.Pointer {
+		dst := reflect.New(t.Elem())
+		if err := fn(raw, dst.Interface()); err != nil {
+			return reflect.Value{}, relocateError(err, start, ptr)
+		}
+		return dst, nil
+	}
+	dst := reflect.New(t)
+	if err := fn(raw, dst.Interface()); err != nil {
+		return reflect.Value{}, relocateError(err, start, ptr)
+	}
+	return dst.Elem(), nil
+}
+
+// peekType returns the type to unmarshal the next value from d into
+// when that can be determined from the data that d has already
+// buffered, without consuming any of it, along with the discriminator
+// value. This is possible when the value is an object with the
+// discriminator member near its start. It returns nil if the type
+// cannot be determined that way.
+func (u *union) peekType(d *jsontext.Decoder) (reflect.Type, any) {
+	if u.discrimField == "" || !u.o.canPeek() {
+		return nil, nil
+	}
+	v, ok := peekDiscrim(d, u.discrimField, u.o.discrimFirst)
+	if !ok {
+		return nil, nil
+	}
+	v, normalized := u.o.normalize(v)
+	if normalized || v == nil || u.o.isMissing(v) {
+		// The value needs rewriting or is treated as missing,
+		// both of which require buffering.
+		return nil, nil
+	}
+	if u.variantUnmarshalers[v] != nil || u.blocked[v] {
+		// The function needs the whole value, or the value
+		// must be rejected.
+		return nil, nil
+	}
+	t := u.discrimByValue[v]
+	if t == nil {
+		t = u.o.unknownType
+	}
+	if t == nil {
+		t = u.fallbackType
+	}
+	if u.mustRemoveDiscrim(d, t) {
+		return nil, nil
+	}
+	return t, v
+}
+
+// peekDiscrim returns the scalar value of the member named field in
+// the object that is the next value in d, reporting whether it could
+// be found in the data that d has already buffered. If firstOnly is
+// true, only the first member of the object is looked at.
+func peekDiscrim(d *jsontext.Decoder, field string, firstOnly bool) (any, bool) {
+	if d.PeekKind() != '{' {
+		return nil, false
+	}
+	if dup, _ := json.GetOption(d.Options(), jsontext.AllowDuplicateNames); dup {
+		// Only the first member is looked at, so
+		// conflicting duplicates would go unnoticed.
+		return nil, false
+	}
+	v, ok := peekFieldValue(d.UnreadBuffer(), field, firstOnly)
+	if !ok || checkScalar(v, true) != nil {
+		return nil, false
+	}
+	return v, true
+}
+
+// mustRemoveDiscrim reports whether the discriminator field must be
+// removed from an object before unmarshaling it from d into a value
+// of type t, because t has no field for it and unknown members are
+// rejected.
+func (u *union) mustRemoveDiscrim(d *jsontext.Decoder, t reflect.Type) bool {
+	if t == nil || !u.lacksDiscrim[structType(t)] {
+		return false
+	}
+	reject, _ := json.GetOption(json.JoinOptions(append([]json.Options{d.Options()}, u.o.innerOpts...)...), json.RejectUnknownMembers)
+	return reject
+}
+
+// acceptsMember reports whether the struct type t, or the type it
+// points to, has a field that accepts a JSON member with the given
+// name, either because the field has that name or because it holds
+// unknown members.
+func acceptsMember(t reflect.Type, name string) bool {
+	names, unknown := jsonMembers(t)
+	return unknown || slices.Contains(names, name)
+}
+
+// jsonMembers returns the names of the JSON object members declared by
+// the fields of the struct type t, or the type it points to. It also
+// reports whether t has a field holding unknown members, which is
+// taken to be true when t is not a struct type.
+func jsonMembers(t reflect.Type) (names []string, unknown bool) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, true
+	}
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous && !hasJSONName(f) {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		_, opts, _ := strings.Cut(tag, ",")
+		switch opts := strings.Split(opts, ","); {
+		case slices.Contains(opts, "unknown"):
+			unknown = true
+		case slices.Contains(opts, "inline"), slices.Contains(opts, "embed"):
+			inlineNames, inlineUnknown := jsonMembers(f.Type)
+			names = append(names, inlineNames...)
+			unknown = unknown || inlineUnknown
+		default:
+			names = append(names, jsonFieldName(f))
+		}
+	}
+	return names, unknown
+}
+
+// checkFirst returns an error if the JSON object data has a
+// discriminator member, under its own name or an alias, that is not
+// its first member.
+func (u *union) checkFirst(data jsontext.Value) error {
+	pd := getDecoder(data)
+	defer putDecoder(pd)
+	d := &pd.d
+	if _, err := d.ReadToken(); err != nil {
+		return err
+	}
+	for i := 0; d.PeekKind() != '}'; i++ {
+		tok, err := d.ReadToken()
+		if err != nil {
+			return err
+		}
+		if name := tok.String(); name == u.discrimField || slices.Contains(u.discrimAliases, name) {
+			if i > 0 {
+				return fmt.Errorf("discriminator field %q is not the first member", name)
+			}
+			return nil
+		}
+		if err := d.SkipValue(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maxPeek holds the maximum number of bytes of buffered input that
+// peekFieldValue looks at. It bounds the work wasted when the
+// discriminator is not near the start of an object, which then has to
+// be read again.
+const maxPeek = 4096
+
+// peekFieldValue returns the value of the member named fieldName in
+// the JSON object at the start of buf, reporting whether it was found
+// with its value and the delimiter following it entirely contained
+// within buf, or within the first [maxPeek] bytes of buf if that is
+// shorter. If firstOnly is true, only the first member of the object
+// is looked at. The start of buf may contain white space and a leading
+// colon or comma as found in the unread buffer of a [jsontext.Decoder].
+func peekFieldValue(buf []byte, fieldName string, firstOnly bool) (any, bool) {
+	buf = bytes.TrimLeft(buf, " \t\r\n")
+	if len(buf) > 0 && (buf[0] == ':' || buf[0] == ',') {
+		buf = buf[1:]
+	}
+	buf = buf[:min(len(buf), maxPeek)]
+	pd := getDecoder(buf)
+	defer putDecoder(pd)
+	d := &pd.d
+	if tok, err := d.ReadToken(); err != nil || tok.Kind() != '{' {
+		return nil, false
+	}
+	for {
+		name, err := d.ReadValue()
+		if err != nil || name.Kind() != '"' {
+			return nil, false
+		}
+		if nameEquals(name, fieldName) {
+			break
+		}
+		if firstOnly {
+			return nil, false
+		}
+		if err := d.SkipValue(); err != nil {
+			return nil, false
+		}
+	}
+	raw, err := d.ReadValue()
+	if err != nil {
+		return nil, false
+	}
+	// A number at the end of buf may continue beyond it,
+	// so the value is only known to be complete when
+	// the delimiter after it is there too.
+	rest := bytes.TrimLeft(buf[d.InputOffset():], " \t\r\n")
+	if len(rest) == 0 || rest[0] != ',' && rest[0] != '}' {
+		return nil, false
+	}
+	v, err := scalarValue(raw)
+	if err != nil {
+		return nil, false
+	}
+	return v, true
 }
 
 // Discriminator returns discrimination information between the given
@@ -96,41 +1069,230 @@ func StructsWithFallback[T any](fallback T, choices ...T) *json.Unmarshalers {
 // It returns the JSON name of the discriminator field and a map mapping
 // possible values for the field to the respective concrete T type for
 // that field value.
+//
+// Only [Const] fields with a different value in every choice are
+// candidates for the discriminator field. Other Const fields, such as
+// a version field holding the same value in all choices, are ignored
+// here but are still checked as usual when unmarshaling.
 func Discriminator[T any](choices ...T) (discrimField string, discrimByValue map[any]reflect.Type, err error) {
+	discrimField, discrimByValue, _, err = discriminator(&options{}, choices)
+	return discrimField, discrimByValue, err
+}
+
+// DiscriminatorWithFallback is like [Discriminator] except that it
+// also takes a fallback choice, following the rules documented in
+// [StructsWithFallback], and returns its concrete type as
+// fallbackType, which is nil if there is no fallback. When there are
+// no choices other than the fallback, discrimField is empty.
+func DiscriminatorWithFallback[T any](fallback T, choices ...T) (discrimField string, discrimByValue map[any]reflect.Type, fallbackType reflect.Type, err error) {
+	t := reflect.TypeFor[T]()
+	if t.Kind() != reflect.Interface {
+		return "", nil, nil, fmt.Errorf("type %v is not an interface type", t)
+	}
+	u, err := newUnion(&options{}, t, fallback, choices)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return u.discrimField, u.discrimByValue, u.fallbackType, nil
+}
+
+// ExplainDiscriminator returns the JSON name of the discriminator field
+// that would be used for the given options and choices, following the
+// rules documented in [StructsWithOptions], along with a description
+// of why that field was chosen. This can help to understand the choice
+// when several Const fields are involved.
+func ExplainDiscriminator[T any](opts []Option, choices ...T) (discrimField, reason string, err error) {
+	discrimField, _, reason, err = discriminator(applyOptions(opts), choices)
+	if err != nil {
+		return "", "", err
+	}
+	return discrimField, reason, nil
+}
+
+func discriminator[T any](o *options, choices []T) (discrimField string, discrimByValue map[any]reflect.Type, reason string, err error) {
+	literals := o.literals
+	if len(literals) > 0 {
+		// Choices that are also given a value by an option take
+		// their discriminator value from that instead.
+		choices = slices.DeleteFunc(slices.Clone(choices), func(choice T) bool {
+			return !isNil(choice) && slices.ContainsFunc(literals, func(l literal) bool {
+				return l.typ == reflect.TypeOf(choice)
+			})
+		})
+	}
+	if o.typeName != nil {
+		// Choices without any Const fields take their
+		// discriminator value from their type name instead.
+		var constChoices []T
+		for _, choice := range choices {
+			if !isNil(choice) {
+				if t := reflect.TypeOf(choice); len(mappedConstFields(t, o.mapFieldName)) == 0 {
+					literals = append(literals, literal{typ: t, value: o.typeName(t)})
+					continue
+				}
+			}
+			constChoices = append(constChoices, choice)
+		}
+		choices = constChoices
+	}
+	if len(literals) == 0 {
+		return constDiscriminator(o, choices)
+	}
+	discrimField = o.discrimField
+	discrimByValue = make(map[any]reflect.Type)
+	reason = "it is given by WithDiscriminatorField"
+	if len(choices) > 0 {
+		discrimField, discrimByValue, reason, err = constDiscriminator(o, choices)
+		if err != nil {
+			return "", nil, "", err
+		}
+	} else if discrimField == "" {
+		return "", nil, "", fmt.Errorf("WithDiscriminatorField is required when no choices have Const fields")
+	}
+	for _, l := range literals {
+		if t, ok := discrimByValue[l.value]; ok {
+			if o.firstMatch {
+				continue
+			}
+			return "", nil, "", fmt.Errorf("discriminator value %#v of %v is already used by %v", l.value, l.typ, t)
+		}
+		discrimByValue[l.value] = l.typ
+	}
+	return discrimField, discrimByValue, reason, nil
+}
+
+// constDiscriminator is like discriminator except that it ignores
+// choices given by options.
+func constDiscriminator[T any](o *options, choices []T) (discrimField string, discrimByValue map[any]reflect.Type, reason string, err error) {
 	if t := reflect.TypeFor[T](); t.Kind() != reflect.Interface {
-		return "", nil, fmt.Errorf("type %v is not an interface type", t)
+		return "", nil, "", fmt.Errorf("type %v is not an interface type", t)
 	}
 	discrims := make(map[string]map[any]reflect.Type)
+	// present holds the number of choices containing each field.
+	present := make(map[string]int)
 	for i, choice := range choices {
 		if isNil(choice) {
-			return "", nil, fmt.Errorf("argument %d is nil but should be concrete implementation of %v", i, reflect.TypeFor[T]())
+			return "", nil, "", fmt.Errorf("argument %d is nil but should be concrete implementation of %v", i, reflect.TypeFor[T]())
 		}
-		for fieldName, v := range constFields(reflect.TypeOf(choice)) {
+		for fieldName, v := range mappedConstFields(reflect.TypeOf(choice), o.mapFieldName) {
+			present[fieldName]++
 			byValue := discrims[fieldName]
 			if discrims[fieldName] == nil {
 				byValue = make(map[any]reflect.Type)
 				discrims[fieldName] = byValue
 			}
+			if _, ok := byValue[v]; ok && o.firstMatch {
+				continue
+			}
 			byValue[v] = reflect.TypeOf(choice)
 		}
 	}
-	for fieldName, byValue := range discrims {
-		if len(byValue) != len(choices) {
-			continue
+	isCandidate := func(fieldName string) bool {
+		if o.firstMatch {
+			return present[fieldName] == len(choices)
 		}
-		if discrimField != "" {
-			return "", nil, fmt.Errorf("ambiguous discriminator fields %q and %q", discrimField, fieldName)
+		return len(discrims[fieldName]) == len(choices)
+	}
+	if o.discrimField != "" {
+		if !isCandidate(o.discrimField) {
+			return "", nil, "", fmt.Errorf("field %q does not hold a different Const value in every choice", o.discrimField)
+		}
+		byValue, err := addAliases(o, choices, o.discrimField, discrims[o.discrimField])
+		if err != nil {
+			return "", nil, "", err
+		}
+		return o.discrimField, byValue, "it is given by WithDiscriminatorField", nil
+	}
+	var candidates []string
+	for fieldName := range discrims {
+		if isCandidate(fieldName) {
+			candidates = append(candidates, fieldName)
+		}
+	}
+	if o.firstMatch && len(candidates) > 1 {
+		// Prefer the fields that best discriminate between the choices.
+		best := 0
+		for _, fieldName := range candidates {
+			best = max(best, len(discrims[fieldName]))
+		}
+		candidates = slices.DeleteFunc(candidates, func(fieldName string) bool {
+			return len(discrims[fieldName]) < best
+		})
+	}
+	switch len(candidates) {
+	case 0:
+		return "", nil, "", fmt.Errorf("cannot determine discriminator from possibles %v", slices.Sorted(maps.Keys(discrims)))
+	case 1:
+		field := candidates[0]
+		if o.firstMatch {
+			reason = fmt.Sprintf("it is present in every choice and distinguishes the most choices (%d of %d)", len(discrims[field]), len(choices))
+		} else {
+			reason = "it is the only Const field with a different value in every choice"
+		}
+		if others := slices.DeleteFunc(slices.Sorted(maps.Keys(discrims)), func(f string) bool { return f == field }); len(others) > 0 {
+			reason += fmt.Sprintf("; other Const fields %v are not", others)
+		}
+		byValue, err := addAliases(o, choices, field, discrims[field])
+		if err != nil {
+			return "", nil, "", err
+		}
+		return field, byValue, reason, nil
+	}
+	slices.Sort(candidates)
+	return "", nil, "", fmt.Errorf("ambiguous discriminator fields %v; disambiguate with WithDiscriminatorField", candidates)
+}
+
+// addAliases adds the aliases of the [Const] fields with the given JSON
+// name in the choices to byValue, which maps their values to the
+// choice types, and returns it.
+func addAliases[T any](o *options, choices []T, jsonName string, byValue map[any]reflect.Type) (map[any]reflect.Type, error) {
+	for _, choice := range choices {
+		t := reflect.TypeOf(choice)
+		for _, a := range constAliases(t, jsonName, o.mapFieldName) {
+			if t1, ok := byValue[a]; ok {
+				if o.firstMatch {
+					continue
+				}
+				return nil, fmt.Errorf("discriminator value %#v of %v is already used by %v", a, t, t1)
+			}
+			byValue[a] = t
 		}
-		discrimField = fieldName
-		discrimByValue = byValue
 	}
-	if discrimField == "" {
-		return "", nil, fmt.Errorf("cannot determine discriminator from possibles %v", slices.Sorted(maps.Keys(discrims)))
+	return byValue, nil
+}
+
+// constAliases returns the aliases of the [Const] field with the given
+// JSON name in the struct type t, or the type it points to.
+func constAliases(t reflect.Type, jsonName string, mapName func(string) string) []any {
+	for _, f := range reflect.VisibleFields(structType(t)) {
+		if f.PkgPath != "" {
+			continue
+		}
+		fv, ok := reflect.Zero(f.Type).Interface().(interface {
+			constAliases() []any
+		})
+		if !ok {
+			continue
+		}
+		name := jsonFieldName(f)
+		if mapName != nil && !hasJSONName(f) {
+			name = mapName(f.Name)
+		}
+		if name == jsonName {
+			return fv.constAliases()
+		}
 	}
-	return discrimField, discrimByValue, nil
+	return nil
 }
 
 func constFields(t0 reflect.Type) map[string]any {
+	return mappedConstFields(t0, nil)
+}
+
+// mappedConstFields is like constFields except that the JSON names of
+// fields without an explicit name in their json tag are derived from
+// their Go names by mapName, if it is non-nil.
+func mappedConstFields(t0 reflect.Type, mapName func(string) string) map[string]any {
 	t := t0
 	if t.Kind() == reflect.Pointer {
 		t = t.Elem()
@@ -149,7 +1311,15 @@ func constFields(t0 reflect.Type) map[string]any {
 		if !ok {
 			continue
 		}
+		if f.Anonymous {
+			// The methods of Const would be promoted, causing
+			// the whole struct to marshal as the constant.
+			panic(fmt.Errorf("%v embeds %v, which has the methods of Const; use a named field with a json tag instead", t0, f.Type))
+		}
 		name := jsonFieldName(f)
+		if mapName != nil && !hasJSONName(f) {
+			name = mapName(f.Name)
+		}
 		if _, ok := fields[name]; ok {
 			panic(fmt.Errorf("multiple fields with JSON name %q in %v", name, t0))
 		}
@@ -158,6 +1328,12 @@ func constFields(t0 reflect.Type) map[string]any {
 	return fields
 }
 
+// hasJSONName reports whether the json tag of f holds an explicit name.
+func hasJSONName(f reflect.StructField) bool {
+	jsonName, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	return jsonName != ""
+}
+
 func jsonFieldName(f reflect.StructField) string {
 	name := f.Name
 	tag := f.Tag.Get("json")
@@ -171,37 +1347,348 @@ func jsonFieldName(f reflect.StructField) string {
 }
 
 func fieldValue(data []byte, fieldName string) (any, error) {
-	d := jsontext.NewDecoder(bytes.NewBuffer(data))
-	tok, err := d.ReadToken()
+	values, err := fieldValues(data, []string{fieldName})
 	if err != nil {
 		return nil, err
 	}
-	if tok.Kind() != '{' {
-		return nil, fmt.Errorf("expected object, got %v", tok.Kind())
+	return values[0], nil
+}
+
+// fieldValues returns the values of all the given fields in the JSON
+// object data, in the same order as fieldNames.
+//
+// When a field appears more than once, as is possible when decoding
+// with [jsontext.AllowDuplicateNames], its values must all be equal;
+// it is an error for them to conflict.
+func fieldValues(data []byte, fieldNames []string) ([]any, error) {
+	pd := getDecoder(data)
+	defer putDecoder(pd)
+	d := &pd.d
+	if kind := d.PeekKind(); kind != '{' {
+		if _, err := d.ReadToken(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("expected object, got %v", kind)
+	}
+	if _, err := d.ReadToken(); err != nil {
+		return nil, err
+	}
+	values := make([]any, len(fieldNames))
+	found := make([]bool, len(fieldNames))
+	for d.PeekKind() != '}' {
+		name, err := d.ReadValue()
+		if err != nil {
+			return nil, err
+		}
+		i := slices.IndexFunc(fieldNames, func(fieldName string) bool {
+			return nameEquals(name, fieldName)
+		})
+		if i < 0 {
+			if err := d.SkipValue(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// Scan the rest of the object even when all the fields
+		// have been found, so that conflicting duplicates are
+		// detected.
+		raw, err := d.ReadValue()
+		if err != nil {
+			return nil, err
+		}
+		v, err := scalarValue(raw)
+		if err != nil {
+			return nil, err
+		}
+		if found[i] && !reflect.DeepEqual(v, values[i]) {
+			return nil, fmt.Errorf("duplicate field %q has conflicting values %#v and %#v", fieldNames[i], values[i], v)
+		}
+		values[i], found[i] = v, true
+	}
+	if _, err := d.ReadToken(); err != nil {
+		return nil, err
+	}
+	if i := slices.Index(found, false); i >= 0 {
+		return nil, &MissingFieldError{Field: fieldNames[i]}
+	}
+	return values, nil
+}
+
+// nameEquals reports whether the raw JSON string name,
+// which must be valid, is equal to s.
+func nameEquals(name jsontext.Value, s string) bool {
+	if bytes.IndexByte(name, '\\') < 0 {
+		// Comparing with a converted byte slice
+		// does not allocate.
+		return string(name[1:len(name)-1]) == s
+	}
+	unquoted, err := jsontext.AppendUnquote(nil, name)
+	return err == nil && string(unquoted) == s
+}
+
+// scalarValue returns the canonical value of the valid JSON value raw,
+// unmarshaling objects and arrays as for [valueOptions].
+func scalarValue(raw jsontext.Value) (any, error) {
+	switch raw.Kind() {
+	case 'n':
+		return nil, nil
+	case 't':
+		return true, nil
+	case 'f':
+		return false, nil
+	case '0':
+		return parseNumber(string(raw)), nil
+	case '"':
+		if bytes.IndexByte(raw, '\\') < 0 {
+			return string(raw[1 : len(raw)-1]), nil
+		}
+		unquoted, err := jsontext.AppendUnquote(nil, raw)
+		return string(unquoted), err
+	}
+	var v any
+	err := json.Unmarshal(raw, &v, valueOptions)
+	return v, err
+}
+
+// decoderPool holds decoders for reading JSON values that have already
+// been buffered, so that finding the discriminator does not allocate a
+// new decoder for every value.
+var decoderPool = sync.Pool{
+	New: func() any {
+		return new(pooledDecoder)
+	},
+}
+
+type pooledDecoder struct {
+	// buf holds the data being decoded. Reading from a
+	// [bytes.Buffer] allows d to use the data without copying.
+	buf bytes.Buffer
+	d   jsontext.Decoder
+}
+
+// getDecoder returns a decoder from the pool reading data, allowing
+// duplicate names so that the caller can check them itself. It must
+// be returned with [putDecoder].
+func getDecoder(data []byte) *pooledDecoder {
+	pd := decoderPool.Get().(*pooledDecoder)
+	pd.buf = *bytes.NewBuffer(data)
+	pd.d.Reset(&pd.buf, jsontext.AllowDuplicateNames(true))
+	return pd
+}
+
+// putDecoder returns pd to the pool.
+func putDecoder(pd *pooledDecoder) {
+	// Drop the references to the data.
+	pd.buf = bytes.Buffer{}
+	pd.d.Reset(&pd.buf)
+	decoderPool.Put(pd)
+}
+
+// fieldPathValue returns the value found by following the given path
+// of member names from the JSON object data.
+func fieldPathValue(data []byte, path []string) (any, error) {
+	d := jsontext.NewDecoder(bytes.NewBuffer(data))
+	for i, name := range path {
+		if k := d.PeekKind(); k != '{' {
+			if i == 0 {
+				return nil, fmt.Errorf("expected object, got %v", k)
+			}
+			return nil, fmt.Errorf("discriminator path element %q is %s not object", strings.Join(path[:i], "."), kindName(k))
+		}
+		if _, err := d.ReadToken(); err != nil {
+			return nil, err
+		}
+		for {
+			tok, err := d.ReadToken()
+			if err != nil {
+				return nil, err
+			}
+			if tok.Kind() == '}' {
+				return nil, &MissingFieldError{Field: strings.Join(path[:i+1], ".")}
+			}
+			if tok.String() == name {
+				break
+			}
+			if err := d.SkipValue(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var v any
+	if err := json.UnmarshalDecode(d, &v, valueOptions); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// fieldRawValue returns the raw value of the given field in the JSON
+// object data along with its byte offset within data.
+func fieldRawValue(data []byte, fieldName string) (jsontext.Value, int64, error) {
+	d := jsontext.NewDecoder(bytes.NewBuffer(data))
+	if _, err := d.ReadToken(); err != nil {
+		return nil, 0, err
 	}
 	for {
 		tok, err := d.ReadToken()
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		if tok.Kind() == '}' {
-			return nil, fmt.Errorf("discriminator field %q not found", fieldName)
-		}
-		if tok.Kind() != '"' {
-			return nil, fmt.Errorf("unexpected token %q", tok)
+			return nil, 0, &MissingFieldError{Field: fieldName}
 		}
 		if tok.String() != fieldName {
 			if err := d.SkipValue(); err != nil {
-				return nil, err
+				return nil, 0, err
 			}
 			continue
 		}
-		var v any
-		if err := json.UnmarshalDecode(d, &v); err != nil {
+		v, err := d.ReadValue()
+		if err != nil {
+			return nil, 0, err
+		}
+		return v, d.InputOffset() - int64(len(v)), nil
+	}
+}
+
+// replaceFieldValue returns a copy of the JSON object data with the
+// value of every member named fieldName replaced by v.
+func replaceFieldValue(data jsontext.Value, fieldName string, v any) (jsontext.Value, error) {
+	return rewriteObject(data, func(e *jsontext.Encoder, name string, value jsontext.Value) error {
+		if name != fieldName {
+			return writeMember(e, name, value)
+		}
+		if err := e.WriteToken(jsontext.String(name)); err != nil {
+			return err
+		}
+		return json.MarshalEncode(e, v)
+	})
+}
+
+// rewriteObject returns a copy of the JSON object data in which each
+// member is replaced by whatever member calls for it write to e, which
+// may be nothing.
+func rewriteObject(data jsontext.Value, member func(e *jsontext.Encoder, name string, value jsontext.Value) error) (jsontext.Value, error) {
+	d := jsontext.NewDecoder(bytes.NewReader(data))
+	var buf bytes.Buffer
+	e := jsontext.NewEncoder(&buf)
+	if _, err := d.ReadToken(); err != nil {
+		return nil, err
+	}
+	if err := e.WriteToken(jsontext.BeginObject); err != nil {
+		return nil, err
+	}
+	for d.PeekKind() != '}' {
+		tok, err := d.ReadToken()
+		if err != nil {
+			return nil, err
+		}
+		name := tok.String()
+		value, err := d.ReadValue()
+		if err != nil {
+			return nil, err
+		}
+		if err := member(e, name, value); err != nil {
 			return nil, err
 		}
-		return v, nil
 	}
+	if err := e.WriteToken(jsontext.EndObject); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(buf.Bytes()), nil
+}
+
+// writeMember writes an object member with the given name
+// and value to e.
+func writeMember(e *jsontext.Encoder, name string, value jsontext.Value) error {
+	if err := e.WriteToken(jsontext.String(name)); err != nil {
+		return err
+	}
+	return e.WriteValue(value)
+}
+
+// isEmptyObject reports whether data is a JSON object
+// with no members.
+func isEmptyObject(data jsontext.Value) bool {
+	return data.Kind() == '{' && len(bytes.TrimSpace(data[1:len(data)-1])) == 0
+}
+
+// misspelledField returns the name of the only member of the JSON
+// object data whose name is one edit away from fieldName, or the
+// empty string if there is not exactly one such member.
+func misspelledField(data jsontext.Value, fieldName string) (string, error) {
+	d := jsontext.NewDecoder(bytes.NewReader(data), jsontext.AllowDuplicateNames(true))
+	if _, err := d.ReadToken(); err != nil {
+		return "", err
+	}
+	var found string
+	for d.PeekKind() != '}' {
+		tok, err := d.ReadToken()
+		if err != nil {
+			return "", err
+		}
+		name := tok.String()
+		if err := d.SkipValue(); err != nil {
+			return "", err
+		}
+		if name != found && oneEdit(name, fieldName) {
+			if found != "" {
+				return "", nil
+			}
+			found = name
+		}
+	}
+	return found, nil
+}
+
+// oneEdit reports whether s1 can be turned into s2 by inserting,
+// deleting or substituting a single rune, or by swapping two adjacent
+// runes.
+func oneEdit(s1, s2 string) bool {
+	r1, r2 := []rune(s1), []rune(s2)
+	if len(r1) > len(r2) {
+		r1, r2 = r2, r1
+	}
+	// Skip the common prefix and suffix; what remains must
+	// be a single edit.
+	for len(r1) > 0 && r1[0] == r2[0] {
+		r1, r2 = r1[1:], r2[1:]
+	}
+	for len(r1) > 0 && r1[len(r1)-1] == r2[len(r2)-1] {
+		r1, r2 = r1[:len(r1)-1], r2[:len(r2)-1]
+	}
+	switch {
+	case len(r2)-len(r1) == 1:
+		return len(r1) == 0
+	case len(r1) != len(r2):
+		return false
+	case len(r1) == 1:
+		return true
+	case len(r1) == 2:
+		return r1[0] == r2[1] && r1[1] == r2[0]
+	}
+	return false
+}
+
+// renameField returns a copy of the JSON object data with any members
+// named from renamed to to.
+func renameField(data jsontext.Value, from, to string) (jsontext.Value, error) {
+	return rewriteObject(data, func(e *jsontext.Encoder, name string, value jsontext.Value) error {
+		if name == from {
+			name = to
+		}
+		return writeMember(e, name, value)
+	})
+}
+
+// removeField returns the JSON object data with any members named
+// fieldName removed.
+func removeField(data jsontext.Value, fieldName string) (jsontext.Value, error) {
+	return rewriteObject(data, func(e *jsontext.Encoder, name string, value jsontext.Value) error {
+		if name == fieldName {
+			return nil
+		}
+		return writeMember(e, name, value)
+	})
 }
 
 func isNil[T any](x T) bool {
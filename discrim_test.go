@@ -2,12 +2,19 @@ package jsondiscrim
 
 import (
 	stdjson "encoding/json"
+	"errors"
+	"io"
+	"math"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 
-	"github.com/go-json-experiment/json"
-	"github.com/go-json-experiment/json/jsontext"
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
 	"github.com/go-quicktest/qt"
 	"github.com/google/go-cmp/cmp"
 )
@@ -59,6 +66,126 @@ func TestConstValue(t *testing.T) {
 	}
 }
 
+func TestConstInfo(t *testing.T) {
+	v, typ := ConstInfo[int, struct {
+		int `const:"42"`
+	}]()
+	qt.Assert(t, qt.Equals(v, 42))
+	qt.Assert(t, qt.Equals(typ, reflect.TypeFor[int]()))
+
+	f, typ := ConstInfo[float64, struct {
+		float64 `const:"-0"`
+	}]()
+	qt.Assert(t, qt.Equals(f, 0.0))
+	qt.Assert(t, qt.IsFalse(math.Signbit(f)))
+	qt.Assert(t, qt.Equals(typ, reflect.TypeFor[float64]()))
+
+	k, typ := ConstInfo[ShelterKind, struct {
+		ShelterKind `const:"0x1"`
+	}]()
+	qt.Assert(t, qt.Equals(k, ShelterCat))
+	qt.Assert(t, qt.Equals(typ, reflect.TypeFor[ShelterKind]()))
+}
+
+func TestConstInfoInvalid(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		ConstInfo[int, struct {
+			int `const:"x"`
+		}]()
+	}, `integer const tag for int must be an integer in range, got "x"`))
+}
+
+type houndConst = stringConst[struct {
+	string `const:"dog" const:"hound" const:"doggo"`
+}]
+
+func TestConstAliases(t *testing.T) {
+	var c houndConst
+	qt.Assert(t, qt.Equals(c.Value(), "dog"))
+	data, err := json.Marshal(c)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(data), `"dog"`))
+	for _, s := range []string{`"dog"`, `"hound"`, `"doggo"`} {
+		qt.Assert(t, qt.IsNil(json.Unmarshal([]byte(s), &c)))
+	}
+	err = json.Unmarshal([]byte(`"cat"`), &c)
+	qt.Assert(t, qt.ErrorMatches(err, `.*unexpected const value; got "cat" but want one of \[\]string{"dog", "hound", "doggo"}`))
+
+	var n Const[int, struct {
+		int `const:"1" const:"0x2"`
+	}]
+	qt.Assert(t, qt.Equals(n.Value(), 1))
+	qt.Assert(t, qt.IsNil(json.Unmarshal([]byte(`2.0`), &n)))
+	err = json.Unmarshal([]byte(`3`), &n)
+	qt.Assert(t, qt.ErrorMatches(err, `.*unexpected const value; got 3 but want one of \[\]int{1, 2}`))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		Const[int, struct {
+			int `const:"1" const:"1e0"`
+		}]{}.Value()
+	}, `const tag value "1e0" for int is given more than once`))
+}
+
+type AliasDog struct {
+	Type houndConst `json:"type"`
+	Bark string
+}
+
+func (*AliasDog) isAnimal() {}
+
+func TestStructsWithConstAliases(t *testing.T) {
+	unmarshalers := json.WithUnmarshalers(Structs[Animal]((*AliasDog)(nil), (*Cat)(nil)))
+	var got []Animal
+	err := json.Unmarshal([]byte(`[{"type":"hound","Bark":"a"},{"Bark":"b","type":"doggo"},{"type":"dog"},{"type":"cat"}]`), &got, unmarshalers)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, []Animal{&AliasDog{Bark: "a"}, &AliasDog{Bark: "b"}, &AliasDog{}, &Cat{}}))
+
+	// The canonical value is used when marshaling.
+	data, err := json.Marshal(got[0])
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(data), `{"type":"dog","Bark":"a"}`))
+
+	_, byValue, err := Discriminator[Animal]((*AliasDog)(nil), (*Cat)(nil))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.CmpEquals(byValue, map[any]reflect.Type{
+		"dog":   reflect.TypeFor[*AliasDog](),
+		"hound": reflect.TypeFor[*AliasDog](),
+		"doggo": reflect.TypeFor[*AliasDog](),
+		"cat":   reflect.TypeFor[*Cat](),
+	}, cmp.Comparer(cmpWithEqual[reflect.Type])))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		Structs[Animal]((*AliasDog)(nil), (*Dog)(nil))
+	}, `cannot determine discriminator from possibles \[type\]`))
+}
+
+func TestStructsWithConflictingConstAlias(t *testing.T) {
+	type CatHound struct {
+		Type stringConst[struct {
+			string `const:"cathound" const:"hound"`
+		}] `json:"type"`
+	}
+	_, _, err := Discriminator[any]((*AliasDog)(nil), (*CatHound)(nil))
+	qt.Assert(t, qt.ErrorMatches(err, `discriminator value "hound" of \*jsondiscrim.CatHound is already used by \*jsondiscrim.AliasDog`))
+}
+
+func TestTagValues(t *testing.T) {
+	tests := []struct {
+		tag  reflect.StructTag
+		want []string
+	}{
+		{``, nil},
+		{`const:"a"`, []string{"a"}},
+		{`const:"a" json:"x" const:"b c"`, []string{"a", "b c"}},
+		{`json:"x"`, nil},
+		{`const:"a\"b"  const:""`, []string{`a"b`, ""}},
+		{`const:"a" bad const:"b"`, []string{"a"}},
+	}
+	for _, tt := range tests {
+		qt.Check(t, qt.DeepEquals(tagValues(tt.tag, "const"), tt.want), qt.Commentf("%s", tt.tag))
+	}
+}
+
 func TestConstMarshalJSON(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -128,6 +255,22 @@ func TestConstUnmarshalJSON(t *testing.T) {
 			wantErr: true,
 			errMsg:  "unexpected const value; got 99 but want 42",
 		},
+		{
+			name: "int 42 float form",
+			json: `42.0`,
+			target: new(Const[int, struct {
+				int `const:"42"`
+			}]),
+		},
+		{
+			name: "int 42 fraction",
+			json: `42.5`,
+			target: new(Const[int, struct {
+				int `const:"42"`
+			}]),
+			wantErr: true,
+			errMsg:  "unexpected const value; got 42.5 but want 42",
+		},
 		{
 			name: "bool true success",
 			json: `true`,
@@ -196,7 +339,7 @@ func (Bird) isAnimal() {}
 
 type OtherAnimal struct {
 	Type        string         `json:"type"`
-	OtherFields jsontext.Value `json:",unknown"`
+	OtherFields jsontext.Value `json:",unknown,embed"`
 }
 
 func (OtherAnimal) isAnimal() {}
@@ -449,6 +592,126 @@ func TestStructsPanics(t *testing.T) {
 	})
 }
 
+func TestValues(t *testing.T) {
+	unmarshalers := json.WithUnmarshalers(Values("kind", map[any]Animal{
+		"horse":  (*Horse)(nil),
+		"pony":   (*Horse)(nil),
+		"cat":    Cat{},
+		int64(7): (*Cow)(nil),
+	}))
+	tests := []struct {
+		name    string
+		json    string
+		want    Animal
+		wantErr string
+	}{
+		{
+			name: "pointer",
+			json: `{"kind":"horse","Neigh":"neigh"}`,
+			want: &Horse{Neigh: "neigh"},
+		},
+		{
+			name: "alias",
+			json: `{"Neigh":"hee","kind":"pony"}`,
+			want: &Horse{Neigh: "hee"},
+		},
+		{
+			name: "value",
+			json: `{"kind":"cat","Meow":"purr"}`,
+			want: Cat{Meow: "purr"},
+		},
+		{
+			name: "number",
+			json: `{"kind":7.0}`,
+			want: &Cow{},
+		},
+		{
+			name:    "unknown",
+			json:    `{"kind":"goat"}`,
+			wantErr: `.*unknown discriminator value "goat".*`,
+		},
+		{
+			name:    "missing",
+			json:    `{"type":"horse"}`,
+			wantErr: `.*discriminator field "kind" not found`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got, unmarshalers)
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestValuesErrors(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		Values("", map[any]Animal{"horse": (*Horse)(nil)})
+	}, `empty field provided to Values`))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		Values("kind", map[any]Animal{"horse": nil})
+	}, `nil choice provided to Values for value "horse"`))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		Values("kind", map[any]Animal{})
+	}, `no choices provided to Structs`))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		Values("kind", map[any]Animal{1.0: (*Horse)(nil), 1: (*Cow)(nil)})
+	}, `discriminator value 1 of \*jsondiscrim\.(Horse|Cow) is already used by .*`))
+}
+
+func TestStructsFunc(t *testing.T) {
+	var calls atomic.Int32
+	unmarshalers := StructsFunc(func() []Animal {
+		calls.Add(1)
+		return []Animal{(*Dog)(nil), (*Cat)(nil)}
+	})
+	qt.Assert(t, qt.Equals(calls.Load(), 0))
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var got []Animal
+			err := json.Unmarshal([]byte(`[{"type":"dog","Bark":"woof"},{"type":"cat"}]`), &got, json.WithUnmarshalers(unmarshalers))
+			qt.Check(t, qt.IsNil(err))
+			qt.Check(t, qt.DeepEquals(got, []Animal{&Dog{Bark: "woof"}, &Cat{}}))
+		}()
+	}
+	wg.Wait()
+	qt.Assert(t, qt.Equals(calls.Load(), 1))
+}
+
+func TestStructsFuncError(t *testing.T) {
+	calls := 0
+	unmarshalers := json.WithUnmarshalers(StructsFunc(func() []Animal {
+		calls++
+		return []Animal{(*Dog)(nil), (*DogV2)(nil)}
+	}))
+	for range 2 {
+		var got Animal
+		err := json.Unmarshal([]byte(`{"type":"dog"}`), &got, unmarshalers)
+		qt.Assert(t, qt.ErrorMatches(err, `.*cannot determine discriminator from possibles \[type\]`))
+	}
+	qt.Assert(t, qt.Equals(calls, 1))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsFunc[Animal](nil)
+	}, `nil function provided to StructsFunc`))
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsFunc(func() []*Dog { return nil })
+	}, `type \*jsondiscrim.Dog is not an interface type`))
+}
+
 func TestFieldValue(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -467,13 +730,13 @@ func TestFieldValue(t *testing.T) {
 			name:  "int field",
 			json:  `{"name":"John","age":30}`,
 			field: "age",
-			want:  float64(30), // JSON numbers unmarshal as float64
+			want:  int64(30), // JSON numbers unmarshal as their canonical values
 		},
 		{
 			name:  "nested object",
 			json:  `{"user":{"name":"John"},"age":30}`,
 			field: "age",
-			want:  float64(30),
+			want:  int64(30),
 		},
 		{
 			name:  "first field",
@@ -481,6 +744,42 @@ func TestFieldValue(t *testing.T) {
 			field: "first",
 			want:  "value",
 		},
+		{
+			name:  "last field",
+			json:  `{"user":{"type":"nested"},"tags":["type"],"type":"dog"}`,
+			field: "type",
+			want:  "dog",
+		},
+		{
+			name:  "escaped name",
+			json:  `{"t\u0079pe":"dog"}`,
+			field: "type",
+			want:  "dog",
+		},
+		{
+			name:  "escaped value",
+			json:  `{"type":"d\u006fg\n"}`,
+			field: "type",
+			want:  "dog\n",
+		},
+		{
+			name:  "object value",
+			json:  `{"type":{"a":1}}`,
+			field: "type",
+			want:  map[string]any{"a": int64(1)},
+		},
+		{
+			name:  "null value",
+			json:  `{"type":null}`,
+			field: "type",
+			want:  nil,
+		},
+		{
+			name:    "truncated",
+			json:    `{"name":"John","type":`,
+			field:   "type",
+			wantErr: `.*unexpected EOF.*`,
+		},
 		{
 			name:    "field not found",
 			json:    `{"name":"John","age":30}`,
@@ -491,7 +790,7 @@ func TestFieldValue(t *testing.T) {
 			name:    "not an object",
 			json:    `["array"]`,
 			field:   "name",
-			wantErr: ".*",
+			wantErr: "expected object, got \\[",
 		},
 	}
 
@@ -559,6 +858,29 @@ func TestConstFieldsPanics(t *testing.T) {
 			constFields(reflect.TypeOf(DuplicateJSON{}))
 		}, "multiple fields with JSON name.*"))
 	})
+
+	type EmbeddedConst struct {
+		Const[string, struct {
+			string `const:"dog"`
+		}] `json:"type"`
+		Bark string
+	}
+
+	t.Run("embedded Const", func(t *testing.T) {
+		qt.Assert(t, qt.PanicMatches(func() {
+			Structs[any](&EmbeddedConst{})
+		}, `\*jsondiscrim.EmbeddedConst embeds jsondiscrim.Const\[.*\], which has the methods of Const; use a named field with a json tag instead`))
+	})
+
+	type IndirectEmbeddedConst struct {
+		EmbeddedConst
+	}
+
+	t.Run("indirectly embedded Const", func(t *testing.T) {
+		qt.Assert(t, qt.PanicMatches(func() {
+			constFields(reflect.TypeOf(IndirectEmbeddedConst{}))
+		}, `jsondiscrim.IndirectEmbeddedConst embeds jsondiscrim.EmbeddedConst, which has the methods of Const.*`))
+	})
 }
 
 // Test round-trip marshaling and unmarshaling
@@ -704,6 +1026,1036 @@ func TestStructsWithDecoder(t *testing.T) {
 	qt.Assert(t, qt.DeepEquals(got, want))
 }
 
+// Pet is a narrower interface implemented by some of the
+// [Animal] implementations.
+type Pet interface {
+	Animal
+	isPet()
+}
+
+func (Dog) isPet() {}
+
+func (Cat) isPet() {}
+
+func TestStructsSubUnion(t *testing.T) {
+	type Home struct {
+		Pet     Pet
+		Visitor Animal
+	}
+	unmarshalers := json.JoinUnmarshalers(
+		Structs[Pet](
+			(*Dog)(nil),
+			(*Cat)(nil),
+		),
+		Structs[Animal](
+			(*Dog)(nil),
+			(*Cat)(nil),
+			(*Bird)(nil),
+		),
+	)
+	var got Home
+	err := json.Unmarshal(
+		[]byte(`{"Pet": {"type": "cat", "Meow": "purr"}, "Visitor": {"type": "bird", "Sing": "tweet"}}`),
+		&got,
+		json.WithUnmarshalers(unmarshalers),
+	)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, Home{
+		Pet:     &Cat{Meow: "purr"},
+		Visitor: &Bird{Sing: "tweet"},
+	}))
+
+	// A bird is an Animal but not a Pet, so the Pet union
+	// should not accept it.
+	err = json.Unmarshal(
+		[]byte(`{"Pet": {"type": "bird", "Sing": "tweet"}}`),
+		&got,
+		json.WithUnmarshalers(unmarshalers),
+	)
+	qt.Assert(t, qt.ErrorMatches(err, `.*unknown discriminator value "bird".*`))
+}
+
+func TestUnknownValueError(t *testing.T) {
+	var got Animal
+	err := json.Unmarshal([]byte(`{"type":"bird","Sing":"tweet"}`), &got,
+		json.WithUnmarshalers(Structs[Animal]((*Dog)(nil), (*Cat)(nil), (*UpperDog)(nil))),
+	)
+	qt.Assert(t, qt.ErrorMatches(err, `.*unknown discriminator value "bird" \(valid values are \[Dog cat dog\]\)`))
+	var valueErr *UnknownValueError
+	qt.Assert(t, qt.IsTrue(errors.As(err, &valueErr)))
+	qt.Assert(t, qt.DeepEquals(valueErr, &UnknownValueError{
+		Field: "type",
+		Got:   "bird",
+		Valid: []any{"Dog", "cat", "dog"},
+	}))
+
+	err = json.Unmarshal([]byte(`{"kind":{"name":"bird"}}`), &got,
+		json.WithUnmarshalers(StructsWithOptions[Animal]([]Option{WithDiscriminatorPath("kind", "name")}, nil, (*DeepDog)(nil), (*DeepCat)(nil))),
+	)
+	qt.Assert(t, qt.IsTrue(errors.As(err, &valueErr)))
+	qt.Assert(t, qt.Equals(valueErr.Field, "kind.name"))
+}
+
+type schemaV1 = stringConst[struct {
+	string `const:"1"`
+}]
+
+type Square struct {
+	Type stringConst[struct {
+		string `const:"square"`
+	}] `json:"type"`
+	SchemaVersion schemaV1 `json:"schemaVersion"`
+	Side          float64  `json:"side"`
+}
+
+func (Square) isShape() {}
+
+type Circle struct {
+	Type stringConst[struct {
+		string `const:"circle"`
+	}] `json:"type"`
+	SchemaVersion schemaV1 `json:"schemaVersion"`
+	Radius        float64  `json:"radius"`
+}
+
+func (Circle) isShape() {}
+
+type Shape interface {
+	isShape()
+}
+
+// ShelterKind is an integer enum of the kind commonly
+// defined with iota.
+type ShelterKind int
+
+const (
+	ShelterDog ShelterKind = iota
+	ShelterCat
+	ShelterBird
+)
+
+func (k ShelterKind) String() string {
+	return [...]string{"dog", "cat", "bird"}[k]
+}
+
+type Resident interface {
+	isResident()
+}
+
+type ResidentDog struct {
+	Kind Const[ShelterKind, struct {
+		ShelterKind `const:"0"`
+	}] `json:"kind"`
+	Bark string
+}
+
+type ResidentCat struct {
+	Kind Const[ShelterKind, struct {
+		ShelterKind `const:"1"`
+	}] `json:"kind"`
+	Meow string
+}
+
+type ResidentBird struct {
+	Kind Const[ShelterKind, struct {
+		ShelterKind `const:"2"`
+	}] `json:"kind"`
+	Sing string
+}
+
+func (*ResidentDog) isResident()  {}
+func (*ResidentCat) isResident()  {}
+func (*ResidentBird) isResident() {}
+
+func TestStructsWithIotaEnum(t *testing.T) {
+	// The const tags must agree with the enum constants.
+	qt.Assert(t, qt.Equals(ResidentDog{}.Kind.Value(), ShelterDog))
+	qt.Assert(t, qt.Equals(ResidentCat{}.Kind.Value(), ShelterCat))
+	qt.Assert(t, qt.Equals(ResidentBird{}.Kind.Value(), ShelterBird))
+
+	unmarshalers := json.WithUnmarshalers(Structs[Resident](
+		(*ResidentDog)(nil),
+		(*ResidentCat)(nil),
+		(*ResidentBird)(nil),
+	))
+	tests := []struct {
+		name    string
+		json    string
+		want    Resident
+		wantErr string
+	}{
+		{
+			name: "first",
+			json: `{"kind":0,"Bark":"woof"}`,
+			want: &ResidentDog{Bark: "woof"},
+		},
+		{
+			name: "second",
+			json: `{"Meow":"purr","kind":1}`,
+			want: &ResidentCat{Meow: "purr"},
+		},
+		{
+			name: "float form",
+			json: `{"kind":2.0,"Sing":"tweet"}`,
+			want: &ResidentBird{Sing: "tweet"},
+		},
+		{
+			name: "exponent form",
+			json: `{"kind":1e0}`,
+			want: &ResidentCat{},
+		},
+		{
+			name:    "out of range",
+			json:    `{"kind":3}`,
+			wantErr: `.*unknown discriminator value 3 .*`,
+		},
+		{
+			name:    "string",
+			json:    `{"kind":"0"}`,
+			wantErr: `.*unknown discriminator value "0".*`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Resident
+			err := json.Unmarshal([]byte(tt.json), &got, unmarshalers)
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+
+			// Marshaling writes the enum as a number,
+			// so the value round-trips.
+			data, err := json.Marshal(got)
+			qt.Assert(t, qt.IsNil(err))
+			var again Resident
+			qt.Assert(t, qt.IsNil(json.Unmarshal(data, &again, unmarshalers)))
+			qt.Assert(t, qt.DeepEquals(again, got))
+		})
+	}
+}
+
+type Hound struct {
+	Bark string
+}
+
+// Beagle is an alias, so it is the same type as Hound.
+type Beagle = Hound
+
+// Basset is a defined type, so it is distinct from Hound.
+type Basset Hound
+
+func (*Hound) isAnimal()  {}
+func (*Basset) isAnimal() {}
+
+func TestStructsWithAliasAndDefinedTypes(t *testing.T) {
+	opts := []Option{WithTypeNameDiscriminator(LowerTypeName), WithDiscriminatorField("type")}
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[Animal](opts, nil, (*Hound)(nil), (*Beagle)(nil))
+	}, `choices 0 and 1 have the same type \*jsondiscrim.Hound`))
+	qt.Assert(t, qt.PanicMatches(func() {
+		Structs[Animal]((*Dog)(nil), (*Cat)(nil), (*Dog)(nil))
+	}, `choices 0 and 2 have the same type \*jsondiscrim.Dog`))
+
+	unmarshalers := json.WithUnmarshalers(StructsWithOptions[Animal](opts, nil, (*Hound)(nil), (*Basset)(nil)))
+	var got []Animal
+	err := json.Unmarshal([]byte(`[{"type":"hound","Bark":"a"},{"type":"basset","Bark":"b"}]`), &got, unmarshalers)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, []Animal{&Hound{Bark: "a"}, &Basset{Bark: "b"}}))
+}
+
+func TestStructsWithSharedConstField(t *testing.T) {
+	field, byValue, err := Discriminator[Shape]((*Square)(nil), (*Circle)(nil))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(field, "type"))
+	qt.Assert(t, qt.Equals(len(byValue), 2))
+
+	unmarshalers := json.WithUnmarshalers(Structs[Shape]((*Square)(nil), (*Circle)(nil)))
+	var got Shape
+	err = json.Unmarshal([]byte(`{"type":"circle","schemaVersion":"1","radius":2}`), &got, unmarshalers)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, Shape(&Circle{Radius: 2})))
+
+	// The non-discriminator const field is still checked.
+	err = json.Unmarshal([]byte(`{"type":"circle","schemaVersion":"2","radius":2}`), &got, unmarshalers)
+	qt.Assert(t, qt.ErrorMatches(err, `.*unexpected const value; got "2" but want "1"`))
+}
+
+type Tri1 struct {
+	A stringConst[struct {
+		string `const:"a1"`
+	}] `json:"a"`
+	B stringConst[struct {
+		string `const:"b1"`
+	}] `json:"b"`
+	C stringConst[struct {
+		string `const:"c1"`
+	}] `json:"c"`
+}
+
+type Tri2 struct {
+	A stringConst[struct {
+		string `const:"a2"`
+	}] `json:"a"`
+	B stringConst[struct {
+		string `const:"b2"`
+	}] `json:"b"`
+	C stringConst[struct {
+		string `const:"c2"`
+	}] `json:"c"`
+}
+
+func TestDiscriminatorAmbiguous(t *testing.T) {
+	_, _, err := Discriminator[any]((*Tri1)(nil), (*Tri2)(nil))
+	qt.Assert(t, qt.ErrorMatches(err, `ambiguous discriminator fields \[a b c\]; disambiguate with WithDiscriminatorField`))
+}
+
+func TestStructsWithField(t *testing.T) {
+	unmarshalers := json.WithUnmarshalers(StructsWithField[any]("b", (*Tri1)(nil), (*Tri2)(nil)))
+	var got any
+	err := json.Unmarshal([]byte(`{"b":"b2","a":"a2","c":"c2"}`), &got, unmarshalers)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, any(&Tri2{})))
+
+	// The other Const fields are still checked.
+	err = json.Unmarshal([]byte(`{"b":"b2","a":"a1"}`), &got, unmarshalers)
+	qt.Assert(t, qt.ErrorMatches(err, `.*unexpected const value; got "a1" but want "a2"`))
+
+	err = json.Unmarshal([]byte(`{"a":"a1"}`), &got, unmarshalers)
+	qt.Assert(t, qt.ErrorMatches(err, `.*discriminator field "b" not found`))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithField[Shape]("schemaVersion", Square{}, Circle{})
+	}, `field "schemaVersion" does not hold a different Const value in every choice`))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithField[any]("d", (*Tri1)(nil), (*Tri2)(nil))
+	}, `field "d" does not hold a different Const value in every choice`))
+}
+
+func TestWithDiscriminatorField(t *testing.T) {
+	unmarshalers := json.WithUnmarshalers(StructsWithOptions[any](
+		[]Option{WithDiscriminatorField("b")},
+		nil,
+		(*Tri1)(nil),
+		(*Tri2)(nil),
+	))
+	var got any
+	err := json.Unmarshal([]byte(`{"a":"a2","b":"b2","c":"c2"}`), &got, unmarshalers)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, any(&Tri2{})))
+
+	err = json.Unmarshal([]byte(`{"a":"a2"}`), &got, unmarshalers)
+	qt.Assert(t, qt.ErrorMatches(err, `.*discriminator field "b" not found`))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[any]([]Option{WithDiscriminatorField("d")}, nil, (*Tri1)(nil), (*Tri2)(nil))
+	}, `field "d" does not hold a different Const value in every choice`))
+}
+
+func TestPeekFieldValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		buf    string
+		want   any
+		wantOK bool
+	}{
+		{"first", `{"type":"dog","Bark":"woof"}`, "dog", true},
+		{"after delimiter", ` , {"type": 1}`, int64(1), true},
+		{"after colon", `:{"type":true}`, true, true},
+		{"not first", `{"Bark":"woof","type":"dog"}`, "dog", true},
+		{"escaped name", `{"t\u0079pe":"dog"}`, "dog", true},
+		{"escaped value", `{"type":"d\u006fg"}`, "dog", true},
+		{"object value", `{"type":{"a":1}}`, map[string]any{"a": int64(1)}, true},
+		{"nested not matched", `{"Owner":{"type":"person"}}`, nil, false},
+		{"beyond limit", `{"Bark":"` + strings.Repeat("w", maxPeek) + `","type":"dog"}`, nil, false},
+		{"truncated", `{"type":"do`, nil, false},
+		{"number without delimiter", `{"type":12`, nil, false},
+		{"number at limit", `{"Bark":"` + strings.Repeat("w", maxPeek-21) + `","type":12}`, int64(12), true},
+		{"number cut at limit", `{"Bark":"` + strings.Repeat("w", maxPeek-20) + `","type":123}`, nil, false},
+		{"empty object", `{}`, nil, false},
+		{"not object", `["type"]`, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := peekFieldValue([]byte(tt.buf), "type", false)
+			qt.Assert(t, qt.Equals(ok, tt.wantOK))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+
+	// Only the first member is looked at when asked.
+	got, ok := peekFieldValue([]byte(`{"type":"dog","Bark":"woof"}`), "type", true)
+	qt.Assert(t, qt.IsTrue(ok))
+	qt.Assert(t, qt.Equals(got, any("dog")))
+	_, ok = peekFieldValue([]byte(`{"Bark":"woof","type":"dog"}`), "type", true)
+	qt.Assert(t, qt.IsFalse(ok))
+}
+
+func TestPeekNumberAtLimit(t *testing.T) {
+	// The discriminator value ends exactly at the peek limit, so
+	// only a prefix of the longer one is within it.
+	unmarshalers := json.WithUnmarshalers(Structs[Coded]((*Code12)(nil), (*Code123)(nil)))
+	long := strings.Repeat("x", maxPeek-14)
+	data := `{"p":"` + long + `","k":123}`
+	qt.Assert(t, qt.Equals(strings.Index(data, "123")+2, maxPeek))
+	var got Coded
+	err := json.Unmarshal([]byte(data), &got, unmarshalers)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, Coded(&Code123{P: long})))
+
+	err = json.Unmarshal([]byte(`{"p":"`+long+`","k":12}`), &got, unmarshalers)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, Coded(&Code12{P: long})))
+}
+
+func TestStructsFromReader(t *testing.T) {
+	// Reading a byte at a time means that the decoder's buffer
+	// often does not hold the whole discriminator, exercising
+	// the buffered path as well as the direct one.
+	data := `[{"type":"dog","Bark":"a"},{"Meow":"b","type":"cat"},{"type":"cat","Meow":"c"}]`
+	unmarshalers := json.WithUnmarshalers(Structs[Animal]((*Dog)(nil), (*Cat)(nil)))
+	want := []Animal{&Dog{Bark: "a"}, &Cat{Meow: "b"}, &Cat{Meow: "c"}}
+	for _, r := range []io.Reader{
+		strings.NewReader(data),
+		iotest.OneByteReader(strings.NewReader(data)),
+	} {
+		var got []Animal
+		err := json.UnmarshalRead(r, &got, unmarshalers)
+		qt.Assert(t, qt.IsNil(err))
+		qt.Assert(t, qt.DeepEquals(got, want))
+	}
+}
+
+type Code12 struct {
+	Code Const[float64, struct {
+		float64 `const:"12"`
+	}] `json:"k"`
+	P string `json:"p,omitempty"`
+}
+
+func (*Code12) isCoded() {}
+
+type Code123 struct {
+	Code Const[float64, struct {
+		float64 `const:"123"`
+	}] `json:"k"`
+	P string `json:"p,omitempty"`
+}
+
+func (*Code123) isCoded() {}
+
+type Coded interface {
+	isCoded()
+}
+
+// chunkReader reads at most n bytes at a time from r.
+type chunkReader struct {
+	r io.Reader
+	n int
+}
+
+func (r chunkReader) Read(p []byte) (int, error) {
+	return r.r.Read(p[:min(len(p), r.n)])
+}
+
+func TestStructsFromReaderNumberAtChunkEnd(t *testing.T) {
+	// The first chunk ends within the discriminator value, so
+	// the decoder's buffer holds only a prefix of it.
+	data := `[{"k":123},{"k":12}]`
+	unmarshalers := json.WithUnmarshalers(Structs[Coded]((*Code12)(nil), (*Code123)(nil)))
+	var got []Coded
+	err := json.UnmarshalRead(chunkReader{strings.NewReader(data), 8}, &got, unmarshalers)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, []Coded{&Code123{}, &Code12{}}))
+}
+
+func BenchmarkStructs(b *testing.B) {
+	unmarshalers := json.WithUnmarshalers(Structs[Animal]((*Dog)(nil), (*Cat)(nil), (*Bird)(nil)))
+	for _, bm := range []struct {
+		name string
+		json string
+	}{
+		{"DiscriminatorFirst", `{"type":"dog","Bark":"woof woof woof woof woof woof woof woof"}`},
+		{"DiscriminatorLast", `{"Bark":"woof woof woof woof woof woof woof woof","type":"dog"}`},
+		{"LargeDiscriminatorFirst", `{"type":"dog","Bark":"` + strings.Repeat("woof ", 1<<20) + `"}`},
+		{"LargeDiscriminatorLast", `{"Bark":"` + strings.Repeat("woof ", 1<<20) + `","type":"dog"}`},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			data := []byte(bm.json)
+			b.ReportAllocs()
+			for b.Loop() {
+				var got Animal
+				if err := json.Unmarshal(data, &got, unmarshalers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkStructsArray(b *testing.B) {
+	unmarshalers := json.WithUnmarshalers(Structs[Animal]((*Dog)(nil), (*Cat)(nil), (*Bird)(nil)))
+	elems := []string{
+		`{"type":"dog","Bark":"woof"}`,
+		`{"type":"cat","Meow":"purr"}`,
+		`{"Bark":"woof","type":"dog"}`,
+	}
+	for _, bm := range []struct {
+		name  string
+		elems []string
+	}{
+		{"Homogeneous", elems[:1]},
+		{"Heterogeneous", elems[:2]},
+		{"DiscriminatorLast", elems[2:]},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			var buf strings.Builder
+			buf.WriteString("[")
+			for i := range 1000 {
+				if i > 0 {
+					buf.WriteString(",")
+				}
+				buf.WriteString(bm.elems[i%len(bm.elems)])
+			}
+			buf.WriteString("]")
+			data := []byte(buf.String())
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for b.Loop() {
+				var got []Animal
+				if err := json.Unmarshal(data, &got, unmarshalers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFieldValue(b *testing.B) {
+	for _, bm := range []struct {
+		name  string
+		json  string
+		field string
+	}{
+		{"DiscriminatorFirst", `{"type":"dog","Bark":"woof","Volume":11,"Tags":["a","b"]}`, "type"},
+		{"DiscriminatorLast", `{"Bark":"woof","Volume":11,"Tags":["a","b"],"type":"dog"}`, "type"},
+		{"Number", `{"Bark":"woof","kind":42}`, "kind"},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			data := []byte(bm.json)
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := fieldValue(data, bm.field); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestConstMalformedTag(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   func() any
+		wantErr string
+	}{{
+		name: "bool",
+		value: func() any {
+			return Const[bool, struct {
+				bool `const:"yes"`
+			}]{}.Value()
+		},
+		wantErr: `bool const tag must be "true" or "false", got "yes"`,
+	}, {
+		name: "int not a number",
+		value: func() any {
+			return Const[int, struct {
+				int `const:"forty-two"`
+			}]{}.Value()
+		},
+		wantErr: `integer const tag for int must be an integer in range, got "forty-two"`,
+	}, {
+		name: "int fraction",
+		value: func() any {
+			return Const[int, struct {
+				int `const:"4.2"`
+			}]{}.Value()
+		},
+		wantErr: `integer const tag for int must be integral, got "4.2"`,
+	}, {
+		name: "int exponent fraction",
+		value: func() any {
+			return Const[int, struct {
+				int `const:"15e-1"`
+			}]{}.Value()
+		},
+		wantErr: `integer const tag for int must be integral, got "15e-1"`,
+	}, {
+		name: "int8 exponent out of range",
+		value: func() any {
+			return Const[int8, struct {
+				int8 `const:"1e3"`
+			}]{}.Value()
+		},
+		wantErr: `integer const tag for int8 must be an integer in range, got "1e3"`,
+	}, {
+		name: "uint8 hex out of range",
+		value: func() any {
+			return Const[uint8, struct {
+				uint8 `const:"0x100"`
+			}]{}.Value()
+		},
+		wantErr: `unsigned integer const tag for uint8 must be a non-negative integer in range, got "0x100"`,
+	}, {
+		name: "int8 out of range",
+		value: func() any {
+			return Const[int8, struct {
+				int8 `const:"300"`
+			}]{}.Value()
+		},
+		wantErr: `integer const tag for int8 must be an integer in range, got "300"`,
+	}, {
+		name: "uint negative",
+		value: func() any {
+			return Const[uint, struct {
+				uint `const:"-1"`
+			}]{}.Value()
+		},
+		wantErr: `unsigned integer const tag for uint must be a non-negative integer in range, got "-1"`,
+	}, {
+		name: "float",
+		value: func() any {
+			return Const[float64, struct {
+				float64 `const:"one"`
+			}]{}.Value()
+		},
+		wantErr: `float const tag for float64 must be a JSON number, got "one"`,
+	}, {
+		name: "float NaN",
+		value: func() any {
+			return Const[float64, struct {
+				float64 `const:"NaN"`
+			}]{}.Value()
+		},
+		wantErr: `float const tag for float64 must be finite, got "NaN"`,
+	}, {
+		name: "float infinity",
+		value: func() any {
+			return Const[float32, struct {
+				float32 `const:"-Inf"`
+			}]{}.Value()
+		},
+		wantErr: `float const tag for float32 must be finite, got "-Inf"`,
+	}, {
+		name: "float NaN from method",
+		value: func() any {
+			return Const[lenientFloat, struct {
+				lenientFloat `const:"\"nan\""`
+			}]{}.Value()
+		},
+		wantErr: `float const tag for jsondiscrim.lenientFloat must be finite, got "\\"nan\\""`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qt.Assert(t, qt.PanicMatches(func() { tt.value() }, tt.wantErr))
+		})
+	}
+}
+
+// lenientFloat is a float type that accepts
+// special values such as NaN as JSON strings.
+type lenientFloat float64
+
+func (f *lenientFloat) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return json.Unmarshal(data, (*float64)(f))
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	*f = lenientFloat(v)
+	return err
+}
+
+func TestConstFloatZero(t *testing.T) {
+	type Point struct {
+		Origin Const[float64, struct {
+			float64 `const:"-0"`
+		}] `json:"origin"`
+	}
+	qt.Assert(t, qt.Equals(math.Signbit(Point{}.Origin.Value()), false))
+	for _, data := range []string{`{"origin":0}`, `{"origin":-0}`, `{"origin":0.0}`, `{"origin":-0e5}`} {
+		var p Point
+		err := json.Unmarshal([]byte(data), &p)
+		qt.Assert(t, qt.IsNil(err), qt.Commentf("%s", data))
+	}
+	data, err := json.Marshal(Point{})
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(data), `{"origin":0}`))
+}
+
+func TestConstIntegerNotation(t *testing.T) {
+	qt.Assert(t, qt.Equals(Const[int, struct {
+		int `const:"0xFF"`
+	}]{}.Value(), 255))
+	qt.Assert(t, qt.Equals(Const[int, struct {
+		int `const:"-0x10"`
+	}]{}.Value(), -16))
+	qt.Assert(t, qt.Equals(Const[int16, struct {
+		int16 `const:"0o17"`
+	}]{}.Value(), 15))
+	qt.Assert(t, qt.Equals(Const[int, struct {
+		int `const:"0b101"`
+	}]{}.Value(), 5))
+	qt.Assert(t, qt.Equals(Const[int, struct {
+		int `const:"1e3"`
+	}]{}.Value(), 1000))
+	qt.Assert(t, qt.Equals(Const[int64, struct {
+		int64 `const:"2.5E1"`
+	}]{}.Value(), 25))
+	qt.Assert(t, qt.Equals(Const[uint8, struct {
+		uint8 `const:"0xff"`
+	}]{}.Value(), 255))
+	qt.Assert(t, qt.Equals(Const[uint64, struct {
+		uint64 `const:"0xFFFFFFFFFFFFFFFF"`
+	}]{}.Value(), math.MaxUint64))
+	qt.Assert(t, qt.Equals(Const[uint, struct {
+		uint `const:"4e2"`
+	}]{}.Value(), 400))
+
+	// The JSON form of the discriminator is still decimal.
+	type Hex struct {
+		Kind Const[int, struct {
+			int `const:"0x10"`
+		}] `json:"kind"`
+	}
+	var got Hex
+	err := json.Unmarshal([]byte(`{"kind":16}`), &got)
+	qt.Assert(t, qt.IsNil(err))
+	data, err := json.Marshal(got)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(data), `{"kind":16}`))
+}
+
+func TestStructsInMap(t *testing.T) {
+	unmarshalers := json.WithUnmarshalers(Structs[Animal]((*Dog)(nil), (*Cat)(nil)))
+	var got map[string]Animal
+	err := json.Unmarshal([]byte(`{"rex":{"type":"dog","Bark":"woof"},"tom":{"Meow":"purr","type":"cat"}}`), &got, unmarshalers)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, map[string]Animal{
+		"rex": &Dog{Bark: "woof"},
+		"tom": &Cat{Meow: "purr"},
+	}))
+
+	err = json.Unmarshal([]byte(`{"rex":{"type":"bird"}}`), &got, unmarshalers)
+	qt.Assert(t, qt.ErrorMatches(err, `.* within "/rex": unknown discriminator value "bird".*`))
+
+	// Map keys are JSON strings, so they can never be union values.
+	var byAnimal map[Animal]int
+	err = json.Unmarshal([]byte(`{"rex":1}`), &byAnimal, unmarshalers)
+	qt.Assert(t, qt.ErrorMatches(err, `.*cannot unmarshal JSON string into jsondiscrim.Animal: expected object`))
+}
+
+func TestMustConst(t *testing.T) {
+	c := MustConst[int, struct {
+		int `const:"7"`
+	}]()
+	qt.Assert(t, qt.Equals(c.Value(), 7))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		MustConst[int, struct {
+			int `const:"seven"`
+		}]()
+	}, `integer const tag for int must be an integer in range, got "seven"`))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		MustConst[string, struct {
+			string
+		}]()
+	}, `const type argument field has no const tag`))
+}
+
+func TestDiscriminatorWithFallback(t *testing.T) {
+	field, byValue, fallbackType, err := DiscriminatorWithFallback[Animal]((*OtherAnimal)(nil), (*Dog)(nil), Cat{})
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(field, "type"))
+	qt.Assert(t, qt.CmpEquals(byValue, map[any]reflect.Type{
+		"dog": reflect.TypeFor[*Dog](),
+		"cat": reflect.TypeFor[Cat](),
+	}, cmp.Comparer(cmpWithEqual[reflect.Type])))
+	qt.Assert(t, qt.Equals(fallbackType, reflect.TypeFor[*OtherAnimal]()))
+
+	field, byValue, fallbackType, err = DiscriminatorWithFallback[Animal](nil, (*Dog)(nil))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(field, "type"))
+	qt.Assert(t, qt.Equals(len(byValue), 1))
+	qt.Assert(t, qt.IsNil(fallbackType))
+
+	field, byValue, fallbackType, err = DiscriminatorWithFallback[Animal]((*OtherAnimal)(nil))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(field, ""))
+	qt.Assert(t, qt.IsNil(byValue))
+	qt.Assert(t, qt.Equals(fallbackType, reflect.TypeFor[*OtherAnimal]()))
+
+	_, _, _, err = DiscriminatorWithFallback[Animal](nil)
+	qt.Assert(t, qt.ErrorMatches(err, `no choices provided to Structs`))
+}
+
+func TestFallbackCollision(t *testing.T) {
+	type CatLike struct {
+		BaseAnimal[struct {
+			string `const:"cat"`
+		}]
+		Extra string
+	}
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithFallback[any](&CatLike{}, (*Dog)(nil), (*Cat)(nil))
+	}, `fallback type \*jsondiscrim.CatLike declares discriminator value "cat" which collides with choice \*jsondiscrim.Cat`))
+
+	type Unknown struct {
+		BaseAnimal[struct {
+			string `const:"unknown"`
+		}]
+	}
+	// A value that no choice uses is fine.
+	StructsWithFallback[any](&Unknown{}, (*Dog)(nil), (*Cat)(nil))
+}
+
+// ExtraDog captures unknown members even though it is a known choice.
+type ExtraDog struct {
+	BaseAnimal[struct {
+		string `const:"dog"`
+	}]
+	Bark  string
+	Extra jsontext.Value `json:",unknown,embed"`
+}
+
+func (*ExtraDog) isAnimal() {}
+
+func TestChoiceUnknownMembers(t *testing.T) {
+	unmarshalers := json.WithUnmarshalers(Structs[Animal]((*ExtraDog)(nil), (*Cat)(nil)))
+	tests := []struct {
+		name string
+		json string
+		want Animal
+	}{
+		{"discriminator first", `{"type":"dog","Bark":"woof","Tail":true,"Age":3}`, &ExtraDog{
+			Bark:  "woof",
+			Extra: jsontext.Value(`{"Tail":true,"Age":3}`),
+		}},
+		{"discriminator last", `{"Tail":true,"Bark":"woof","type":"dog"}`, &ExtraDog{
+			Bark:  "woof",
+			Extra: jsontext.Value(`{"Tail":true}`),
+		}},
+		{"no unknown members", `{"type":"dog","Bark":"woof"}`, &ExtraDog{
+			Bark: "woof",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got, unmarshalers)
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+// distinctConst is a distinct Const type argument for each type P.
+type distinctConst[P any] struct {
+	string `const:"x"`
+}
+
+func distinctConstValue[P any]() string {
+	return Const[string, distinctConst[P]]{}.Value()
+}
+
+func TestConstConcurrentFirstUse(t *testing.T) {
+	// Each of these uses a Const type that has not been used before,
+	// so all the goroutines race to compute its value.
+	values := []func() string{
+		distinctConstValue[[0]int],
+		distinctConstValue[[1]int],
+		distinctConstValue[[2]int],
+		distinctConstValue[[3]int],
+		distinctConstValue[[4]int],
+		distinctConstValue[[5]int],
+		distinctConstValue[[6]int],
+		distinctConstValue[[7]int],
+	}
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Go(func() {
+			for _, value := range values {
+				if got := value(); got != "x" {
+					t.Errorf("got %q want %q", got, "x")
+				}
+			}
+		})
+	}
+	wg.Wait()
+}
+
+type Feature interface {
+	isFeature()
+}
+
+type EnabledFeature struct {
+	Enabled Const[bool, struct {
+		bool `const:"true"`
+	}] `json:"enabled"`
+	Level int `json:"level"`
+}
+
+func (*EnabledFeature) isFeature() {}
+
+type DisabledFeature struct {
+	Enabled Const[bool, struct {
+		bool `const:"false"`
+	}] `json:"enabled"`
+	Reason string `json:"reason"`
+}
+
+func (*DisabledFeature) isFeature() {}
+
+func TestBoolDiscriminator(t *testing.T) {
+	field, byValue, err := Discriminator[Feature]((*EnabledFeature)(nil), (*DisabledFeature)(nil))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(field, "enabled"))
+	qt.Assert(t, qt.CmpEquals(byValue, map[any]reflect.Type{
+		true:  reflect.TypeFor[*EnabledFeature](),
+		false: reflect.TypeFor[*DisabledFeature](),
+	}, cmp.Comparer(cmpWithEqual[reflect.Type])))
+
+	tests := []struct {
+		name    string
+		json    string
+		want    Feature
+		wantErr string
+	}{
+		{"true first", `{"enabled":true,"level":3}`, &EnabledFeature{Level: 3}, ""},
+		{"false first", `{"enabled":false,"reason":"off"}`, &DisabledFeature{Reason: "off"}, ""},
+		{"true last", `{"level":3,"enabled":true}`, &EnabledFeature{Level: 3}, ""},
+		{"false last", `{"reason":"off","enabled":false}`, &DisabledFeature{Reason: "off"}, ""},
+		{"string is not bool", `{"enabled":"true"}`, nil, `.*unknown discriminator value "true".*`},
+		{"null", `{"enabled":null}`, nil, `.*discriminator field "enabled" is null`},
+		{"missing", `{"level":3}`, nil, `.*discriminator field "enabled" not found`},
+	}
+	unmarshalers := json.WithUnmarshalers(Structs[Feature]((*EnabledFeature)(nil), (*DisabledFeature)(nil)))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Feature
+			err := json.Unmarshal([]byte(tt.json), &got, unmarshalers)
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestObjectDiscriminator(t *testing.T) {
+	for _, data := range []string{
+		`{"type":{"nested":true},"Bark":"woof"}`,
+		`{"Bark":"woof","type":{"nested":true}}`,
+	} {
+		var got Animal
+		err := json.Unmarshal([]byte(data), &got, json.WithUnmarshalers(Structs[Animal]((*Dog)(nil), (*Cat)(nil))))
+		qt.Assert(t, qt.ErrorMatches(err, `.*discriminator value is not a scalar: found JSON object`))
+
+		// With a fallback, the value is treated as unknown.
+		err = json.Unmarshal([]byte(data), &got, json.WithUnmarshalers(StructsWithFallback[Animal]((*OtherAnimal)(nil), (*Dog)(nil), (*Cat)(nil))))
+		qt.Assert(t, qt.ErrorMatches(err, `.*(cannot|unable to) unmarshal JSON object into Go string within "/type".*`))
+	}
+}
+
+func TestArrayDiscriminator(t *testing.T) {
+	for _, data := range []string{
+		`{"type":["dog"],"Bark":"woof"}`,
+		`{"Bark":"woof","type":["dog"]}`,
+	} {
+		var got Animal
+		err := json.Unmarshal([]byte(data), &got, json.WithUnmarshalers(Structs[Animal]((*Dog)(nil), (*Cat)(nil))))
+		qt.Assert(t, qt.ErrorMatches(err, `.*discriminator value is not a scalar: found JSON array`))
+		var nsErr *NotScalarError
+		qt.Assert(t, qt.ErrorAs(err, &nsErr))
+		qt.Assert(t, qt.Equals(nsErr.Kind, '['))
+	}
+}
+
+func TestCompositeKeyNotScalar(t *testing.T) {
+	type Keyed struct {
+		Key Const[string, struct {
+			string `const:"a"`
+		}] `json:"-"`
+	}
+	var got any
+	err := json.Unmarshal([]byte(`{"x":1}`), &got, json.WithUnmarshalers(StructsWithOptions[any](
+		[]Option{WithCompositeKey([]string{"x"}, func(values []any) any { return values })},
+		nil,
+		Keyed{},
+	)))
+	qt.Assert(t, qt.ErrorMatches(err, `.*: discriminator value is not a scalar`))
+}
+
+func TestExplainDiscriminator(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		choices    []any
+		wantField  string
+		wantReason string
+		wantErr    string
+	}{{
+		name:       "only field",
+		choices:    []any{(*Dog)(nil), (*Cat)(nil)},
+		wantField:  "type",
+		wantReason: "it is the only Const field with a different value in every choice",
+	}, {
+		name:       "other fields",
+		choices:    []any{Square{}, Circle{}},
+		wantField:  "type",
+		wantReason: "it is the only Const field with a different value in every choice; other Const fields [schemaVersion] are not",
+	}, {
+		name:       "explicit field",
+		opts:       []Option{WithDiscriminatorField("b")},
+		choices:    []any{Tri1{}, Tri2{}},
+		wantField:  "b",
+		wantReason: "it is given by WithDiscriminatorField",
+	}, {
+		name:       "first match",
+		opts:       []Option{WithFirstMatch()},
+		choices:    []any{(*Dog)(nil), (*Cat)(nil), (*DogV2)(nil)},
+		wantField:  "type",
+		wantReason: "it is present in every choice and distinguishes the most choices (2 of 3)",
+	}, {
+		name:       "literals only",
+		opts:       []Option{WithDiscriminatorField("type"), WithLiteral((*Horse)(nil), Literal("horse"))},
+		wantField:  "type",
+		wantReason: "it is given by WithDiscriminatorField",
+	}, {
+		name:    "ambiguous",
+		choices: []any{Tri1{}, Tri2{}},
+		wantErr: `ambiguous discriminator fields \[a b c\]; disambiguate with WithDiscriminatorField`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field, reason, err := ExplainDiscriminator(tt.opts, tt.choices...)
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.Equals(field, tt.wantField))
+			qt.Assert(t, qt.Equals(reason, tt.wantReason))
+		})
+	}
+}
+
 func cmpWithEqual[T comparable](x, y T) bool {
 	return x == y
 }
@@ -0,0 +1,127 @@
+package jsondiscrim
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+)
+
+// NotObjectError is returned when a union value is not a JSON object
+// and so cannot contain a discriminator field.
+type NotObjectError struct {
+	// Type holds the interface type being unmarshaled into.
+	Type reflect.Type
+	// Kind holds the kind of the JSON value that was found.
+	Kind jsontext.Kind
+}
+
+func (e *NotObjectError) Error() string {
+	return fmt.Sprintf("cannot unmarshal JSON %s into %v: expected object", kindName(e.Kind), e.Type)
+}
+
+// KindError is returned when [WithKindDiscriminator] is used and
+// there is no choice for the kind of a JSON value.
+type KindError struct {
+	// Type holds the interface type being unmarshaled into.
+	Type reflect.Type
+	// Kind holds the kind of the JSON value that was found.
+	Kind jsontext.Kind
+}
+
+func (e *KindError) Error() string {
+	return fmt.Sprintf("cannot unmarshal JSON %s into %v: no choice for that kind", kindName(e.Kind), e.Type)
+}
+
+// MissingFieldError is returned when a JSON object does not contain
+// the discriminator field.
+type MissingFieldError struct {
+	// Field holds the JSON name of the discriminator field.
+	Field string
+}
+
+func (e *MissingFieldError) Error() string {
+	return fmt.Sprintf("discriminator field %q not found", e.Field)
+}
+
+// UnknownValueError is returned when the discriminator value in a
+// JSON object does not match that of any choice and there is no other
+// way of handling the object, such as a fallback.
+type UnknownValueError struct {
+	// Field holds the JSON name of the discriminator field.
+	Field string
+	// Got holds the discriminator value that was found.
+	Got any
+	// Valid holds the discriminator values of the choices,
+	// ordered by their printed form, or nil if they are not known.
+	Valid []any
+}
+
+func (e *UnknownValueError) Error() string {
+	if e.Valid == nil {
+		return fmt.Sprintf("unknown discriminator value %#v", e.Got)
+	}
+	return fmt.Sprintf("unknown discriminator value %#v (valid values are %v)", e.Got, e.Valid)
+}
+
+// NotScalarError is returned when the discriminator value in a JSON
+// object is not a scalar, and so cannot match the value of any
+// [Const] field.
+type NotScalarError struct {
+	// Kind holds the kind of the JSON value that was found,
+	// or 0 if the value was computed by [WithCompositeKey].
+	Kind jsontext.Kind
+}
+
+func (e *NotScalarError) Error() string {
+	if e.Kind == 0 {
+		return "discriminator value is not a scalar"
+	}
+	return fmt.Sprintf("discriminator value is not a scalar: found JSON %s", kindName(e.Kind))
+}
+
+// ReadLimitError is returned when reading from a reader returned by
+// [LimitReader] would exceed its limit.
+type ReadLimitError struct {
+	// Limit holds the maximum number of bytes that may be read.
+	Limit int64
+}
+
+func (e *ReadLimitError) Error() string {
+	return fmt.Sprintf("read limit of %d bytes exceeded", e.Limit)
+}
+
+// relocateError returns err adjusted so that any position it holds,
+// which is relative to a JSON value starting at byte offset start with
+// the JSON pointer ptr, is relative to the whole input instead.
+func relocateError(err error, start int64, ptr jsontext.Pointer) error {
+	var semErr *json.SemanticError
+	if errors.As(err, &semErr) {
+		e := *semErr
+		e.ByteOffset += start
+		e.JSONPointer = ptr + e.JSONPointer
+		return &e
+	}
+	var synErr *jsontext.SyntacticError
+	if errors.As(err, &synErr) {
+		e := *synErr
+		e.ByteOffset += start
+		e.JSONPointer = ptr + e.JSONPointer
+		return &e
+	}
+	return err
+}
+
+func kindName(k jsontext.Kind) string {
+	switch k {
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case 't', 'f':
+		return "boolean"
+	}
+	return k.String()
+}
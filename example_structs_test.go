@@ -1,3 +1,8 @@
+// The examples use github.com/go-json-experiment/json directly, as
+// code using this package would, so they build only when it does.
+
+//go:build !goexperiment.jsonv2 || !go1.27
+
 package jsondiscrim_test
 
 import (
@@ -0,0 +1,75 @@
+// The examples use github.com/go-json-experiment/json directly, as
+// code using this package would, so they build only when it does.
+
+//go:build !goexperiment.jsonv2 || !go1.27
+
+package jsondiscrim_test
+
+import (
+	"fmt"
+
+	"github.com/go-json-experiment/json"
+
+	"github.com/cue-exp/jsondiscrim"
+)
+
+// URLMessage is a narrower interface implemented only by the
+// [Message] types that refer to a URL.
+type URLMessage interface {
+	Message
+	Location() string
+}
+
+func (m *ImageMessage) Location() string {
+	return m.URL
+}
+
+func (m *LinkMessage) Location() string {
+	return m.URL
+}
+
+// Post holds a message together with a pinned message that must
+// refer to a URL.
+type Post struct {
+	Pinned URLMessage `json:"pinned"`
+	Body   Message    `json:"body"`
+}
+
+// This example demonstrates how a union over a narrower interface can
+// be used alongside a union over the broader interface by joining
+// their unmarshalers.
+func Example_subUnion() {
+	unmarshalers := json.JoinUnmarshalers(
+		jsondiscrim.Structs[URLMessage](
+			(*ImageMessage)(nil),
+			(*LinkMessage)(nil),
+		),
+		jsondiscrim.Structs[Message](
+			(*TextMessage)(nil),
+			(*ImageMessage)(nil),
+			(*LinkMessage)(nil),
+		),
+	)
+
+	postJSON := `{
+		"pinned": {"type":"link","url":"https://example.com","text":"Home"},
+		"body": {"type":"text","text":"See the pinned link"}
+	}`
+	var post Post
+	if err := json.Unmarshal([]byte(postJSON), &post, json.WithUnmarshalers(unmarshalers)); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	fmt.Println(post.Pinned.Location())
+	fmt.Println(post.Body.Format())
+
+	// A text message does not implement URLMessage, so it is
+	// rejected as a pinned message.
+	err := json.Unmarshal([]byte(`{"pinned": {"type":"text","text":"hello"}}`), &post, json.WithUnmarshalers(unmarshalers))
+	fmt.Println(err != nil)
+
+	// Output:
+	// https://example.com
+	// Text: See the pinned link
+	// true
+}
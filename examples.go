@@ -0,0 +1,45 @@
+package jsondiscrim
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+)
+
+// Examples returns an example JSON object for each of the given
+// choices, following the rules documented in [Structs], keyed by the
+// choice's discriminator value. Aliases of that value, as given by
+// further const tags, have no examples of their own. Each example is
+// the JSON form of the zero value of the choice, so it holds the
+// discriminator field and all the other fields with their zero values.
+// Unmarshaling it with the unmarshalers returned by [Structs] chooses
+// the same type again.
+//
+// The output is deterministic, so the examples are suitable for use as
+// test fixtures or in documentation. Like [Structs], Examples panics
+// if the choices are not well formed.
+func Examples[T any](choices ...T) map[any]jsontext.Value {
+	discrimField, byValue, err := Discriminator(choices...)
+	if err != nil {
+		panic(err)
+	}
+	examples := make(map[any]jsontext.Value)
+	for v, t := range byValue {
+		if v != constFields(t)[discrimField] {
+			// v is an alias.
+			continue
+		}
+		zero := reflect.New(t)
+		if t.Kind() == reflect.Pointer {
+			zero = reflect.New(t.Elem())
+		}
+		data, err := json.Marshal(zero.Interface(), json.Deterministic(true))
+		if err != nil {
+			panic(fmt.Errorf("cannot marshal example for %v: %v", t, err))
+		}
+		examples[v] = data
+	}
+	return examples
+}
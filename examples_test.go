@@ -0,0 +1,44 @@
+package jsondiscrim
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestExamples(t *testing.T) {
+	examples := Examples[Animal]((*Dog)(nil), (*Cat)(nil), Bird{})
+	qt.Assert(t, qt.DeepEquals(examples, map[any]jsontext.Value{
+		"dog":  jsontext.Value(`{"type":"dog","Bark":""}`),
+		"cat":  jsontext.Value(`{"type":"cat","Meow":""}`),
+		"bird": jsontext.Value(`{"type":"bird","Sing":""}`),
+	}))
+
+	// Each example unmarshals as its own choice.
+	_, byValue, err := Discriminator[Animal]((*Dog)(nil), (*Cat)(nil), Bird{})
+	qt.Assert(t, qt.IsNil(err))
+	unmarshalers := json.WithUnmarshalers(Structs[Animal]((*Dog)(nil), (*Cat)(nil), Bird{}))
+	for v, data := range examples {
+		var got Animal
+		err := json.Unmarshal(data, &got, unmarshalers)
+		qt.Assert(t, qt.IsNil(err))
+		qt.Assert(t, qt.Equals(reflect.TypeOf(got), byValue[v]))
+	}
+}
+
+func TestExamplesAliases(t *testing.T) {
+	examples := Examples[Animal]((*AliasDog)(nil), (*Cat)(nil))
+	qt.Assert(t, qt.DeepEquals(examples, map[any]jsontext.Value{
+		"dog": jsontext.Value(`{"type":"dog","Bark":""}`),
+		"cat": jsontext.Value(`{"type":"cat","Meow":""}`),
+	}))
+}
+
+func TestExamplesInvalid(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		Examples[Animal]((*Dog)(nil), (*Dog)(nil))
+	}, `cannot determine discriminator.*`))
+}
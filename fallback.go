@@ -0,0 +1,40 @@
+package jsondiscrim
+
+import (
+	"reflect"
+	"sync"
+)
+
+var fallbackByType sync.Map // reflect.Type -> fallback value
+
+// RegisterFallback registers fallback as the fallback choice used by
+// subsequent calls to [Structs] for the interface type T, so that it
+// need not be given everywhere the union is used. Functions that take
+// an explicit fallback, such as [StructsWithFallback], are unaffected.
+// Registering another fallback for T replaces it.
+//
+// The registration is global to the program, so it is best done
+// during initialization by the package that defines T. Use
+// [ClearFallback] to remove it, for example at the end of a test.
+func RegisterFallback[T any](fallback T) {
+	if isNil(fallback) {
+		panic("nil fallback provided to RegisterFallback")
+	}
+	fallbackByType.Store(reflect.TypeFor[T](), fallback)
+}
+
+// ClearFallback removes any fallback registered for T with
+// [RegisterFallback].
+func ClearFallback[T any]() {
+	fallbackByType.Delete(reflect.TypeFor[T]())
+}
+
+// registeredFallback returns the fallback registered for T,
+// or the zero T if there is none.
+func registeredFallback[T any]() T {
+	fallback, ok := fallbackByType.Load(reflect.TypeFor[T]())
+	if !ok {
+		return *new(T)
+	}
+	return fallback.(T)
+}
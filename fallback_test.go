@@ -0,0 +1,38 @@
+package jsondiscrim
+
+import (
+	"testing"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/go-quicktest/qt"
+)
+
+func TestRegisterFallback(t *testing.T) {
+	data := []byte(`[{"type":"dog"},{"type":"bird"}]`)
+	var got []Animal
+
+	RegisterFallback[Animal]((*OtherAnimal)(nil))
+	defer ClearFallback[Animal]()
+	err := json.Unmarshal(data, &got, json.WithUnmarshalers(Structs[Animal]((*Dog)(nil), (*Cat)(nil))))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, []Animal{&Dog{}, &OtherAnimal{Type: "bird"}}))
+
+	// An explicit fallback takes precedence.
+	err = json.Unmarshal(data, &got, json.WithUnmarshalers(StructsWithFallback[Animal](OtherAnimal{}, (*Dog)(nil), (*Cat)(nil))))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, []Animal{&Dog{}, OtherAnimal{Type: "bird"}}))
+
+	// Other interface types are unaffected.
+	err = json.Unmarshal([]byte(`{"Kind":"boat"}`), new(Vehicle), json.WithUnmarshalers(Structs[Vehicle](Car{}, Bike{})))
+	qt.Assert(t, qt.ErrorMatches(err, `.*unknown discriminator value "boat".*`))
+
+	ClearFallback[Animal]()
+	err = json.Unmarshal(data, &got, json.WithUnmarshalers(Structs[Animal]((*Dog)(nil), (*Cat)(nil))))
+	qt.Assert(t, qt.ErrorMatches(err, `.*unknown discriminator value "bird".*`))
+}
+
+func TestRegisterNilFallback(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		RegisterFallback[Animal](nil)
+	}, `nil fallback provided to RegisterFallback`))
+}
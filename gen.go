@@ -0,0 +1,391 @@
+package jsondiscrim
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"go/format"
+	"io"
+	"maps"
+	"path"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+)
+
+// GenerateConfig holds the configuration for [Generate].
+type GenerateConfig struct {
+	// Package holds the import path of the package
+	// that the generated code will belong to.
+	Package string
+
+	// PackageName holds the name of that package.
+	PackageName string
+
+	// Func holds the name of the generated function.
+	Func string
+}
+
+// Generate writes to w the source of a Go file holding a function that
+// unmarshals a value of the union described by the given fallback and
+// choices, following the rules documented in [StructsWithFallback]. As
+// the discriminator field and the type for each of its values are
+// fixed when the code is generated, the function does not need to use
+// reflection to choose the type, which can make a difference when
+// unmarshaling very many values. Like the unmarshaler returned by
+// [Structs], it unmarshals an object directly from the decoder when
+// the discriminator is near its start, and otherwise reads the whole
+// object first.
+//
+// The generated function has the signature
+//
+//	func(d *jsontext.Decoder, dst *T) error
+//
+// so it can be passed to [json.UnmarshalFromFunc]. The code imports
+// whichever JSON packages this package itself uses, either
+// encoding/json/v2 or github.com/go-json-experiment/json, so it should
+// be generated with the same build configuration as the code that uses
+// it. The fallback may be
+// nil, in which case there is no fallback choice. No options are
+// supported, and T (unless it is any) and the choices must all be
+// named types (or pointers to them) that are not generic, with
+// distinct names.
+//
+// Generate is usually called from a program run by go generate;
+// the jsondiscrim-gen command writes and runs such a program.
+func Generate[T any](w io.Writer, cfg GenerateConfig, fallback T, choices ...T) error {
+	typ := reflect.TypeFor[T]()
+	if typ.Kind() != reflect.Interface {
+		return fmt.Errorf("type %v is not an interface type", typ)
+	}
+	if cfg.PackageName == "" || cfg.Func == "" {
+		return fmt.Errorf("package name or function name not provided to Generate")
+	}
+	u, err := newUnion(&options{}, typ, fallback, choices)
+	if err != nil {
+		return err
+	}
+	if u.discrimField == "" {
+		return fmt.Errorf("no discriminator field found")
+	}
+	if err := checkTypeNames(u); err != nil {
+		return err
+	}
+	g := &generator{
+		pkg:     cfg.Package,
+		imports: make(map[string]string),
+	}
+	rt := g.qualify(thisPackage, "jsondiscrim")
+	g.qualify(jsonImportPath, "json")
+	g.qualify(jsontextImportPath, "jsontext")
+	ifaceName, err := g.typeName(typ)
+	if err != nil {
+		return err
+	}
+
+	// Sort the cases so that the output is deterministic.
+	cases := make(map[string]reflect.Type)
+	for v, t := range u.discrimByValue {
+		lit, err := valueLiteral(v)
+		if err != nil {
+			return err
+		}
+		cases[lit] = t
+	}
+	lits := slices.Sorted(maps.Keys(cases))
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "func %s(d *jsontext.Decoder, dst *%s) error {\n", cfg.Func, ifaceName)
+	// When the discriminator is near the start of the object,
+	// unmarshal directly from d without buffering the value.
+	fmt.Fprintf(&body, "if v, ok := %sPeekDiscriminatorValue(d, %q); ok {\nswitch v {\n", rt, u.discrimField)
+	for _, lit := range lits {
+		fmt.Fprintf(&body, "case %s:\n", lit)
+		if err := g.decodeCase(&body, cases[lit]); err != nil {
+			return err
+		}
+	}
+	if u.fallbackType != nil {
+		body.WriteString("default:\n")
+		if err := g.decodeCase(&body, u.fallbackType); err != nil {
+			return err
+		}
+	}
+	body.WriteString("}\n}\n")
+	body.WriteString("raw, err := d.ReadValue()\nif err != nil {\nreturn err\n}\n")
+	if u.fallbackType != nil {
+		g.qualify("errors", "errors")
+		fmt.Fprintf(&body, "var v any\nif raw.Kind() == '{' {\n")
+		fmt.Fprintf(&body, "v, err = %sDiscriminatorValue(raw, %q)\n", rt, u.discrimField)
+		fmt.Fprintf(&body, "if err != nil && !errors.As(err, new(*%sMissingFieldError)) {\nreturn err\n}\n}\n", rt)
+	} else {
+		g.qualify("reflect", "reflect")
+		fmt.Fprintf(&body, "if raw.Kind() != '{' {\nreturn &%sNotObjectError{Type: reflect.TypeFor[%s](), Kind: raw.Kind()}\n}\n", rt, ifaceName)
+		fmt.Fprintf(&body, "v, err := %sDiscriminatorValue(raw, %q)\nif err != nil {\nreturn err\n}\n", rt, u.discrimField)
+	}
+	body.WriteString("switch v {\n")
+	for _, lit := range lits {
+		fmt.Fprintf(&body, "case %s:\n", lit)
+		if err := g.unmarshalCase(&body, rt, cases[lit]); err != nil {
+			return err
+		}
+	}
+	body.WriteString("default:\n")
+	if u.fallbackType != nil {
+		if err := g.unmarshalCase(&body, rt, u.fallbackType); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(&body, "return &%sUnknownValueError{Field: %q, Got: v, Valid: []any{%s}}\n", rt, u.discrimField, strings.Join(lits, ", "))
+	}
+	body.WriteString("}\nreturn nil\n}\n")
+
+	var out bytes.Buffer
+	out.WriteString("// Code generated by jsondiscrim-gen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&out, "package %s\n\n", cfg.PackageName)
+	out.WriteString("import (\n")
+	// Put the standard library packages first, as goimports does.
+	paths := slices.SortedFunc(maps.Keys(g.imports), func(p1, p2 string) int {
+		return cmp.Or(cmp.Compare(importGroup(p1), importGroup(p2)), cmp.Compare(p1, p2))
+	})
+	for i, p := range paths {
+		if i > 0 && importGroup(p) != importGroup(paths[i-1]) {
+			out.WriteString("\n")
+		}
+		if name := g.imports[p]; name != path.Base(p) {
+			fmt.Fprintf(&out, "%s %q\n", name, p)
+		} else {
+			fmt.Fprintf(&out, "%q\n", p)
+		}
+	}
+	out.WriteString(")\n\n")
+	fmt.Fprintf(&out, "// %s unmarshals a value of the union type %s.\n", cfg.Func, ifaceName)
+	out.Write(body.Bytes())
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return fmt.Errorf("cannot format generated code: %v", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// checkTypeNames checks that the choices of u have distinct type
+// names, as code and other artifacts generated for a union are usually
+// named after the types of its choices.
+func checkTypeNames(u *union) error {
+	types := slices.Collect(maps.Values(u.discrimByValue))
+	if u.fallbackType != nil {
+		types = append(types, u.fallbackType)
+	}
+	// Sort the types so that the error is deterministic.
+	slices.SortFunc(types, func(t1, t2 reflect.Type) int {
+		return cmp.Compare(qualifiedName(t1), qualifiedName(t2))
+	})
+	byName := make(map[string]reflect.Type)
+	for _, t := range types {
+		st := structType(t)
+		if t1, ok := byName[st.Name()]; ok && structType(t1) != st {
+			return fmt.Errorf("choices %s and %s have the same type name; give one of them a different name", qualifiedName(t1), qualifiedName(t))
+		}
+		byName[st.Name()] = t
+	}
+	return nil
+}
+
+// qualifiedName returns the name of t qualified by
+// its full package path.
+func qualifiedName(t reflect.Type) string {
+	st := structType(t)
+	name := st.PkgPath() + "." + st.Name()
+	if t.Kind() == reflect.Pointer {
+		name = "*" + name
+	}
+	return name
+}
+
+// jsonImportPath and jsontextImportPath hold the import paths of the
+// JSON packages used by the generated code, which are those used by
+// this package.
+var jsonImportPath, jsontextImportPath = json.ImportPath, jsontext.ImportPath
+
+// thisPackage holds the import path of this package.
+var thisPackage = reflect.TypeFor[GenerateConfig]().PkgPath()
+
+// generator holds the state used by [Generate].
+type generator struct {
+	// pkg holds the import path of the generated code.
+	pkg string
+	// imports maps import paths to package names.
+	imports map[string]string
+}
+
+// qualify records that the package with the given import path and name
+// is used by the generated code, and returns the prefix for qualified
+// identifiers in that package.
+func (g *generator) qualify(importPath, name string) string {
+	if importPath == g.pkg {
+		return ""
+	}
+	g.imports[importPath] = name
+	return name + "."
+}
+
+// typeName returns the name by which the generated code refers to t.
+func (g *generator) typeName(t reflect.Type) (string, error) {
+	star := ""
+	if t.Kind() == reflect.Pointer {
+		star, t = "*", t.Elem()
+	}
+	if t == reflect.TypeFor[any]() {
+		return "any", nil
+	}
+	if t.Name() == "" || t.PkgPath() == "" {
+		return "", fmt.Errorf("type %v is not a named type", t)
+	}
+	if strings.Contains(t.Name(), "[") {
+		return "", fmt.Errorf("type %v is generic", t)
+	}
+	if t.PkgPath() != g.pkg && !isExported(t.Name()) {
+		return "", fmt.Errorf("type %v is not exported from its package", t)
+	}
+	name, ok := g.imports[t.PkgPath()]
+	if !ok {
+		name = path.Base(t.PkgPath())
+		// Use a different name for each package, avoiding
+		// those of the packages used by the generated code.
+		used := append(slices.Collect(maps.Values(g.imports)), "errors", "fmt", "reflect", "jsondiscrim")
+		for i := 0; !isIdentifier(name) || slices.Contains(used, name); i++ {
+			name = "pkg" + strconv.Itoa(i)
+		}
+	}
+	return star + g.qualify(t.PkgPath(), name) + t.Name(), nil
+}
+
+// unmarshalCase writes the code that unmarshals raw into a value
+// of type t and stores it in *dst.
+func (g *generator) unmarshalCase(w *bytes.Buffer, rt string, t reflect.Type) error {
+	name, err := g.typeName(t)
+	if err != nil {
+		return err
+	}
+	if t.Kind() == reflect.Pointer {
+		fmt.Fprintf(w, "x := new(%s)\n", strings.TrimPrefix(name, "*"))
+		w.WriteString("if err := json.Unmarshal(raw, x, d.Options()); err != nil {\n")
+	} else {
+		fmt.Fprintf(w, "var x %s\n", name)
+		w.WriteString("if err := json.Unmarshal(raw, &x, d.Options()); err != nil {\n")
+	}
+	fmt.Fprintf(w, "return %sRelocateError(err, d, raw)\n}\n*dst = x\n", rt)
+	return nil
+}
+
+// decodeCase writes the code that unmarshals the next value from d
+// into a value of type t, stores it in *dst and returns.
+func (g *generator) decodeCase(w *bytes.Buffer, t reflect.Type) error {
+	name, err := g.typeName(t)
+	if err != nil {
+		return err
+	}
+	if t.Kind() == reflect.Pointer {
+		fmt.Fprintf(w, "x := new(%s)\n", strings.TrimPrefix(name, "*"))
+		w.WriteString("if err := json.UnmarshalDecode(d, x); err != nil {\n")
+	} else {
+		fmt.Fprintf(w, "var x %s\n", name)
+		w.WriteString("if err := json.UnmarshalDecode(d, &x); err != nil {\n")
+	}
+	w.WriteString("return err\n}\n*dst = x\nreturn nil\n")
+	return nil
+}
+
+// valueLiteral returns a Go expression for the canonical
+// discriminator value v that compares equal to v when converted
+// to an interface.
+func valueLiteral(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return fmt.Sprintf("int64(%d)", v), nil
+	case uint64:
+		return fmt.Sprintf("uint64(%d)", v), nil
+	case float64:
+		return fmt.Sprintf("float64(%s)", strconv.FormatFloat(v, 'g', -1, 64)), nil
+	}
+	return "", fmt.Errorf("cannot generate code for discriminator value %#v of type %T", v, v)
+}
+
+// importGroup returns 0 for standard library import paths
+// and 1 for others.
+func importGroup(importPath string) int {
+	first, _, _ := strings.Cut(importPath, "/")
+	if strings.Contains(first, ".") {
+		return 1
+	}
+	return 0
+}
+
+func isExported(name string) bool {
+	return name != "" && strings.ToUpper(name[:1]) == name[:1]
+}
+
+func isIdentifier(name string) bool {
+	for i, r := range name {
+		if !(r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || i > 0 && '0' <= r && r <= '9') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// DiscriminatorValue returns the value of the discriminator field
+// named field in the JSON object raw, in the canonical form used to
+// look up discriminator values: strings and booleans are returned as
+// such, integers as int64 (or uint64 when too large for int64), and
+// other numbers as float64. It is used by the code written by
+// [Generate].
+//
+// It returns a [*MissingFieldError] if the field is absent, and a
+// [*NotScalarError] if its value is an object or array.
+func DiscriminatorValue(raw jsontext.Value, field string) (any, error) {
+	v, err := fieldValue(raw, field)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkScalar(v, true); err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, fmt.Errorf("discriminator field %q is null", field)
+	}
+	return v, nil
+}
+
+// PeekDiscriminatorValue returns the value of the discriminator field
+// named field in the JSON object that is the next value in d, in the
+// canonical form returned by [DiscriminatorValue], when it can be found
+// in the data that d has already buffered, without consuming any of it.
+// It reports whether the value was found that way, which is possible
+// when the field is near the start of the object and its value is a
+// scalar other than null. It is used by the code written by [Generate]
+// to unmarshal the object directly from d.
+func PeekDiscriminatorValue(d *jsontext.Decoder, field string) (any, bool) {
+	v, ok := peekDiscrim(d, field, false)
+	if !ok || v == nil {
+		return nil, false
+	}
+	return v, true
+}
+
+// RelocateError returns err, which was returned when unmarshaling raw,
+// the value just read from d, with any position it holds made relative
+// to the whole input read by d. It is used by the code written by
+// [Generate].
+func RelocateError(err error, d *jsontext.Decoder, raw jsontext.Value) error {
+	return relocateError(err, d.InputOffset()-int64(len(raw)), d.StackPointer())
+}
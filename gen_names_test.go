@@ -0,0 +1,36 @@
+package jsondiscrim_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/cue-exp/jsondiscrim"
+	pets1 "github.com/cue-exp/jsondiscrim/internal/gentest/one/pets"
+	pets2 "github.com/cue-exp/jsondiscrim/internal/gentest/two/pets"
+)
+
+var genConfig = jsondiscrim.GenerateConfig{
+	Package:     "example.com/zoo",
+	PackageName: "zoo",
+	Func:        "unmarshalPet",
+}
+
+func TestGenerateSameTypeName(t *testing.T) {
+	var buf bytes.Buffer
+	err := jsondiscrim.Generate[any](&buf, genConfig, nil, pets1.Pet{}, (*pets2.Pet)(nil))
+	qt.Assert(t, qt.ErrorMatches(err, `choices \*github.com/cue-exp/jsondiscrim/internal/gentest/two/pets.Pet and github.com/cue-exp/jsondiscrim/internal/gentest/one/pets.Pet have the same type name; give one of them a different name`))
+}
+
+func TestGenerateSamePackageName(t *testing.T) {
+	var buf bytes.Buffer
+	err := jsondiscrim.Generate[any](&buf, genConfig, nil, pets1.Dog{}, pets2.Cat{})
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.StringContains(buf.String(), `
+	pkg0 "github.com/cue-exp/jsondiscrim/internal/gentest/one/pets"
+	"github.com/cue-exp/jsondiscrim/internal/gentest/two/pets"
+`))
+	qt.Assert(t, qt.StringContains(buf.String(), `var x pkg0.Dog`))
+	qt.Assert(t, qt.StringContains(buf.String(), `var x pets.Cat`))
+}
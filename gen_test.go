@@ -0,0 +1,151 @@
+package jsondiscrim
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/go-quicktest/qt"
+)
+
+//go:generate go test -run TestGenerate -update
+
+var update = flag.Bool("update", false, "update generated test files")
+
+func TestGenerate(t *testing.T) {
+	// The generated files import the internal JSON packages
+	// so that they build whichever packages those use.
+	defer func(p1, p2 string) {
+		jsonImportPath, jsontextImportPath = p1, p2
+	}(jsonImportPath, jsontextImportPath)
+	jsonImportPath = thisPackage + "/internal/json"
+	jsontextImportPath = thisPackage + "/internal/jsontext"
+
+	tests := []struct {
+		file     string
+		fn       string
+		fallback Animal
+	}{{
+		file: "animal_gen_test.go",
+		fn:   "unmarshalAnimalGenerated",
+	}, {
+		file:     "animal_fallback_gen_test.go",
+		fn:       "unmarshalAnimalWithFallbackGenerated",
+		fallback: (*OtherAnimal)(nil),
+	}}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := Generate[Animal](&buf, GenerateConfig{
+				Package:     thisPackage,
+				PackageName: "jsondiscrim",
+				Func:        tt.fn,
+			}, tt.fallback, (*Dog)(nil), (*Cat)(nil))
+			qt.Assert(t, qt.IsNil(err))
+			if *update {
+				err := os.WriteFile(tt.file, buf.Bytes(), 0o666)
+				qt.Assert(t, qt.IsNil(err))
+				return
+			}
+			want, err := os.ReadFile(tt.file)
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.Equals(buf.String(), string(want)), qt.Commentf("run go generate to update %s", tt.file))
+		})
+	}
+}
+
+func TestGeneratedUnmarshal(t *testing.T) {
+	inputs := []string{
+		`[{"type":"dog","Bark":"woof"},{"Meow":"purr","type":"cat"}]`,
+		`[{"type":"bird"}]`,
+		`[{"Bark":"woof"}]`,
+		`["dog"]`,
+		`[{"type":null}]`,
+		`[{"type":["dog"]}]`,
+		`[{"type":"dog","Bark":1}]`,
+	}
+	for _, withFallback := range []bool{false, true} {
+		var fallback Animal
+		generated := json.UnmarshalFromFunc(unmarshalAnimalGenerated)
+		if withFallback {
+			fallback = (*OtherAnimal)(nil)
+			generated = json.UnmarshalFromFunc(unmarshalAnimalWithFallbackGenerated)
+		}
+		reflected := StructsWithFallback[Animal](fallback, (*Dog)(nil), (*Cat)(nil))
+		for _, input := range inputs {
+			var want, got, gotRead []Animal
+			wantErr := json.Unmarshal([]byte(input), &want, json.WithUnmarshalers(reflected))
+			gotErr := json.Unmarshal([]byte(input), &got, json.WithUnmarshalers(generated))
+			qt.Assert(t, qt.DeepEquals(got, want), qt.Commentf("input %s, fallback %v", input, withFallback))
+			qt.Assert(t, qt.Equals(gotErr == nil, wantErr == nil), qt.Commentf("input %s, fallback %v: got %v, want %v", input, withFallback, gotErr, wantErr))
+
+			// Reading a byte at a time exercises the
+			// buffered path as well as the direct one.
+			gotErr = json.UnmarshalRead(iotest.OneByteReader(strings.NewReader(input)), &gotRead, json.WithUnmarshalers(generated))
+			qt.Assert(t, qt.DeepEquals(gotRead, want), qt.Commentf("input %s, fallback %v", input, withFallback))
+			qt.Assert(t, qt.Equals(gotErr == nil, wantErr == nil), qt.Commentf("input %s, fallback %v: got %v, want %v", input, withFallback, gotErr, wantErr))
+		}
+	}
+}
+
+func BenchmarkGeneratedUnmarshal(b *testing.B) {
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for i := range 1000 {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		if i%2 == 0 {
+			buf.WriteString(`{"type":"dog","Bark":"woof woof woof woof"}`)
+		} else {
+			buf.WriteString(`{"type":"cat","Meow":"purr purr purr purr"}`)
+		}
+	}
+	buf.WriteString("]")
+	data := buf.Bytes()
+	for _, bm := range []struct {
+		name         string
+		unmarshalers *json.Unmarshalers
+	}{
+		{"Structs", Structs[Animal]((*Dog)(nil), (*Cat)(nil))},
+		{"Generated", json.UnmarshalFromFunc(unmarshalAnimalGenerated)},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			opts := json.WithUnmarshalers(bm.unmarshalers)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			for b.Loop() {
+				var got []Animal
+				if err := json.Unmarshal(data, &got, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateError(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := GenerateConfig{
+		Package:     "example.com/animals",
+		PackageName: "animals",
+		Func:        "unmarshalAnimal",
+	}
+	err := Generate[Animal](&buf, cfg, (*OtherAnimal)(nil))
+	qt.Assert(t, qt.ErrorMatches(err, `no discriminator field found`))
+
+	err = Generate[*Dog](&buf, cfg, nil, (*Dog)(nil))
+	qt.Assert(t, qt.ErrorMatches(err, `type \*jsondiscrim.Dog is not an interface type`))
+
+	err = Generate[Animal](&buf, GenerateConfig{Func: "f"}, nil, (*Dog)(nil))
+	qt.Assert(t, qt.ErrorMatches(err, `package name or function name not provided to Generate`))
+
+	// Types from other packages are qualified.
+	err = Generate[Animal](&buf, cfg, nil, (*Dog)(nil))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.StringContains(buf.String(), `x := new(jsondiscrim.Dog)`))
+}
@@ -3,13 +3,16 @@ module github.com/cue-exp/jsondiscrim
 go 1.25
 
 require (
+	github.com/fxamacker/cbor/v2 v2.9.4
 	github.com/go-json-experiment/json v0.0.0-20251027170946-4849db3c2f7e
 	github.com/go-quicktest/qt v1.101.0
+	github.com/google/go-cmp v0.5.9
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/google/go-cmp v0.5.9 // indirect
 	github.com/kr/pretty v0.3.1 // indirect
 	github.com/kr/text v0.2.0 // indirect
 	github.com/rogpeppe/go-internal v1.11.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 )
@@ -0,0 +1,17 @@
+// Package pets holds types for testing code generation
+// with types of the same name in different packages.
+package pets
+
+import "github.com/cue-exp/jsondiscrim"
+
+type Pet struct {
+	Kind jsondiscrim.Const[string, struct {
+		string `const:"pet1"`
+	}] `json:"kind"`
+}
+
+type Dog struct {
+	Kind jsondiscrim.Const[string, struct {
+		string `const:"dog"`
+	}] `json:"kind"`
+}
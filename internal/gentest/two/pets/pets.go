@@ -0,0 +1,17 @@
+// Package pets holds types for testing code generation
+// with types of the same name in different packages.
+package pets
+
+import "github.com/cue-exp/jsondiscrim"
+
+type Pet struct {
+	Kind jsondiscrim.Const[string, struct {
+		string `const:"pet2"`
+	}] `json:"kind"`
+}
+
+type Cat struct {
+	Kind jsondiscrim.Const[string, struct {
+		string `const:"cat"`
+	}] `json:"kind"`
+}
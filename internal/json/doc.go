@@ -0,0 +1,7 @@
+// Package json provides the parts of the semantic JSON API used by
+// this module. They come from the standard library's encoding/json/v2
+// package when it is available with its final API, as it is from Go
+// 1.27 when the jsonv2 experiment is enabled, and otherwise from
+// github.com/go-json-experiment/json. The types are aliases, so values
+// can be passed freely to and from whichever package is in use.
+package json
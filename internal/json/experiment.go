@@ -0,0 +1,60 @@
+//go:build !goexperiment.jsonv2 || !go1.27
+
+package json
+
+import (
+	"github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+	jsonv1 "github.com/go-json-experiment/json/v1"
+)
+
+// ImportPath holds the import path of the package in use.
+const ImportPath = "github.com/go-json-experiment/json"
+
+type (
+	Marshaler       = json.Marshaler
+	MarshalerTo     = json.MarshalerTo
+	Marshalers      = json.Marshalers
+	Options         = json.Options
+	SemanticError   = json.SemanticError
+	Unmarshaler     = json.Unmarshaler
+	UnmarshalerFrom = json.UnmarshalerFrom
+	Unmarshalers    = json.Unmarshalers
+)
+
+var (
+	Deterministic        = json.Deterministic
+	JoinOptions          = json.JoinOptions
+	JoinUnmarshalers     = json.JoinUnmarshalers
+	Marshal              = json.Marshal
+	MarshalEncode        = json.MarshalEncode
+	RejectUnknownMembers = json.RejectUnknownMembers
+	Unmarshal            = json.Unmarshal
+	UnmarshalDecode      = json.UnmarshalDecode
+	UnmarshalRead        = json.UnmarshalRead
+	WithMarshalers       = json.WithMarshalers
+	WithUnmarshalers     = json.WithUnmarshalers
+
+	// SkipFunc is returned by marshal and unmarshal functions
+	// to skip their use.
+	SkipFunc = json.SkipFunc
+
+	// DefaultOptionsV1 returns the options that give the
+	// semantics of encoding/json.
+	DefaultOptionsV1 = jsonv1.DefaultOptionsV1
+)
+
+// GetOption is [json.GetOption].
+func GetOption[T any](opts Options, setter func(T) Options) (T, bool) {
+	return json.GetOption(opts, setter)
+}
+
+// MarshalToFunc is [json.MarshalToFunc].
+func MarshalToFunc[T any](fn func(*jsontext.Encoder, T) error) *Marshalers {
+	return json.MarshalToFunc(fn)
+}
+
+// UnmarshalFromFunc is [json.UnmarshalFromFunc].
+func UnmarshalFromFunc[T any](fn func(*jsontext.Decoder, T) error) *Unmarshalers {
+	return json.UnmarshalFromFunc(fn)
+}
@@ -0,0 +1,4 @@
+// Package jsontext provides the parts of the syntactic JSON API used by
+// this module, taken from the same place as those provided by package
+// [github.com/cue-exp/jsondiscrim/internal/json].
+package jsontext
@@ -0,0 +1,43 @@
+//go:build !goexperiment.jsonv2 || !go1.27
+
+package jsontext
+
+import "github.com/go-json-experiment/json/jsontext"
+
+// ImportPath holds the import path of the package in use.
+const ImportPath = "github.com/go-json-experiment/json/jsontext"
+
+type (
+	Decoder        = jsontext.Decoder
+	Encoder        = jsontext.Encoder
+	Kind           = jsontext.Kind
+	Options        = jsontext.Options
+	Pointer        = jsontext.Pointer
+	SyntacticError = jsontext.SyntacticError
+	Token          = jsontext.Token
+	Value          = jsontext.Value
+)
+
+var (
+	AllowDuplicateNames = jsontext.AllowDuplicateNames
+	AllowInvalidUTF8    = jsontext.AllowInvalidUTF8
+	NewDecoder          = jsontext.NewDecoder
+	NewEncoder          = jsontext.NewEncoder
+
+	Bool   = jsontext.Bool
+	Float  = jsontext.Float
+	Int    = jsontext.Int
+	Null   = jsontext.Null
+	String = jsontext.String
+	Uint   = jsontext.Uint
+
+	BeginArray  = jsontext.BeginArray
+	BeginObject = jsontext.BeginObject
+	EndArray    = jsontext.EndArray
+	EndObject   = jsontext.EndObject
+)
+
+// AppendUnquote is [jsontext.AppendUnquote].
+func AppendUnquote[Bytes ~[]byte | ~string](dst []byte, src Bytes) ([]byte, error) {
+	return jsontext.AppendUnquote(dst, src)
+}
@@ -0,0 +1,66 @@
+package jsondiscrim
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+)
+
+// Lazy holds a value of the union over the interface type T in its
+// JSON form, deferring the work of unmarshaling it until [Lazy.Get] is
+// called. This can be useful for fields that are often ignored.
+//
+// The zero Lazy holds no value.
+type Lazy[T any] struct {
+	raw jsontext.Value
+}
+
+// UnmarshalJSON implements [json.Unmarshaler] by storing a copy of
+// data.
+func (l *Lazy[T]) UnmarshalJSON(data []byte) error {
+	l.raw = bytes.Clone(data)
+	return nil
+}
+
+// MarshalJSON implements [json.Marshaler] by returning the stored JSON,
+// or null if there is none.
+func (l Lazy[T]) MarshalJSON() ([]byte, error) {
+	if l.raw == nil {
+		return []byte("null"), nil
+	}
+	return l.raw, nil
+}
+
+// Raw returns the stored JSON, or nil if there is none.
+func (l Lazy[T]) Raw() jsontext.Value {
+	return l.raw
+}
+
+// Get unmarshals the stored JSON using the given choices, following
+// the rules documented in [Structs], and returns the result. It
+// returns the zero T if there is no stored JSON or it is null.
+//
+// The stored JSON is left unchanged, so calling Get again returns an
+// equal value.
+func (l Lazy[T]) Get(choices ...T) (T, error) {
+	var value T
+	t := reflect.TypeFor[T]()
+	if t.Kind() != reflect.Interface {
+		return value, fmt.Errorf("type %v is not an interface type", t)
+	}
+	if l.raw == nil || l.raw.Kind() == 'n' {
+		return value, nil
+	}
+	u, err := newUnion(&options{}, t, registeredFallback[T](), choices)
+	if err != nil {
+		return value, err
+	}
+	err = json.Unmarshal(l.raw, &value, json.WithUnmarshalers(unionUnmarshalers[T](u)))
+	if err != nil {
+		return *new(T), err
+	}
+	return value, nil
+}
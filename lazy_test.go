@@ -0,0 +1,67 @@
+package jsondiscrim
+
+import (
+	"testing"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/go-quicktest/qt"
+)
+
+type Zoo struct {
+	Name  string
+	Star  Lazy[Animal]
+	Other Lazy[Animal]
+}
+
+func TestLazy(t *testing.T) {
+	var zoo Zoo
+	err := json.Unmarshal([]byte(`{"Name":"city","Star":{"type":"cat","Meow":"purr"},"Other":{"type":"bird"}}`), &zoo)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(zoo.Star.Raw()), `{"type":"cat","Meow":"purr"}`))
+
+	// The unknown value is only an error when resolved.
+	for range 2 {
+		star, err := zoo.Star.Get((*Dog)(nil), (*Cat)(nil))
+		qt.Assert(t, qt.IsNil(err))
+		qt.Assert(t, qt.DeepEquals(star, Animal(&Cat{Meow: "purr"})))
+	}
+	_, err = zoo.Other.Get((*Dog)(nil), (*Cat)(nil))
+	qt.Assert(t, qt.ErrorMatches(err, `.*unknown discriminator value "bird".*`))
+
+	data, err := json.Marshal(zoo)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(data), `{"Name":"city","Star":{"type":"cat","Meow":"purr"},"Other":{"type":"bird"}}`))
+}
+
+func TestLazyRegisteredFallback(t *testing.T) {
+	RegisterFallback[Animal]((*OtherAnimal)(nil))
+	defer ClearFallback[Animal]()
+	var l Lazy[Animal]
+	err := json.Unmarshal([]byte(`{"type":"bird"}`), &l)
+	qt.Assert(t, qt.IsNil(err))
+	got, err := l.Get((*Dog)(nil), (*Cat)(nil))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, Animal(&OtherAnimal{Type: "bird"})))
+}
+
+func TestLazyEmpty(t *testing.T) {
+	var zoo Zoo
+	err := json.Unmarshal([]byte(`{"Name":"city","Star":null}`), &zoo)
+	qt.Assert(t, qt.IsNil(err))
+	for _, l := range []Lazy[Animal]{zoo.Star, zoo.Other} {
+		got, err := l.Get((*Dog)(nil), (*Cat)(nil))
+		qt.Assert(t, qt.IsNil(err))
+		qt.Assert(t, qt.IsNil(got))
+	}
+	data, err := json.Marshal(zoo)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(data), `{"Name":"city","Star":null,"Other":null}`))
+}
+
+func TestLazyInvalidChoices(t *testing.T) {
+	var l Lazy[Animal]
+	err := json.Unmarshal([]byte(`{"type":"dog"}`), &l)
+	qt.Assert(t, qt.IsNil(err))
+	_, err = l.Get()
+	qt.Assert(t, qt.ErrorMatches(err, `no choices provided to Structs`))
+}
@@ -0,0 +1,39 @@
+package jsondiscrim
+
+import "io"
+
+// LimitReader returns a reader that reads from r but fails with a
+// [*ReadLimitError] once more than n bytes would be read, guarding
+// against unbounded input when decoding from an untrusted source.
+//
+// When a union value is being unmarshaled from a [jsontext.Decoder]
+// reading from it at the time, the error is reported at the position
+// of that value, so it is clear which element of an array overflowed.
+func LimitReader(r io.Reader, n int64) io.Reader {
+	return &limitReader{r: r, limit: n, remaining: n}
+}
+
+type limitReader struct {
+	r         io.Reader
+	limit     int64
+	remaining int64
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, &ReadLimitError{Limit: l.limit}
+	}
+	// Read one byte more than allowed so that we can tell
+	// whether the limit is exceeded.
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		n = int(l.remaining)
+		l.remaining = -1
+		return n, &ReadLimitError{Limit: l.limit}
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,39 @@
+package jsondiscrim
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/go-quicktest/qt"
+)
+
+func TestLimitReader(t *testing.T) {
+	data, err := io.ReadAll(LimitReader(strings.NewReader("abcdef"), 6))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(data), "abcdef"))
+
+	data, err = io.ReadAll(LimitReader(strings.NewReader("abcdefg"), 6))
+	qt.Assert(t, qt.ErrorMatches(err, `read limit of 6 bytes exceeded`))
+	qt.Assert(t, qt.Equals(string(data), "abcdef"))
+}
+
+func TestLimitReaderLargeArray(t *testing.T) {
+	unmarshalers := json.WithUnmarshalers(Structs[Animal]((*Dog)(nil), (*Cat)(nil)))
+	input := "[" + strings.Repeat(`{"type":"dog","Bark":"woof"},`, 100) + `{"type":"cat"}]`
+
+	var got []Animal
+	err := json.UnmarshalRead(LimitReader(strings.NewReader(input), int64(len(input))), &got, unmarshalers)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.HasLen(got, 101))
+
+	// The limit is exceeded partway through the array, and the
+	// error says which element was being read at the time.
+	err = json.UnmarshalRead(LimitReader(strings.NewReader(input), 1000), &got, unmarshalers)
+	qt.Assert(t, qt.ErrorMatches(err, `.* within "/[1-9][0-9]*": read limit of 1000 bytes exceeded`))
+	var limitErr *ReadLimitError
+	qt.Assert(t, qt.IsTrue(errors.As(err, &limitErr)))
+	qt.Assert(t, qt.Equals(limitErr.Limit, 1000))
+}
@@ -0,0 +1,220 @@
+package jsondiscrim
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+)
+
+// Marshalers returns marshalers for the interface type T, the
+// counterpart of the unmarshalers returned by [Structs] with the same
+// choices, so that values marshaled with them can always be
+// unmarshaled again. Each value of one of the choices is marshaled
+// with its discriminator member first, which is added if it is
+// missing, for example because its [Const] field is tagged with
+// omitzero. This also lets it be unmarshaled without buffering,
+// and with unmarshalers using [WithDiscriminatorFirst].
+//
+// As for any marshaler function for an interface type, the marshalers
+// apply to values of all types that implement T, not just to those
+// held in values of type T. Values of the fallback type registered for
+// T are marshaled as is, and nil values as null. Marshaling a value of
+// any other type is an error, as
+// is a value that marshals with a discriminator member that would not
+// choose its type, for example because of a custom MarshalJSON method.
+// A value may be a pointer when its choice is not, and vice versa.
+//
+// Like [Structs], it panics if the choices are not well formed.
+func Marshalers[T any](choices ...T) *json.Marshalers {
+	m, err := newMarshaler(choices)
+	if err != nil {
+		panic(err)
+	}
+	return json.MarshalToFunc(func(e *jsontext.Encoder, v T) error {
+		if isNil(v) {
+			return json.SkipFunc
+		}
+		if _, ok := m.encoders.Load(e); ok && e.StackDepth() == 0 {
+			// The function applies to all types implementing T,
+			// including that of v, so it is called again when
+			// marshal marshals v itself.
+			return json.SkipFunc
+		}
+		raw, err := m.marshal(v, e.Options())
+		if err != nil {
+			return err
+		}
+		return e.WriteValue(raw)
+	})
+}
+
+// Options returns options holding both the marshalers returned by
+// [Marshalers] and the unmarshalers returned by [Structs] for the
+// given choices, so that a single value can be used for both
+// marshaling and unmarshaling.
+func Options[T any](choices ...T) json.Options {
+	return json.JoinOptions(
+		json.WithMarshalers(Marshalers(choices...)),
+		json.WithUnmarshalers(Structs(choices...)),
+	)
+}
+
+// MarshalSlice marshals values as a JSON array, ensuring that each
+// element holds the discriminator member of its choice so that the
+// result can be unmarshaled again with [UnmarshalSlice] or [Structs].
+// The elements are marshaled as for [Marshalers], except that it is
+// an error for an element to be nil.
+func MarshalSlice[T any](values []T, choices ...T) ([]byte, error) {
+	m, err := newMarshaler(choices)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	e := jsontext.NewEncoder(&buf)
+	if err := e.WriteToken(jsontext.BeginArray); err != nil {
+		return nil, err
+	}
+	for i, v := range values {
+		if isNil(v) {
+			return nil, fmt.Errorf("value %d is nil", i)
+		}
+		raw, err := m.marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("value %d: %w", i, err)
+		}
+		if err := e.WriteValue(raw); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.WriteToken(jsontext.EndArray); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(buf.Bytes()), nil
+}
+
+// marshaler holds the information needed to marshal values of a
+// discriminated union.
+type marshaler struct {
+	u *union
+	// valueByType holds the discriminator value of each choice,
+	// keyed by its struct type.
+	valueByType map[reflect.Type]any
+	// encoders holds the encoders in use by marshal.
+	encoders sync.Map
+}
+
+func newMarshaler[T any](choices []T) (*marshaler, error) {
+	t := reflect.TypeFor[T]()
+	if t.Kind() != reflect.Interface {
+		return nil, fmt.Errorf("type %v is not an interface type", t)
+	}
+	u, err := newUnion(&options{}, t, registeredFallback[T](), choices)
+	if err != nil {
+		return nil, err
+	}
+	m := &marshaler{
+		u:           u,
+		valueByType: make(map[reflect.Type]any),
+	}
+	// Use the primary value of each Const, which
+	// is listed first, rather than any alias.
+	for _, t := range u.discrimByValue {
+		st := structType(t)
+		if v, ok := constFields(t)[u.discrimField]; ok {
+			m.valueByType[st] = canonicalValue(v)
+		}
+	}
+	// Otherwise use the first value for each type
+	// so that the output is deterministic.
+	for _, v := range slices.SortedFunc(maps.Keys(u.discrimByValue), compareValues) {
+		st := structType(u.discrimByValue[v])
+		if _, ok := m.valueByType[st]; !ok {
+			m.valueByType[st] = v
+		}
+	}
+	return m, nil
+}
+
+// marshal returns the JSON for the non-nil value v.
+func (m *marshaler) marshal(v any, opts ...json.Options) (jsontext.Value, error) {
+	vt := structType(reflect.TypeOf(v))
+	discrim, ok := m.valueByType[vt]
+	if !ok && (m.u.fallbackType == nil || vt != structType(m.u.fallbackType)) {
+		return nil, fmt.Errorf("type %v is not a choice", reflect.TypeOf(v))
+	}
+	var buf bytes.Buffer
+	e := jsontext.NewEncoder(&buf, opts...)
+	m.encoders.Store(e, true)
+	defer m.encoders.Delete(e)
+	if err := json.MarshalEncode(e, v); err != nil {
+		return nil, err
+	}
+	raw := jsontext.Value(bytes.TrimSpace(buf.Bytes()))
+	if !ok || m.u.discrimField == "" {
+		return raw, nil
+	}
+	raw, err := fieldFirst(raw, m.u.discrimField, discrim)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDiscrim(m.u, raw, vt); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// checkDiscrim checks that the discriminator member of the JSON object
+// data chooses the struct type st.
+func checkDiscrim(u *union, data jsontext.Value, st reflect.Type) error {
+	v, err := fieldValue(data, u.discrimField)
+	if err == nil {
+		err = checkScalar(v, true)
+	}
+	if err != nil {
+		return err
+	}
+	if t := u.discrimByValue[v]; t == nil || structType(t) != st {
+		return fmt.Errorf("discriminator field %q holds %#v, which does not choose %v", u.discrimField, v, st)
+	}
+	return nil
+}
+
+// fieldFirst returns the JSON object data with its member named
+// fieldName moved to the start, or with a member of that name holding
+// v added at the start if it has no such member.
+func fieldFirst(data jsontext.Value, fieldName string, v any) (jsontext.Value, error) {
+	if data.Kind() != '{' {
+		return nil, fmt.Errorf("expected object, got %v", data.Kind())
+	}
+	value, _, err := fieldRawValue(data, fieldName)
+	switch {
+	case err == nil:
+		if data, err = removeField(data, fieldName); err != nil {
+			return nil, err
+		}
+	case errors.As(err, new(*MissingFieldError)):
+		if value, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	member, err := json.Marshal(map[string]jsontext.Value{fieldName: value})
+	if err != nil {
+		return nil, err
+	}
+	rest := bytes.TrimSpace(data[1:])
+	if rest[0] == '}' {
+		return member, nil
+	}
+	// Replace the closing brace of the member
+	// with a comma.
+	return slices.Concat(member[:len(member)-1], []byte(","), rest), nil
+}
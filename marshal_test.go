@@ -0,0 +1,186 @@
+package jsondiscrim
+
+import (
+	"testing"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+	"github.com/go-quicktest/qt"
+)
+
+type QuietDog struct {
+	Type stringConst[struct {
+		string `const:"quietdog"`
+	}] `json:"type,omitzero"`
+	Bark string
+}
+
+func (*QuietDog) isAnimal() {}
+
+// QuietCanine has an alias for its discriminator value
+// that sorts before its primary value.
+type QuietCanine struct {
+	Type stringConst[struct {
+		string `const:"dog" const:"canine"`
+	}] `json:"type,omitzero"`
+	Bark string
+}
+
+func (*QuietCanine) isAnimal() {}
+
+// LyingDog marshals with the discriminator value of [Dog]
+// rather than its own.
+type LyingDog struct {
+	Type stringConst[struct {
+		string `const:"lyingdog"`
+	}] `json:"type"`
+}
+
+func (*LyingDog) isAnimal() {}
+
+func (LyingDog) MarshalJSON() ([]byte, error) {
+	return []byte(`{"type":"dog"}`), nil
+}
+
+func TestMarshalSlice(t *testing.T) {
+	choices := []Animal{(*Dog)(nil), (*Cat)(nil), (*QuietDog)(nil)}
+	values := []Animal{
+		&Dog{Bark: "woof"},
+		&Cat{Meow: "purr"},
+		&QuietDog{Bark: "..."},
+		&QuietDog{},
+	}
+	data, err := MarshalSlice(values, choices...)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(data), `[{"type":"dog","Bark":"woof"},{"type":"cat","Meow":"purr"},{"type":"quietdog","Bark":"..."},{"type":"quietdog","Bark":""}]`))
+
+	got, err := UnmarshalSlice(data, choices...)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, values))
+
+	data, err = MarshalSlice([]Animal{}, choices...)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(data), `[]`))
+}
+
+func TestMarshalPrimaryValue(t *testing.T) {
+	choices := []Animal{(*QuietCanine)(nil), (*Cat)(nil)}
+	data, err := MarshalSlice([]Animal{&QuietCanine{}}, choices...)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(data), `[{"type":"dog","Bark":""}]`))
+
+	data, err = json.Marshal(Kennel{Resident: &QuietCanine{}}, json.WithMarshalers(Marshalers(choices...)))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(data), `{"Resident":{"type":"dog","Bark":""},"Visitors":[]}`))
+}
+
+// TailDog declares its discriminator field last.
+type TailDog struct {
+	Bark string
+	Type stringConst[struct {
+		string `const:"taildog"`
+	}] `json:"type"`
+}
+
+func (*TailDog) isAnimal() {}
+
+type Kennel struct {
+	Resident Animal
+	Visitors []Animal
+}
+
+func TestMarshalers(t *testing.T) {
+	choices := []Animal{(*Dog)(nil), (*Cat)(nil), (*QuietDog)(nil), (*TailDog)(nil)}
+	kennel := Kennel{
+		Resident: &TailDog{Bark: "woof"},
+		Visitors: []Animal{&QuietDog{}, Cat{Meow: "purr"}, nil},
+	}
+	data, err := json.Marshal(kennel, json.WithMarshalers(Marshalers(choices...)))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(data), `{"Resident":{"type":"taildog","Bark":"woof"},"Visitors":[{"type":"quietdog","Bark":""},{"type":"cat","Meow":"purr"},null]}`))
+
+	// Without the marshalers, the discriminator is in field order
+	// or missing.
+	data, err = json.Marshal(kennel)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(data), `{"Resident":{"Bark":"woof","type":"taildog"},"Visitors":[{"Bark":""},{"type":"cat","Meow":"purr"},null]}`))
+
+	opts := Options(choices...)
+	kennel.Visitors = kennel.Visitors[:2]
+	data, err = json.Marshal(kennel, opts)
+	qt.Assert(t, qt.IsNil(err))
+	var got Kennel
+	err = json.Unmarshal(data, &got, opts)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, Kennel{
+		Resident: &TailDog{Bark: "woof"},
+		Visitors: []Animal{&QuietDog{}, &Cat{Meow: "purr"}},
+	}))
+}
+
+func TestMarshalersErrors(t *testing.T) {
+	marshalers := json.WithMarshalers(Marshalers[Animal]((*Dog)(nil), (*LyingDog)(nil)))
+	_, err := json.Marshal([]Animal{&Cat{}}, marshalers)
+	qt.Assert(t, qt.ErrorMatches(err, `.*type \*jsondiscrim.Cat is not a choice`))
+
+	_, err = json.Marshal([]Animal{&LyingDog{}}, marshalers)
+	qt.Assert(t, qt.ErrorMatches(err, `.*discriminator field "type" holds "dog", which does not choose jsondiscrim.LyingDog`))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		Marshalers[Animal]()
+	}, `no choices provided to Structs`))
+}
+
+func TestFieldFirst(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want string
+	}{
+		{"first", `{"type":"dog","Bark":"woof"}`, `{"type":"dog","Bark":"woof"}`},
+		{"last", `{"Bark":"woof","type":"dog"}`, `{"type":"dog","Bark":"woof"}`},
+		{"middle", `{"a":1,"type":"dog","b":2}`, `{"type":"dog","a":1,"b":2}`},
+		{"missing", `{"Bark":"woof"}`, `{"type":"x","Bark":"woof"}`},
+		{"empty", `{}`, `{"type":"x"}`},
+		{"only", `{"type":"dog"}`, `{"type":"dog"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fieldFirst(jsontext.Value(tt.json), "type", "x")
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.Equals(string(got), tt.want))
+		})
+	}
+}
+
+func TestMarshalSliceFallback(t *testing.T) {
+	RegisterFallback[Animal]((*OtherAnimal)(nil))
+	defer ClearFallback[Animal]()
+
+	values := []Animal{
+		&Dog{Bark: "woof"},
+		&OtherAnimal{Type: "bird", OtherFields: jsontext.Value(`{"Sing":"tweet"}`)},
+	}
+	data, err := MarshalSlice[Animal](values, (*Dog)(nil), (*Cat)(nil))
+	qt.Assert(t, qt.IsNil(err))
+	got, err := UnmarshalSlice[Animal](data, (*Dog)(nil), (*Cat)(nil))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, values))
+}
+
+func TestMarshalSliceErrors(t *testing.T) {
+	_, err := MarshalSlice[Animal]([]Animal{&Dog{}, nil}, (*Dog)(nil))
+	qt.Assert(t, qt.ErrorMatches(err, `value 1 is nil`))
+
+	_, err = MarshalSlice[Animal]([]Animal{&Cat{}}, (*Dog)(nil))
+	qt.Assert(t, qt.ErrorMatches(err, `value 0: type \*jsondiscrim.Cat is not a choice`))
+
+	_, err = MarshalSlice[Animal]([]Animal{&Dog{}, &LyingDog{}}, (*Dog)(nil), (*LyingDog)(nil))
+	qt.Assert(t, qt.ErrorMatches(err, `value 1: discriminator field "type" holds "dog", which does not choose jsondiscrim.LyingDog`))
+
+	_, err = MarshalSlice[Animal](nil)
+	qt.Assert(t, qt.ErrorMatches(err, `no choices provided to Structs`))
+
+	_, err = UnmarshalSlice[Animal]([]byte(`[{"type":"bird"}]`), (*Dog)(nil))
+	qt.Assert(t, qt.ErrorMatches(err, `.*unknown discriminator value "bird".*`))
+}
@@ -0,0 +1,81 @@
+package jsondiscrim
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+)
+
+// valueOptions holds the options used to unmarshal discriminator
+// values from JSON. They cause numbers to unmarshal losslessly as
+// their canonical values, so that they can be compared exactly with
+// the canonical values of [Const] fields.
+var valueOptions = json.WithUnmarshalers(json.UnmarshalFromFunc(func(d *jsontext.Decoder, v *any) error {
+	if d.PeekKind() != '0' {
+		return json.SkipFunc
+	}
+	raw, err := d.ReadValue()
+	if err != nil {
+		return err
+	}
+	*v = parseNumber(string(raw))
+	return nil
+}))
+
+// parseNumber returns the canonical value of the JSON number s.
+func parseNumber(s string) any {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return u
+	}
+	// The number is valid JSON, so the only possible error is
+	// that it is out of range, in which case f is infinite.
+	f, _ := strconv.ParseFloat(s, 64)
+	return canonicalValue(f)
+}
+
+// canonicalValue returns the value used to look up the discriminator
+// value v. Values of named types are converted to their underlying
+// basic types, and numbers to int64 when they are integers in its
+// range, to uint64 when they are larger integers and to float64
+// otherwise. Thus a Const[int] holding 1, a Const[float64] holding 1.0
+// and the JSON number 1e0 all have the same canonical value.
+func canonicalValue(v any) any {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u := rv.Uint(); u > math.MaxInt64 {
+			return u
+		}
+		return int64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+			return int64(f)
+		}
+		return f
+	}
+	return v
+}
+
+// isNumber reports whether t is an integer or floating-point type.
+func isNumber(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
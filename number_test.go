@@ -0,0 +1,245 @@
+package jsondiscrim
+
+import (
+	"math"
+	"testing"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/go-quicktest/qt"
+)
+
+type Shape2 interface {
+	isShape2()
+}
+
+type Point2 struct {
+	Dims Const[int, struct {
+		int `const:"0"`
+	}] `json:"dims"`
+}
+
+func (*Point2) isShape2() {}
+
+type Line2 struct {
+	Dims Const[uint8, struct {
+		uint8 `const:"1"`
+	}] `json:"dims"`
+	Length float64
+}
+
+func (*Line2) isShape2() {}
+
+type Plane2 struct {
+	Dims Const[float64, struct {
+		float64 `const:"2"`
+	}] `json:"dims"`
+	Area float64
+}
+
+func (*Plane2) isShape2() {}
+
+type Fractal2 struct {
+	Dims Const[float64, struct {
+		float64 `const:"1.5"`
+	}] `json:"dims"`
+}
+
+func (*Fractal2) isShape2() {}
+
+func TestIntegerDiscriminator(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    Shape2
+		wantErr string
+	}{
+		{"int", `{"dims":0}`, &Point2{}, ""},
+		{"uint8", `{"dims":1,"Length":3}`, &Line2{Length: 3}, ""},
+		{"integral float", `{"Area":4,"dims":2}`, &Plane2{Area: 4}, ""},
+		{"exponent", `{"dims":2e0}`, &Plane2{}, ""},
+		{"fraction", `{"dims":1.5}`, &Fractal2{}, ""},
+		{"unknown", `{"dims":3}`, nil, `.*unknown discriminator value 3 .*`},
+		{"string is not number", `{"dims":"1"}`, nil, `.*unknown discriminator value "1" .*`},
+	}
+	unmarshalers := json.WithUnmarshalers(Structs[Shape2](
+		(*Point2)(nil),
+		(*Line2)(nil),
+		(*Plane2)(nil),
+		(*Fractal2)(nil),
+	))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Shape2
+			err := json.Unmarshal([]byte(tt.json), &got, unmarshalers)
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+type Agent interface {
+	isAgent()
+}
+
+type AgentBond struct {
+	Code Const[string, struct {
+		string `const:"007"`
+	}] `json:"code"`
+}
+
+type AgentSeven struct {
+	Code Const[int, struct {
+		int `const:"7"`
+	}] `json:"code"`
+}
+
+type AgentEight struct {
+	Code Const[uint8, struct {
+		uint8 `const:"8"`
+	}] `json:"code"`
+}
+
+func (*AgentBond) isAgent()  {}
+func (*AgentSeven) isAgent() {}
+func (*AgentEight) isAgent() {}
+
+func TestStringNumberAmbiguity(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		opts    []Option
+		noBond  bool
+		want    Agent
+		wantErr string
+	}{
+		{name: "string", json: `{"code":"007"}`, want: &AgentBond{}},
+		{name: "number", json: `{"code":7}`, want: &AgentSeven{}},
+		{name: "quoted number", json: `{"code":"7"}`, wantErr: `.*unknown discriminator value "7".*`},
+		{name: "quoted number without string choice", json: `{"code":"007"}`, noBond: true, wantErr: `.*unknown discriminator value "007".*`},
+		{name: "leading zeros are invalid JSON", json: `{"code":007}`, wantErr: `.*invalid character '0' after object value.*`},
+		{
+			name: "lenient string",
+			json: `{"code":"007"}`,
+			opts: []Option{WithLenientNumbers()},
+			want: &AgentBond{},
+		},
+		{
+			name: "lenient quoted number",
+			json: `{"code":"7"}`,
+			opts: []Option{WithLenientNumbers()},
+			want: &AgentSeven{},
+		},
+		{
+			name:   "lenient leading zeros without string choice",
+			json:   `{"code":"007"}`,
+			opts:   []Option{WithLenientNumbers()},
+			noBond: true,
+			want:   &AgentSeven{},
+		},
+		{
+			name: "lenient unsigned",
+			json: `{"code":"0008"}`,
+			opts: []Option{WithLenientNumbers()},
+			want: &AgentEight{},
+		},
+		{
+			name: "lenient number",
+			json: `{"code":7.0}`,
+			opts: []Option{WithLenientNumbers()},
+			want: &AgentSeven{},
+		},
+		{
+			name:    "lenient plus sign",
+			json:    `{"code":"+7"}`,
+			opts:    []Option{WithLenientNumbers()},
+			wantErr: `.*unknown discriminator value "\+7".*`,
+		},
+		{
+			name:    "lenient fraction",
+			json:    `{"code":"7.0"}`,
+			opts:    []Option{WithLenientNumbers()},
+			wantErr: `.*unknown discriminator value "7.0".*`,
+		},
+		{
+			name:    "lenient unknown",
+			json:    `{"code":"9"}`,
+			opts:    []Option{WithLenientNumbers()},
+			wantErr: `.*unknown discriminator value "9".*`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			choices := []Agent{(*AgentSeven)(nil), (*AgentEight)(nil)}
+			if !tt.noBond {
+				choices = append(choices, (*AgentBond)(nil))
+			}
+			var got Agent
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions(tt.opts, nil, choices...)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestParseDecimalInt(t *testing.T) {
+	tests := []struct {
+		s      string
+		want   any
+		wantOK bool
+	}{
+		{"7", int64(7), true},
+		{"007", int64(7), true},
+		{"-007", int64(-7), true},
+		{"18446744073709551615", uint64(math.MaxUint64), true},
+		{"18446744073709551616", nil, false},
+		{"", nil, false},
+		{"-", nil, false},
+		{"+7", nil, false},
+		{"7.0", nil, false},
+		{"7e0", nil, false},
+		{" 7", nil, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseDecimalInt(tt.s)
+		qt.Check(t, qt.Equals(ok, tt.wantOK), qt.Commentf("%q", tt.s))
+		qt.Check(t, qt.Equals(got, tt.want), qt.Commentf("%q", tt.s))
+	}
+}
+
+func TestCanonicalValueCollision(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		Structs[Shape2]((*Line2)(nil), (*Fractal2)(nil), &struct {
+			Plane2
+			Dims Const[int, struct {
+				int `const:"1"`
+			}] `json:"dims"`
+		}{})
+	}, `discriminator value 1 of .* is already used by .*`))
+}
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		s    string
+		want any
+	}{
+		{"0", int64(0)},
+		{"-12", int64(-12)},
+		{"1.0", int64(1)},
+		{"1e3", int64(1000)},
+		{"0.5", 0.5},
+		{"18446744073709551615", uint64(math.MaxUint64)},
+		{"1e300", 1e300},
+		{"1e400", math.Inf(1)},
+	}
+	for _, tt := range tests {
+		qt.Check(t, qt.Equals(parseNumber(tt.s), tt.want), qt.Commentf("%s", tt.s))
+	}
+}
@@ -0,0 +1,108 @@
+package jsondiscrim
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+)
+
+// OneOf returns an unmarshaler for the struct type T, which represents
+// a discriminated union by holding a pointer field for each choice
+// rather than by being an interface type. This is useful when the
+// choices cannot share a Go interface.
+//
+// Each choice field must be an exported field of pointer type tagged
+// with `jsondiscrim:"oneof"`, where the pointed-to struct types follow
+// the rules for choices documented in [Structs]. At most one field may
+// instead be tagged with `jsondiscrim:"oneof,fallback"`, in which case
+// it is used as the fallback choice as for [StructsWithFallback].
+//
+// For example:
+//
+//	type AnyAnimal struct {
+//		Dog   *Dog         `jsondiscrim:"oneof"`
+//		Cat   *Cat         `jsondiscrim:"oneof"`
+//		Other *OtherAnimal `jsondiscrim:"oneof,fallback"`
+//	}
+//
+// When unmarshaling, the whole JSON value is unmarshaled into the
+// field chosen by the discriminator and all other choice fields are
+// set to nil. Fields without the tag are left unchanged. Since each
+// choice is held in a field of its own type, OneOf panics if given
+// [WithValueResults] or [WithPointerResults].
+func OneOf[T any](opts ...Option) *json.Unmarshalers {
+	t := reflect.TypeFor[T]()
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Errorf("type %v is not a struct type", t))
+	}
+	var choices []any
+	var fallback any
+	var choiceFields []int
+	fieldByType := make(map[reflect.Type]int)
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("jsondiscrim")
+		if !ok {
+			continue
+		}
+		switch tag {
+		case "oneof", "oneof,fallback":
+		default:
+			panic(fmt.Errorf("invalid jsondiscrim tag %q on field %s of %v", tag, f.Name, t))
+		}
+		if f.PkgPath != "" {
+			panic(fmt.Errorf("oneof field %s of %v is not exported", f.Name, t))
+		}
+		if f.Type.Kind() != reflect.Pointer {
+			panic(fmt.Errorf("oneof field %s of %v is %v not pointer", f.Name, t, f.Type))
+		}
+		if j, ok := fieldByType[f.Type]; ok {
+			panic(fmt.Errorf("oneof fields %s and %s of %v have the same type", t.Field(j).Name, f.Name, t))
+		}
+		fieldByType[f.Type] = i
+		choiceFields = append(choiceFields, i)
+		choice := reflect.Zero(f.Type).Interface()
+		if tag == "oneof,fallback" {
+			if fallback != nil {
+				panic(fmt.Errorf("multiple oneof fallback fields in %v", t))
+			}
+			fallback = choice
+			continue
+		}
+		choices = append(choices, choice)
+	}
+	if len(choiceFields) == 0 {
+		panic(fmt.Errorf("no oneof fields in %v", t))
+	}
+	o := applyOptions(opts)
+	if o.results != registeredResults {
+		panic(fmt.Errorf("OneOf cannot be used with WithValueResults or WithPointerResults"))
+	}
+	for _, ct := range o.extraChoices() {
+		if _, ok := fieldByType[ct]; !ok {
+			panic(fmt.Errorf("choice %v is not the type of a oneof field in %v", ct, t))
+		}
+	}
+	u, err := newUnion(o, t, fallback, choices)
+	if err != nil {
+		panic(err)
+	}
+	return json.UnmarshalFromFunc(func(d *jsontext.Decoder, dst *T) error {
+		v, err := u.unmarshal(d)
+		if err != nil {
+			return err
+		}
+		dstv := reflect.ValueOf(dst).Elem()
+		for _, i := range choiceFields {
+			dstv.Field(i).SetZero()
+		}
+		if v.Type() == t {
+			// There is no value, as for [WithEmptyObjectAsNil].
+			return nil
+		}
+		dstv.Field(fieldByType[v.Type()]).Set(v)
+		return nil
+	})
+}
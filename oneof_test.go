@@ -0,0 +1,116 @@
+package jsondiscrim
+
+import (
+	"testing"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+	"github.com/go-quicktest/qt"
+)
+
+type AnyAnimal struct {
+	Name  string
+	Dog   *Dog         `jsondiscrim:"oneof"`
+	Cat   *Cat         `jsondiscrim:"oneof"`
+	Other *OtherAnimal `jsondiscrim:"oneof,fallback"`
+}
+
+func TestOneOf(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want AnyAnimal
+	}{
+		{
+			name: "dog",
+			json: `{"type":"dog","Bark":"woof"}`,
+			want: AnyAnimal{Name: "existing", Dog: &Dog{Bark: "woof"}},
+		},
+		{
+			name: "cat",
+			json: `{"Meow":"purr","type":"cat"}`,
+			want: AnyAnimal{Name: "existing", Cat: &Cat{Meow: "purr"}},
+		},
+		{
+			name: "fallback",
+			json: `{"type":"dragon","Fire":true}`,
+			want: AnyAnimal{Name: "existing", Other: &OtherAnimal{
+				Type:        "dragon",
+				OtherFields: jsontext.Value(`{"Fire":true}`),
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Start with a previously populated value to check
+			// that other choice fields are cleared.
+			got := AnyAnimal{
+				Name: "existing",
+				Dog:  &Dog{Bark: "old"},
+				Cat:  &Cat{Meow: "old"},
+			}
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(OneOf[AnyAnimal]()))
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestOneOfEmptyObjectAsNil(t *testing.T) {
+	got := AnyAnimal{Name: "existing", Dog: &Dog{Bark: "old"}}
+	err := json.Unmarshal([]byte(`{}`), &got, json.WithUnmarshalers(OneOf[AnyAnimal](WithEmptyObjectAsNil())))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, AnyAnimal{Name: "existing"}))
+}
+
+func TestOneOfInSlice(t *testing.T) {
+	type PetOnly struct {
+		Dog *Dog `jsondiscrim:"oneof"`
+		Cat *Cat `jsondiscrim:"oneof"`
+	}
+	var got []PetOnly
+	err := json.Unmarshal(
+		[]byte(`[{"type":"cat"},{"type":"dog","Bark":"yap"}]`),
+		&got,
+		json.WithUnmarshalers(OneOf[PetOnly]()),
+	)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, []PetOnly{{Cat: &Cat{}}, {Dog: &Dog{Bark: "yap"}}}))
+
+	err = json.Unmarshal([]byte(`[{"type":"bird"}]`), &got, json.WithUnmarshalers(OneOf[PetOnly]()))
+	qt.Assert(t, qt.ErrorMatches(err, `.*unknown discriminator value "bird".*`))
+}
+
+func TestOneOfPanics(t *testing.T) {
+	type NotPointer struct {
+		Dog Dog `jsondiscrim:"oneof"`
+	}
+	qt.Assert(t, qt.PanicMatches(func() {
+		OneOf[NotPointer]()
+	}, `oneof field Dog of .* is jsondiscrim.Dog not pointer`))
+
+	type BadTag struct {
+		Dog *Dog `jsondiscrim:"oneof,other"`
+	}
+	qt.Assert(t, qt.PanicMatches(func() {
+		OneOf[BadTag]()
+	}, `invalid jsondiscrim tag "oneof,other" on field Dog of .*`))
+
+	type NoFields struct {
+		Dog *Dog
+	}
+	qt.Assert(t, qt.PanicMatches(func() {
+		OneOf[NoFields]()
+	}, `no oneof fields in .*`))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		OneOf[Animal]()
+	}, `type jsondiscrim.Animal is not a struct type`))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		OneOf[AnyAnimal](WithValueResults())
+	}, `OneOf cannot be used with WithValueResults or WithPointerResults`))
+	qt.Assert(t, qt.PanicMatches(func() {
+		OneOf[AnyAnimal](WithPointerResults())
+	}, `OneOf cannot be used with WithValueResults or WithPointerResults`))
+}
@@ -0,0 +1,1018 @@
+package jsondiscrim
+
+import (
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+)
+
+// Option represents an option that modifies the behavior of the
+// unmarshalers returned by [StructsWithOptions].
+type Option func(*options)
+
+type options struct {
+	objectRequired      bool
+	keyFields           []string
+	keyJoin             func([]any) any
+	trim                bool
+	discrimField        string
+	firstMatch          bool
+	matchers            []matcher
+	innerOpts           []json.Options
+	discrimPath         []string
+	mapFieldName        func(string) string
+	literals            []literal
+	typeName            func(reflect.Type) string
+	bodyField           string
+	optionalBody        bool
+	bareString          bool
+	emptyAsMissing      bool
+	inherited           *LiteralValue
+	defaultValue        *LiteralValue
+	results             resultForm
+	envelope            []string
+	variantUnmarshalers []variantUnmarshaler
+	nullAsMissing       bool
+	kinds               map[jsontext.Kind]reflect.Type
+	deprecated          []reflect.Type
+	onDeprecated        func(reflect.Type)
+	maxElements         int
+	blocked             []any
+	goNameAlias         bool
+	readLimit           int64
+	unicodeFold         bool
+	missingType         reflect.Type
+	unknownType         reflect.Type
+	strictField         bool
+	discrimFirst        bool
+	decodeHook          func(jsontext.Value) (jsontext.Value, error)
+	emptyObjectAsNil    bool
+	lenientNumbers      bool
+	// foldedValues maps the folded forms of the string discriminator
+	// values to the values themselves when unicodeFold is set.
+	// It is filled in by newUnion.
+	foldedValues map[string]string
+	// discrimValues holds the discriminator values of the union
+	// when lenientNumbers is set. It is filled in by newUnion.
+	discrimValues map[any]reflect.Type
+	// innerSyntactic reports whether innerOpts holds options for
+	// the decoder itself, which [json.UnmarshalDecode] ignores.
+	// It is filled in by newUnion.
+	innerSyntactic bool
+}
+
+type variantUnmarshaler struct {
+	value     any
+	unmarshal func(jsontext.Value, any) error
+}
+
+// resultForm determines whether choices are unmarshaled as pointers
+// or values.
+type resultForm int
+
+const (
+	registeredResults resultForm = iota
+	valueResults
+	pointerResults
+)
+
+type literal struct {
+	typ   reflect.Type
+	value any
+}
+
+type matcher struct {
+	typ   reflect.Type
+	match func(jsontext.Value) bool
+}
+
+func applyOptions(opts []Option) *options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &o
+}
+
+// WithDiscriminatorField causes the [Const] field with the given JSON
+// name to be used as the discriminator field rather than determining
+// it automatically. This is useful when the choices have several Const
+// fields that could act as the discriminator. The field must still
+// hold a different value in each choice.
+func WithDiscriminatorField(name string) Option {
+	return func(o *options) {
+		o.discrimField = name
+	}
+}
+
+// WithTypenameField is equivalent to [WithDiscriminatorField]. It
+// suits conventions such as GraphQL's, where every object holds a
+// "__typename" member that the Go types do not otherwise need, and is
+// usually combined with [WithTypeNameDiscriminator] or [WithLiteral].
+// As for any discriminator field, the member is removed from objects
+// before unmarshaling them into a chosen type that has no field for
+// it, so that it is accepted even when unknown members are rejected
+// with [json.RejectUnknownMembers].
+func WithTypenameField(name string) Option {
+	return WithDiscriminatorField(name)
+}
+
+// WithGoFieldNameAlias causes the discriminator field to be found
+// under the Go name of the [Const] field that declares it, as well as
+// under its JSON name, for producers that use the Go name. For
+// example, with a discriminator field declared as
+//
+//	Kind Const[string, struct{string `const:"dog"`}] `json:"kind"`
+//
+// both {"kind": "dog"} and {"Kind": "dog"} choose the same type. When
+// both are present, the JSON name takes precedence.
+//
+// It has no effect with [WithCompositeKey] or [WithDiscriminatorPath].
+func WithGoFieldNameAlias() Option {
+	return func(o *options) {
+		o.goNameAlias = true
+	}
+}
+
+// WithFieldNameMapper causes the JSON names of [Const] fields that do
+// not have an explicit name in their json tag to be derived by calling
+// mapName with their Go field name, rather than being the Go field name
+// itself. This keeps discriminator detection in line with a project-wide
+// naming convention (for example snake_case) used on the wire.
+//
+// Names given explicitly in json tags are always used unchanged.
+func WithFieldNameMapper(mapName func(goName string) string) Option {
+	return func(o *options) {
+		o.mapFieldName = mapName
+	}
+}
+
+// WithFirstMatch allows several choices to share the same
+// discriminator value, in which case the first such choice is used
+// when unmarshaling that value and the others are never used. Without
+// this option, a field only qualifies as the discriminator when its
+// value is different in every choice.
+//
+// This is lossy and is intended for migration scenarios, such as when
+// two types temporarily share a discriminator value.
+func WithFirstMatch() Option {
+	return func(o *options) {
+		o.firstMatch = true
+	}
+}
+
+// WithMatcher adds a choice that is selected by the structure of the
+// JSON object rather than by a discriminator value, for objects that do
+// not contain the discriminator field at all. The concrete type of
+// choice must implement the type being unmarshaled, but need not have
+// a discriminator field.
+//
+// When the discriminator field is absent, match is called with each
+// such object, and the choice is used if it returns true. Matchers are
+// consulted in the order in which they were added, after looking for
+// the discriminator field and before resorting to any fallback choice.
+// Matchers are never consulted for objects that contain the
+// discriminator field, even if its value is unknown.
+func WithMatcher(choice any, match func(obj jsontext.Value) bool) Option {
+	if choice == nil {
+		panic("nil choice provided to WithMatcher")
+	}
+	if match == nil {
+		panic("nil function provided to WithMatcher")
+	}
+	m := matcher{
+		typ:   reflect.TypeOf(choice),
+		match: match,
+	}
+	return func(o *options) {
+		o.matchers = append(o.matchers, m)
+	}
+}
+
+// WithLiteral adds a choice that is selected when the discriminator
+// field holds the given value. Unlike the choices passed to [Structs],
+// the concrete type of choice need not have a [Const] discriminator
+// field, which allows types that cannot be annotated to take part in
+// the union.
+//
+// The literal values are merged with the values of the Const fields of
+// the other choices, so they must all be different unless
+// [WithFirstMatch] is used. The discriminator field itself is
+// determined from the Const fields of the other choices as usual; when
+// all the choices are given by WithLiteral, it must be specified with
+// [WithDiscriminatorField].
+//
+// Such a choice need not have a field for the discriminator at all, in
+// which case the discriminator member is removed before unmarshaling
+// into it when unknown members are rejected with
+// [json.RejectUnknownMembers]. It may instead hold the discriminator in
+// a plain field, such as a string field with the same JSON name, which
+// then receives the value when unmarshaling. Unlike a Const field, it
+// is not checked or filled in automatically, so values of the choice
+// that are created in Go must set it explicitly to marshal correctly.
+func WithLiteral(choice any, value LiteralValue) Option {
+	if choice == nil {
+		panic("nil choice provided to WithLiteral")
+	}
+	l := literal{
+		typ:   reflect.TypeOf(choice),
+		value: value.value,
+	}
+	return func(o *options) {
+		o.literals = append(o.literals, l)
+	}
+}
+
+// WithVariantValue is like [WithLiteral] except that value is given
+// directly and variant may also be among the choices passed to
+// [Structs], which suits third-party types that cannot be annotated
+// but are listed alongside the others. Such a choice is not
+// considered when determining the discriminator field from the
+// [Const] fields of the other choices. The value must be comparable.
+func WithVariantValue(variant, value any) Option {
+	if variant == nil {
+		panic("nil choice provided to WithVariantValue")
+	}
+	if value == nil {
+		panic("nil value provided to WithVariantValue")
+	}
+	if !reflect.TypeOf(value).Comparable() {
+		panic("non-comparable value provided to WithVariantValue")
+	}
+	return WithLiteral(variant, LiteralValue{value: value})
+}
+
+// LiteralValue holds a discriminator value given at run time.
+// See [Literal].
+type LiteralValue struct {
+	value any
+}
+
+// Literal returns a discriminator value for use with [WithLiteral].
+// It is the run time equivalent of a [Const] field holding the same
+// value.
+func Literal[T comparable](value T) LiteralValue {
+	return LiteralValue{value: value}
+}
+
+// WithTypeNameDiscriminator causes choices without any [Const] fields
+// to take their discriminator value from their type, as returned by
+// typeName, avoiding the need to spell out the value in a Const field
+// when it is derived from the name of the type anyway. See
+// [LowerTypeName] for a suitable function.
+//
+// As with [WithLiteral], the resulting values are merged with the
+// values of the Const fields of the other choices, and the
+// discriminator field must be specified with [WithDiscriminatorField]
+// if no choices have Const fields.
+func WithTypeNameDiscriminator(typeName func(reflect.Type) string) Option {
+	return func(o *options) {
+		o.typeName = typeName
+	}
+}
+
+// LowerTypeName returns the name of t, or of the type it points to if
+// it is a pointer type, in lower case. For example, it returns "dog"
+// for both Dog and *Dog.
+func LowerTypeName(t reflect.Type) string {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return strings.ToLower(t.Name())
+}
+
+// extraChoices returns the types of the choices added by options.
+func (o *options) extraChoices() []reflect.Type {
+	var types []reflect.Type
+	for _, l := range o.literals {
+		types = append(types, l.typ)
+	}
+	for _, m := range o.matchers {
+		types = append(types, m.typ)
+	}
+	for _, k := range slices.Sorted(maps.Keys(o.kinds)) {
+		types = append(types, o.kinds[k])
+	}
+	for _, t := range []reflect.Type{o.missingType, o.unknownType} {
+		if t != nil {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// match returns the type of the first matcher choice that matches the
+// JSON object obj, or nil if there is none.
+func (o *options) match(obj jsontext.Value) reflect.Type {
+	for _, m := range o.matchers {
+		if m.match(obj) {
+			return m.typ
+		}
+	}
+	return nil
+}
+
+// WithVariantUnmarshaler causes the choice selected by the given
+// discriminator value to be unmarshaled by calling unmarshal rather
+// than in the usual way, which allows a single choice to use a bespoke
+// format without giving up the union for the others.
+//
+// The unmarshal function is called with the JSON object (or the body,
+// when [WithBodyField] is used) and a non-nil pointer to the zero
+// value of the choice's struct type, which it should fill in. For
+// example, for a choice given as (*Dog)(nil) or Dog{}, it is called
+// with a *Dog.
+//
+// It is an error if value is not the discriminator value of a choice.
+func WithVariantUnmarshaler(value LiteralValue, unmarshal func(data jsontext.Value, dst any) error) Option {
+	vu := variantUnmarshaler{
+		value:     value.value,
+		unmarshal: unmarshal,
+	}
+	return func(o *options) {
+		o.variantUnmarshalers = append(o.variantUnmarshalers, vu)
+	}
+}
+
+// WithInnerOptions adds options used when unmarshaling the value of
+// the chosen concrete type. They are applied after the options in
+// effect for the union value as a whole, so they take precedence over
+// them. This allows, for example, unknown members to be rejected
+// within the choices only.
+//
+// Note that the union value is always read using the syntactic
+// options of the outer decoder, so syntactic options such as
+// [jsontext.AllowDuplicateNames] can only make unmarshaling the chosen
+// type stricter, not more lenient. Giving any of them also means that
+// the value is read in full before it is unmarshaled, even when the
+// discriminator member comes first.
+func WithInnerOptions(opts ...json.Options) Option {
+	return func(o *options) {
+		o.innerOpts = append(o.innerOpts, opts...)
+	}
+}
+
+// WithRejectUnknown causes unmarshaling to fail when the object
+// holding a choice has members that the chosen type does not accept,
+// rather than ignoring them. Only the choice itself is checked: members
+// of the enclosing values are handled as usual. The discriminator
+// member is always accepted, even when the type has no field for it.
+//
+// It is equivalent to [WithInnerOptions] with
+// [json.RejectUnknownMembers] set to true.
+func WithRejectUnknown() Option {
+	return WithInnerOptions(json.RejectUnknownMembers(true))
+}
+
+// WithDiscriminatorPath causes the discriminator value to be read from
+// a member nested inside the JSON object rather than from a member of
+// the object itself. Each element of path names a member of the object
+// found by the previous elements. For example, the path "meta", "kind"
+// finds the value "dog" in:
+//
+//	{"meta": {"kind": "dog"}, "bark": "woof"}
+//
+// The value is matched against the constant values of the choices'
+// discriminator field as usual. As that field does not itself appear
+// in the JSON, it will usually be tagged with `json:"-"`.
+//
+// It cannot be used together with [WithCompositeKey].
+func WithDiscriminatorPath(path ...string) Option {
+	if len(path) == 0 {
+		panic("empty path provided to WithDiscriminatorPath")
+	}
+	return func(o *options) {
+		o.discrimPath = path
+	}
+}
+
+// WithBodyField causes only the value of the given field of the JSON
+// object, rather than the whole object, to be unmarshaled into the
+// choice selected by the discriminator. This supports envelopes such
+// as:
+//
+//	{"kind": "dog", "data": {"bark": "woof"}}
+//
+// The discriminator field is read from the envelope as usual. As it is
+// not present in the body, the chosen type's [Const] field is not
+// checked when unmarshaling. A fallback choice, or one selected by
+// [WithMatcher], is unmarshaled from the whole envelope.
+//
+// It is an error for the body field to be absent unless
+// [WithOptionalBody] is also used.
+func WithBodyField(name string) Option {
+	return func(o *options) {
+		o.bodyField = name
+	}
+}
+
+// WithOptionalBody causes a choice to be unmarshaled from an empty
+// object when the field named by [WithBodyField] is absent, rather than
+// that being an error.
+func WithOptionalBody() Option {
+	return func(o *options) {
+		o.optionalBody = true
+	}
+}
+
+// WithBareStringShorthand causes a JSON string to be accepted in place
+// of an object holding only the discriminator field with that string
+// as its value. For example, "dog" is treated as {"type": "dog"}, and
+// so unmarshals as the choice for "dog" with all its other fields left
+// as zero.
+//
+// Strings that are not known discriminator values are treated like
+// any other object with an unknown discriminator value. This applies
+// even when [WithObjectRequired] is used.
+//
+// It has no effect with [WithCompositeKey] or [WithDiscriminatorPath].
+func WithBareStringShorthand() Option {
+	return func(o *options) {
+		o.bareString = true
+	}
+}
+
+// WithEmptyAsMissing causes a discriminator field holding the empty
+// string to be treated as if the field were absent, so the object is
+// passed to any matchers added with [WithMatcher] or the fallback
+// choice. When there is no fallback and no matcher applies,
+// unmarshaling fails with a [*MissingFieldError] rather than an
+// unknown value error.
+//
+// With [WithCompositeKey], an empty string in any of the key fields
+// causes the key as a whole to be treated as missing. The empty string
+// cannot be used as the discriminator value of any choice.
+func WithEmptyAsMissing() Option {
+	return func(o *options) {
+		o.emptyAsMissing = true
+	}
+}
+
+// WithMissingVariant causes objects without the discriminator field
+// to be unmarshaled into a value of the concrete type of choice, rather
+// than into the fallback. Together with [WithUnknownVariant], this
+// routes objects with a missing discriminator and those with an unknown
+// one to different types. For example:
+//
+//	jsondiscrim.StructsWithOptions[Message](
+//		[]jsondiscrim.Option{
+//			jsondiscrim.WithMissingVariant((*DefaultMessage)(nil)),
+//			jsondiscrim.WithUnknownVariant((*UnknownMessage)(nil)),
+//		},
+//		nil,
+//		(*TextMessage)(nil),
+//		(*ImageMessage)(nil),
+//	)
+//
+// Values that are not objects still use the fallback, if any.
+// A [WithMatcher] choice that matches takes precedence.
+func WithMissingVariant(choice any) Option {
+	if choice == nil {
+		panic("nil choice provided to WithMissingVariant")
+	}
+	t := reflect.TypeOf(choice)
+	return func(o *options) {
+		o.missingType = t
+	}
+}
+
+// WithUnknownVariant causes objects whose discriminator value does not
+// match that of any choice to be unmarshaled into a value of the
+// concrete type of choice, rather than into the fallback. See
+// [WithMissingVariant].
+func WithUnknownVariant(choice any) Option {
+	if choice == nil {
+		panic("nil choice provided to WithUnknownVariant")
+	}
+	t := reflect.TypeOf(choice)
+	return func(o *options) {
+		o.unknownType = t
+	}
+}
+
+// WithLenientNumbers causes a discriminator value in the JSON that is
+// a string holding a decimal integer, such as "7" or "007", to match
+// a choice whose discriminator value is that integer, for producers
+// that quote numbers. The JSON is rewritten to hold the number before
+// unmarshaling into the chosen type, so that its [Const] field accepts
+// it. Leading zeros and a minus sign are allowed, but not a plus sign,
+// a fraction or an exponent.
+//
+// A string that is itself the discriminator value of a choice always
+// selects that choice, so with a string constant "007" and an integer
+// constant 7 the JSON "007" selects the first and the JSON "7" the
+// second. Without this option, strings and numbers never match each
+// other. The option has no effect with [WithCompositeKey].
+func WithLenientNumbers() Option {
+	return func(o *options) {
+		o.lenientNumbers = true
+	}
+}
+
+// WithEmptyObjectAsNil causes an empty JSON object, {}, to be
+// unmarshaled as the zero value of the union type, a nil interface,
+// for schemas that use it to mean that there is no value. This takes
+// precedence over the fallback choice and over [WithMissingVariant],
+// but not over a choice given for objects by [WithKindDiscriminator].
+// The handling of null is unchanged.
+func WithEmptyObjectAsNil() Option {
+	return func(o *options) {
+		o.emptyObjectAsNil = true
+	}
+}
+
+// WithDecodeHook causes hook to be called with each JSON value of the
+// union before the discriminator is extracted from it, and the value
+// it returns to be used in place of the original for both choosing and
+// unmarshaling the choice. This allows values to be normalized first,
+// for example by renaming members or removing a wrapper. For example,
+// the following hook unwraps values such as {"animal": {"type": "dog"}}:
+//
+//	jsondiscrim.WithDecodeHook(func(raw jsontext.Value) (jsontext.Value, error) {
+//		var w struct {
+//			Animal jsontext.Value `json:"animal"`
+//		}
+//		if err := json.Unmarshal(raw, &w); err != nil || w.Animal == nil {
+//			return raw, err
+//		}
+//		return w.Animal, nil
+//	})
+//
+// An error returned by hook is returned from unmarshaling. As every
+// value must be read in full before calling hook, objects are never
+// unmarshaled directly from the decoder. [WithKindDiscriminator]
+// chooses by the kind of the original value, before hook is called.
+// Positions reported in errors from unmarshaling the choice refer to
+// the value returned by hook.
+func WithDecodeHook(hook func(raw jsontext.Value) (jsontext.Value, error)) Option {
+	if hook == nil {
+		panic("nil function provided to WithDecodeHook")
+	}
+	return func(o *options) {
+		o.decodeHook = hook
+	}
+}
+
+// WithStrictDiscriminatorField causes an object without the
+// discriminator field to be rejected when it has exactly one member
+// whose name is one edit away from that of the field, which usually
+// means that the producer misspelled it. An edit is the insertion,
+// deletion or substitution of a single character, or the transposition
+// of two adjacent ones, so for a field named "type" the members
+// "kind" and "types" are treated differently: the first is ignored
+// as usual, while the second causes an error such as
+//
+//	discriminator field "type" not found but "types" is present; did you mean "type"?
+//
+// The error is returned even when there is a fallback or other way of
+// handling objects without the field. The option has no effect with
+// [WithCompositeKey] or [WithDiscriminatorPath].
+func WithStrictDiscriminatorField() Option {
+	return func(o *options) {
+		o.strictField = true
+	}
+}
+
+// WithDiscriminatorFirst requires the discriminator member of each
+// object that has one to be its first member, as written by
+// [Marshalers]. Objects holding it anywhere else are rejected with an
+// error such as
+//
+//	discriminator field "type" is not the first member
+//
+// rather than being read in full so that it can be found. Only the
+// start of each object is then looked at to find the discriminator,
+// which is enough to unmarshal it directly from the decoder when the
+// other options allow that. Objects without the member are handled as
+// usual. The option has no effect with [WithCompositeKey] or
+// [WithDiscriminatorPath].
+func WithDiscriminatorFirst() Option {
+	return func(o *options) {
+		o.discrimFirst = true
+	}
+}
+
+// WithDeprecated marks the given choices as deprecated, as if the
+// [Const] fields holding their discriminator values were tagged with
+// `jsondiscrim:"deprecated"`. This allows choices whose types cannot
+// be annotated to be deprecated. The concrete type of each must be the
+// type of a choice or of the fallback.
+func WithDeprecated(choices ...any) Option {
+	var types []reflect.Type
+	for _, choice := range choices {
+		if choice == nil {
+			panic("nil choice provided to WithDeprecated")
+		}
+		types = append(types, reflect.TypeOf(choice))
+	}
+	return func(o *options) {
+		o.deprecated = append(o.deprecated, types...)
+	}
+}
+
+// WithDeprecationHandler causes handle to be called with the type of
+// each value unmarshaled into a deprecated choice, so that uses of
+// legacy variants can be logged or counted while migrating away from
+// them. Unmarshaling succeeds as usual.
+//
+// A choice is deprecated when any of its Const fields is tagged with
+// `jsondiscrim:"deprecated"`, or when it is given to [WithDeprecated].
+// For example:
+//
+//	type OldDog struct {
+//		Type Const[string, struct{string `const:"olddog"`}] `json:"type" jsondiscrim:"deprecated"`
+//	}
+func WithDeprecationHandler(handle func(t reflect.Type)) Option {
+	return func(o *options) {
+		o.onDeprecated = handle
+	}
+}
+
+// WithNullDiscriminatorAsFallback causes a discriminator field holding
+// null to be treated as if the field were absent, so the object is
+// passed to any matchers added with [WithMatcher] or the fallback
+// choice. When there is no fallback and no matcher applies,
+// unmarshaling fails with a [*MissingFieldError].
+//
+// Without this option, a null discriminator is always an error, even
+// when there is a fallback.
+func WithNullDiscriminatorAsFallback() Option {
+	return func(o *options) {
+		o.nullAsMissing = true
+	}
+}
+
+// WithInheritedDiscriminator causes objects that lack the
+// discriminator field to be treated as if it held the given value,
+// which will usually have been declared once by an enclosing object
+// for all the union values within it. Objects that have the field
+// still use its own value.
+//
+// As options are fixed when the unmarshalers are created, the value is
+// scoped by creating unmarshalers for it when unmarshaling the
+// enclosing object, for example in its UnmarshalJSONFrom method:
+//
+//	unmarshalers := jsondiscrim.StructsWithOptions(
+//		[]jsondiscrim.Option{jsondiscrim.WithInheritedDiscriminator(jsondiscrim.Literal(kind))},
+//		nil, choices...,
+//	)
+//	err := json.UnmarshalDecode(d, &h.Members, json.WithUnmarshalers(unmarshalers))
+//
+// The value then applies only within that call.
+func WithInheritedDiscriminator(value LiteralValue) Option {
+	return func(o *options) {
+		o.inherited = &LiteralValue{value: canonicalValue(value.value)}
+	}
+}
+
+// WithDefaultDiscriminator causes objects that lack the discriminator
+// field to be treated as if it held the given value, which must be the
+// discriminator value of one of the choices. This suits payloads that
+// omit the field for their most common variant. For example, with
+//
+//	jsondiscrim.WithDefaultDiscriminator(jsondiscrim.Literal("text"))
+//
+// the object {"body": "hello"} is unmarshaled like
+// {"type": "text", "body": "hello"}. The same applies to a field that
+// is treated as absent because of [WithEmptyAsMissing] or
+// [WithNullDiscriminatorAsFallback].
+//
+// The default takes precedence over matchers added with [WithMatcher],
+// [WithMissingVariant] and the fallback, but a value given to
+// [WithInheritedDiscriminator] takes precedence over it.
+func WithDefaultDiscriminator(value LiteralValue) Option {
+	return func(o *options) {
+		o.defaultValue = &LiteralValue{value: canonicalValue(value.value)}
+	}
+}
+
+// WithValueResults causes each choice to be unmarshaled as a value of
+// the struct type, even when it was given as a pointer. For example,
+// a choice given as (*Dog)(nil) results in a Dog rather than a *Dog.
+// All the struct types must then implement the interface type being
+// unmarshaled.
+//
+// By default, the result has the same form as the choice.
+func WithValueResults() Option {
+	return func(o *options) {
+		o.results = valueResults
+	}
+}
+
+// WithPointerResults causes each choice to be unmarshaled as a pointer
+// to the struct type, even when it was given as a value. For example,
+// a choice given as Dog{} results in a *Dog rather than a Dog.
+//
+// By default, the result has the same form as the choice.
+func WithPointerResults() Option {
+	return func(o *options) {
+		o.results = pointerResults
+	}
+}
+
+// WithEnvelopeSchema causes unmarshaling to fail, before the
+// discriminator is even looked at, when an object has a member that is
+// not declared by any of the choices, including the fallback. The
+// given fields are also allowed. This catches mistakes such as a
+// misspelled discriminator field in the JSON, which would otherwise be
+// treated as missing so that the fallback is used.
+//
+// Fields holding unknown members, such as a fallback field with the
+// `json:",unknown"` option, do not declare any members, so they do not
+// stop the check. Choices that are not structs do not declare any
+// members either.
+func WithEnvelopeSchema(fields ...string) Option {
+	return func(o *options) {
+		o.envelope = append([]string{}, fields...)
+	}
+}
+
+// WithBlockedValues causes unmarshaling to fail when the discriminator
+// field holds any of the given values, even when there is a fallback
+// choice. This allows retired variants to be rejected outright while
+// the fallback continues to accept values from newer producers.
+//
+// It is an error for a blocked value to be the discriminator value of
+// a choice.
+func WithBlockedValues(values ...any) Option {
+	values = slices.Clone(values)
+	return func(o *options) {
+		o.blocked = append(o.blocked, values...)
+	}
+}
+
+// WithMaxElements limits the number of union values that may be
+// unmarshaled from a single JSON array to n, guarding against resource
+// exhaustion when decoding untrusted input. Unmarshaling fails as soon
+// as an array holds more than n such values. It also limits the number
+// of values read by [UnmarshalStream].
+func WithMaxElements(n int) Option {
+	if n <= 0 {
+		panic("non-positive limit provided to WithMaxElements")
+	}
+	return func(o *options) {
+		o.maxElements = n
+	}
+}
+
+// WithReadLimit limits the number of bytes read by [UnmarshalStream]
+// to n, as if its reader were wrapped with [LimitReader]. It has no
+// effect on unmarshalers, which do not control their reader; use
+// LimitReader directly for those.
+func WithReadLimit(n int64) Option {
+	if n <= 0 {
+		panic("non-positive limit provided to WithReadLimit")
+	}
+	return func(o *options) {
+		o.readLimit = n
+	}
+}
+
+// WithObjectRequired causes unmarshaling to fail with a
+// [*NotObjectError] when the value is not a JSON object, even when
+// there is a fallback choice. Without this option, non-object values
+// are passed to the fallback choice to unmarshal as it sees fit.
+//
+// When there is no fallback, non-object values are always an error.
+func WithObjectRequired() Option {
+	return func(o *options) {
+		o.objectRequired = true
+	}
+}
+
+// WithKindDiscriminator causes the kind of a JSON value to select the
+// choice it is unmarshaled into, for unions whose variants have
+// different JSON shapes, such as a string in one case and an object in
+// another. A value whose kind is in kinds is unmarshaled directly into
+// a value of the corresponding type, without looking for any
+// discriminator field. The kinds 't' and 'f' are interchangeable, so
+// either may be used for JSON booleans.
+//
+// Values of other kinds are unmarshaled as usual, so objects can
+// still be discriminated by field among the choices passed to
+// [StructsWithOptions]; when there are no such choices and no
+// fallback, unmarshaling fails with a [*KindError].
+func WithKindDiscriminator(kinds map[jsontext.Kind]reflect.Type) Option {
+	kinds = maps.Clone(kinds)
+	return func(o *options) {
+		o.kinds = kinds
+	}
+}
+
+// kindType returns the type added with [WithKindDiscriminator]
+// for JSON values of kind k, or nil if there is none.
+func (o *options) kindType(k jsontext.Kind) reflect.Type {
+	if t := o.kinds[k]; t != nil {
+		return t
+	}
+	switch k {
+	case 't':
+		return o.kinds['f']
+	case 'f':
+		return o.kinds['t']
+	}
+	return nil
+}
+
+// WithCompositeKey causes the discriminator value to be computed from
+// several JSON fields rather than just one. The values of the given
+// fields are passed, in order, to join, and the result is looked up
+// in the constant values of the choices' discriminator fields, which
+// should therefore hold the joined form. All the fields must be
+// present for the discriminator to be considered present.
+//
+// As the discriminator field does not itself appear in the JSON, it
+// will usually be tagged with `json:"-"`.
+func WithCompositeKey(fields []string, join func([]any) any) Option {
+	if len(fields) == 0 {
+		panic("no fields provided to WithCompositeKey")
+	}
+	if join == nil {
+		panic("nil function provided to WithCompositeKey")
+	}
+	return func(o *options) {
+		o.keyFields = fields
+		o.keyJoin = join
+	}
+}
+
+// WithTrimDiscriminator causes leading and trailing white space to be
+// removed from string discriminator values in the JSON before they are
+// matched against the constant values of the choices. The constant
+// values themselves are always used literally.
+//
+// When a trimmed value matches, the JSON is rewritten to hold the
+// trimmed value before unmarshaling into the chosen type, so that its
+// [Const] field accepts it.
+func WithTrimDiscriminator() Option {
+	return func(o *options) {
+		o.trim = true
+	}
+}
+
+// WithUnicodeFold causes string discriminator values in the JSON to
+// match the constant values of the choices regardless of case, using
+// Unicode simple case folding rather than comparing ASCII letters
+// only. Two strings match when they have the same length in runes and
+// each pair of corresponding runes is equivalent under
+// [unicode.SimpleFold], as reported by [strings.EqualFold]. The
+// comparison does not depend on the locale, and one rune never
+// matches several, so for example "K" (the Kelvin sign) matches "k"
+// and "ſ" matches "s", but "ß" does not match "ss", and the Turkish
+// "İ" and "ı" match only themselves.
+//
+// It is an error for two choices to have values that match each other.
+// As for [WithTrimDiscriminator], the JSON is rewritten to hold the
+// constant value before unmarshaling into the chosen type. The option
+// has no effect on the values joined by [WithCompositeKey].
+//
+// The same matching can be declared in the choices themselves with
+// the case:ignore json tag option, as described in [Structs].
+func WithUnicodeFold() Option {
+	return func(o *options) {
+		o.unicodeFold = true
+	}
+}
+
+// canPeek reports whether the discriminator value can be found by
+// looking at the start of an object only, allowing the object to
+// be unmarshaled directly from the decoder.
+func (o *options) canPeek() bool {
+	return o.keyFields == nil && o.discrimPath == nil && !o.innerSyntactic && o.bodyField == "" && o.envelope == nil && o.decodeHook == nil
+}
+
+// hasDecodeOptions reports whether opts sets any of the syntactic
+// options that affect decoding.
+func hasDecodeOptions(opts []json.Options) bool {
+	joined := json.JoinOptions(opts...)
+	_, dup := json.GetOption(joined, jsontext.AllowDuplicateNames)
+	_, invalid := json.GetOption(joined, jsontext.AllowInvalidUTF8)
+	return dup || invalid
+}
+
+// discrimValue returns the discriminator value found in the JSON
+// object data given the name of the discriminator field.
+// It also reports whether the value has been normalized so that it
+// differs from the value in the JSON.
+func (o *options) discrimValue(data []byte, discrimField string) (_ any, normalized bool, _ error) {
+	if o.discrimPath != nil {
+		v, err := fieldPathValue(data, o.discrimPath)
+		if err != nil {
+			return nil, false, err
+		}
+		// The value is not in the discriminator field itself,
+		// so there is nothing to rewrite.
+		v, _ = o.normalize(v)
+		if o.isMissing(v) {
+			return nil, false, &MissingFieldError{Field: strings.Join(o.discrimPath, ".")}
+		}
+		return v, false, nil
+	}
+	if o.keyFields == nil {
+		v, err := fieldValue(data, discrimField)
+		if err != nil {
+			return nil, false, err
+		}
+		v, normalized = o.normalize(v)
+		if o.isMissing(v) {
+			return nil, false, &MissingFieldError{Field: discrimField}
+		}
+		return v, normalized, nil
+	}
+	values, err := fieldValues(data, o.keyFields)
+	if err != nil {
+		return nil, false, err
+	}
+	for i, v := range values {
+		values[i], _ = o.normalize(v)
+		if o.isMissing(values[i]) {
+			return nil, false, &MissingFieldError{Field: o.keyFields[i]}
+		}
+	}
+	// The joined value never appears in the JSON, so there is
+	// nothing to rewrite.
+	return canonicalValue(o.keyJoin(values)), false, nil
+}
+
+// normalize returns the normalized form of the discriminator value v
+// and reports whether it differs from v.
+func (o *options) normalize(v any) (any, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return v, false
+	}
+	ns := s
+	if o.trim {
+		ns = strings.TrimSpace(ns)
+	}
+	if o.foldedValues != nil {
+		if cv, ok := o.foldedValues[foldString(ns)]; ok {
+			ns = cv
+		}
+	}
+	if o.lenientNumbers && o.discrimValues[ns] == nil {
+		if n, ok := parseDecimalInt(ns); ok && o.discrimValues[n] != nil {
+			return n, true
+		}
+	}
+	if ns == s {
+		// Return v itself to avoid allocating a new interface value.
+		return v, false
+	}
+	return ns, true
+}
+
+// parseDecimalInt returns the canonical value of s if it holds a
+// decimal integer, with an optional minus sign and any number of
+// leading zeros, that is in the range of int64 or uint64.
+func parseDecimalInt(s string) (any, bool) {
+	digits := strings.TrimPrefix(s, "-")
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return nil, false
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, true
+	}
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return u, true
+	}
+	return nil, false
+}
+
+// foldString returns s with each rune replaced by the smallest rune
+// equivalent to it under [unicode.SimpleFold], so that two strings
+// have the same folded form exactly when [strings.EqualFold] reports
+// them as equal.
+func foldString(s string) string {
+	return strings.Map(func(r rune) rune {
+		least := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			least = min(least, f)
+		}
+		return least
+	}, s)
+}
+
+// discrimName returns a description of where the discriminator
+// value is found given the name of the discriminator field.
+func (o *options) discrimName(discrimField string) string {
+	switch {
+	case o.discrimPath != nil:
+		return strings.Join(o.discrimPath, ".")
+	case o.keyFields != nil:
+		return strings.Join(o.keyFields, "+")
+	}
+	return discrimField
+}
+
+// isMissing reports whether the normalized discriminator value v
+// should be treated as if its field were absent.
+func (o *options) isMissing(v any) bool {
+	return o.emptyAsMissing && v == "" || o.nullAsMissing && v == nil
+}
@@ -0,0 +1,2711 @@
+package jsondiscrim
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestObjectRequired(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		fallback Animal
+		opts     []Option
+		want     Animal
+		wantKind jsontext.Kind
+		wantErr  string
+	}{
+		{
+			name:     "string without fallback",
+			json:     `"dog"`,
+			wantKind: '"',
+			wantErr:  `.*cannot unmarshal JSON string into jsondiscrim.Animal: expected object`,
+		},
+		{
+			name:     "array without fallback",
+			json:     `[{"type":"dog"}]`,
+			wantKind: '[',
+			wantErr:  `.*cannot unmarshal JSON array into jsondiscrim.Animal: expected object`,
+		},
+		{
+			name:     "number without fallback",
+			json:     `1`,
+			wantKind: '0',
+			wantErr:  `.*cannot unmarshal JSON number into jsondiscrim.Animal: expected object`,
+		},
+		{
+			name:     "string with fallback",
+			json:     `"dog"`,
+			fallback: (*ScalarAnimal)(nil),
+			want:     &ScalarAnimal{Name: "dog"},
+		},
+		{
+			name:     "string with fallback and object required",
+			json:     `"dog"`,
+			fallback: (*ScalarAnimal)(nil),
+			opts:     []Option{WithObjectRequired()},
+			wantKind: '"',
+			wantErr:  `.*cannot unmarshal JSON string into jsondiscrim.Animal: expected object`,
+		},
+		{
+			name:     "object with fallback and object required",
+			json:     `{"type":"dog","Bark":"woof"}`,
+			fallback: (*OtherAnimal)(nil),
+			opts:     []Option{WithObjectRequired()},
+			want:     &Dog{Bark: "woof"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](tt.opts, tt.fallback,
+				(*Dog)(nil),
+				(*Cat)(nil),
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				var notObject *NotObjectError
+				qt.Assert(t, qt.ErrorAs(err, &notObject))
+				qt.Assert(t, qt.Equals(notObject.Kind, tt.wantKind))
+				qt.Assert(t, qt.Equals(notObject.Type, reflect.TypeFor[Animal]()))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestObjectRequiredFallbackOnly(t *testing.T) {
+	var got Animal
+	err := json.Unmarshal([]byte(`"dog"`), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+		[]Option{WithObjectRequired()},
+		(*ScalarAnimal)(nil),
+	)))
+	qt.Assert(t, qt.IsTrue(errors.As(err, new(*NotObjectError))))
+
+	err = json.Unmarshal([]byte(`"dog"`), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+		nil,
+		(*ScalarAnimal)(nil),
+	)))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, Animal(&ScalarAnimal{Name: "dog"})))
+}
+
+// ScalarAnimal is an [Animal] represented as a bare JSON string.
+type ScalarAnimal struct {
+	Name string
+}
+
+func (*ScalarAnimal) isAnimal() {}
+
+func (a *ScalarAnimal) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &a.Name)
+}
+
+type Resource interface {
+	isResource()
+}
+
+type Deployment struct {
+	Kind stringConst[struct {
+		string `const:"apps/deployment"`
+	}] `json:"-"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Replicas  int    `json:"replicas"`
+}
+
+func (Deployment) isResource() {}
+
+type Service struct {
+	Kind stringConst[struct {
+		string `const:"core/service"`
+	}] `json:"-"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Port      int    `json:"port"`
+}
+
+func (Service) isResource() {}
+
+func TestCompositeKey(t *testing.T) {
+	join := func(values []any) any {
+		return fmt.Sprintf("%v/%v", values[0], values[1])
+	}
+	tests := []struct {
+		name    string
+		json    string
+		want    Resource
+		wantErr string
+	}{
+		{
+			name: "deployment",
+			json: `{"namespace":"apps","name":"deployment","replicas":3}`,
+			want: &Deployment{Namespace: "apps", Name: "deployment", Replicas: 3},
+		},
+		{
+			name: "service with fields reversed",
+			json: `{"port":80,"name":"service","namespace":"core"}`,
+			want: &Service{Namespace: "core", Name: "service", Port: 80},
+		},
+		{
+			name:    "unknown key",
+			json:    `{"namespace":"core","name":"deployment"}`,
+			wantErr: `.*unknown discriminator value "core/deployment".*`,
+		},
+		{
+			name:    "missing field",
+			json:    `{"namespace":"core"}`,
+			wantErr: `.*discriminator field "name" not found`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Resource
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Resource](
+				[]Option{WithCompositeKey([]string{"namespace", "name"}, join)},
+				nil,
+				(*Deployment)(nil),
+				(*Service)(nil),
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestCompositeKeyPanics(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		WithCompositeKey(nil, func([]any) any { return nil })
+	}, `no fields provided to WithCompositeKey`))
+	qt.Assert(t, qt.PanicMatches(func() {
+		WithCompositeKey([]string{"a"}, nil)
+	}, `nil function provided to WithCompositeKey`))
+}
+
+func TestFieldValues(t *testing.T) {
+	values, err := fieldValues([]byte(`{"c":true,"a":1,"b":"x","a":1.0}`), []string{"a", "b", "c"})
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(values, []any{int64(1), "x", true}))
+
+	_, err = fieldValues([]byte(`{"c":true,"a":1,"b":"x","a":2}`), []string{"a", "b", "c"})
+	qt.Assert(t, qt.ErrorMatches(err, `duplicate field "a" has conflicting values 1 and 2`))
+
+	_, err = fieldValues([]byte(`{"a":1}`), []string{"a", "b"})
+	qt.Assert(t, qt.ErrorMatches(err, `discriminator field "b" not found`))
+}
+
+func TestTrimDiscriminator(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		opts    []Option
+		want    Animal
+		wantErr string
+	}{
+		{
+			name:    "padded without option",
+			json:    `{"type":" dog ","Bark":"woof"}`,
+			wantErr: `.*unknown discriminator value " dog ".*`,
+		},
+		{
+			name: "padded with option",
+			json: `{"Bark":"woof","type":"\tdog\n"}`,
+			opts: []Option{WithTrimDiscriminator()},
+			want: &Dog{Bark: "woof"},
+		},
+		{
+			name: "unpadded with option",
+			json: `{"type":"cat","Meow":"meow"}`,
+			opts: []Option{WithTrimDiscriminator()},
+			want: &Cat{Meow: "meow"},
+		},
+		{
+			name:    "padded unknown with option",
+			json:    `{"type":" bird "}`,
+			opts:    []Option{WithTrimDiscriminator()},
+			wantErr: `.*unknown discriminator value "bird".*`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+				tt.opts,
+				nil,
+				(*Dog)(nil),
+				(*Cat)(nil),
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+type Elk struct {
+	BaseAnimal[struct {
+		string `const:"élk"`
+	}]
+}
+
+func (Elk) isAnimal() {}
+
+type ElkV2 struct {
+	BaseAnimal[struct {
+		string `const:"ÉLK"`
+	}]
+}
+
+func (ElkV2) isAnimal() {}
+
+type Ibex struct {
+	BaseAnimal[struct {
+		string `const:"İbex"`
+	}]
+}
+
+func (Ibex) isAnimal() {}
+
+type Strauss struct {
+	BaseAnimal[struct {
+		string `const:"strauß"`
+	}]
+}
+
+func (Strauss) isAnimal() {}
+
+func TestUnicodeFold(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		opts    []Option
+		want    Animal
+		wantErr string
+	}{
+		{
+			name:    "non-ASCII case without option",
+			json:    `{"type":"ÉLK"}`,
+			wantErr: `.*unknown discriminator value "ÉLK".*`,
+		},
+		{
+			name: "non-ASCII case",
+			json: `{"type":"ÉLK"}`,
+			opts: []Option{WithUnicodeFold()},
+			want: &Elk{},
+		},
+		{
+			name: "exact",
+			json: `{"type":"élk"}`,
+			opts: []Option{WithUnicodeFold()},
+			want: &Elk{},
+		},
+		{
+			name: "ASCII case",
+			json: `{"type":"DOG","Bark":"woof"}`,
+			opts: []Option{WithUnicodeFold()},
+			want: &Dog{Bark: "woof"},
+		},
+		{
+			name: "dotted capital I",
+			json: `{"type":"İBEX"}`,
+			opts: []Option{WithUnicodeFold()},
+			want: &Ibex{},
+		},
+		{
+			name:    "dotless lowercase i",
+			json:    `{"type":"ıbex"}`,
+			opts:    []Option{WithUnicodeFold()},
+			wantErr: `.*unknown discriminator value "ıbex".*`,
+		},
+		{
+			name:    "ASCII i does not match dotted capital I",
+			json:    `{"type":"ibex"}`,
+			opts:    []Option{WithUnicodeFold()},
+			wantErr: `.*unknown discriminator value "ibex".*`,
+		},
+		{
+			name: "capital sharp s",
+			json: `{"type":"STRAUẞ"}`,
+			opts: []Option{WithUnicodeFold()},
+			want: &Strauss{},
+		},
+		{
+			name:    "sharp s does not match ss",
+			json:    `{"type":"STRAUSS"}`,
+			opts:    []Option{WithUnicodeFold()},
+			wantErr: `.*unknown discriminator value "STRAUSS".*`,
+		},
+		{
+			name: "with trim",
+			json: `{"type":" Élk "}`,
+			opts: []Option{WithUnicodeFold(), WithTrimDiscriminator()},
+			want: &Elk{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+				tt.opts,
+				nil,
+				(*Dog)(nil),
+				(*Elk)(nil),
+				(*Ibex)(nil),
+				(*Strauss)(nil),
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestUnicodeFoldConflict(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[Animal]([]Option{WithUnicodeFold()}, nil, (*Elk)(nil), (*ElkV2)(nil))
+	}, `discriminator values "ÉLK" of \*jsondiscrim.ElkV2 and "élk" of \*jsondiscrim.Elk are equal under Unicode case folding`))
+
+	// Without the option, the values are distinct.
+	StructsWithOptions[Animal](nil, nil, (*Elk)(nil), (*ElkV2)(nil))
+}
+
+type Wolf struct {
+	Kind stringConst[struct {
+		string `const:"wolf"`
+	}] `json:"kind,case:ignore"`
+	Howl string
+}
+
+func (*Wolf) isAnimal() {}
+
+type Fox struct {
+	Kind stringConst[struct {
+		string `const:"fox"`
+	}] `json:"kind,omitzero,case:ignore"`
+}
+
+func (*Fox) isAnimal() {}
+
+type Jackal struct {
+	Kind stringConst[struct {
+		string `const:"jackal"`
+	}] `json:"kind"`
+}
+
+func (*Jackal) isAnimal() {}
+
+func TestCaseIgnoreTag(t *testing.T) {
+	unmarshalers := json.WithUnmarshalers(Structs[Animal]((*Wolf)(nil), (*Fox)(nil)))
+	tests := []struct {
+		name    string
+		json    string
+		want    Animal
+		wantErr string
+	}{
+		{
+			name: "exact",
+			json: `{"kind":"wolf","Howl":"awoo"}`,
+			want: &Wolf{Howl: "awoo"},
+		},
+		{
+			name: "upper case",
+			json: `{"kind":"WOLF","Howl":"awoo"}`,
+			want: &Wolf{Howl: "awoo"},
+		},
+		{
+			name: "mixed case after other member",
+			json: `{"Howl":"awoo","kind":"Wolf"}`,
+			want: &Wolf{Howl: "awoo"},
+		},
+		{
+			name: "other choice",
+			json: `{"kind":"FoX"}`,
+			want: &Fox{},
+		},
+		{
+			name:    "unknown",
+			json:    `{"kind":"coyote"}`,
+			wantErr: `.*unknown discriminator value "coyote".*`,
+		},
+		{
+			name:    "name matched exactly",
+			json:    `{"KIND":"wolf"}`,
+			wantErr: `.*discriminator field "kind" not found`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got, unmarshalers)
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestCaseIgnoreTagMixed(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		Structs[Animal]((*Wolf)(nil), (*Jackal)(nil))
+	}, `discriminator field "kind" has the case:ignore option in \*jsondiscrim.Wolf but not in \*jsondiscrim.Jackal`))
+}
+
+func TestFoldString(t *testing.T) {
+	values := []string{"élk", "ÉLK", "Kelvin", "\u212Aelvin", "ſ", "S", "İ", "ı", "i"}
+	for _, s := range values {
+		for _, s1 := range values {
+			qt.Check(t, qt.Equals(foldString(s) == foldString(s1), strings.EqualFold(s, s1)), qt.Commentf("%q %q", s, s1))
+		}
+	}
+}
+
+func TestReplaceFieldValue(t *testing.T) {
+	got, err := replaceFieldValue(jsontext.Value(`{"a": 1, "type": " x ", "b": [true]}`), "type", "x")
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(got), `{"a":1,"type":"x","b":[true]}`))
+}
+
+type DogV2 struct {
+	BaseAnimal[struct {
+		string `const:"dog"`
+	}]
+	Bark   string
+	Volume int
+}
+
+func (DogV2) isAnimal() {}
+
+func TestFirstMatch(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		Structs[Animal]((*DogV2)(nil), (*Dog)(nil), (*Cat)(nil))
+	}, `cannot determine discriminator from possibles \[type\]`))
+
+	field, byValue, _, err := discriminator(applyOptions([]Option{WithFirstMatch()}), []Animal{(*DogV2)(nil), (*Dog)(nil), (*Cat)(nil)})
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(field, "type"))
+	qt.Assert(t, qt.Equals(byValue["dog"], reflect.TypeFor[*DogV2]()))
+
+	unmarshalers := json.WithUnmarshalers(StructsWithOptions[Animal](
+		[]Option{WithFirstMatch()},
+		nil,
+		(*DogV2)(nil),
+		(*Dog)(nil),
+		(*Cat)(nil),
+	))
+	var got Animal
+	err = json.Unmarshal([]byte(`{"type":"dog","Bark":"woof","Volume":11}`), &got, unmarshalers)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, Animal(&DogV2{Bark: "woof", Volume: 11})))
+
+	err = json.Unmarshal([]byte(`{"type":"cat"}`), &got, unmarshalers)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, Animal(&Cat{})))
+}
+
+func TestFirstMatchPrefersMostDistinct(t *testing.T) {
+	// With WithFirstMatch, the shared schema version field is
+	// present in every choice but should not be chosen over the
+	// type field.
+	field, _, _, err := discriminator(applyOptions([]Option{WithFirstMatch()}), []Shape{(*Square)(nil), (*Circle)(nil)})
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(field, "type"))
+}
+
+// LegacyFish has no discriminator field but is recognized by the
+// presence of its Fins field.
+type LegacyFish struct {
+	Fins int
+}
+
+func (*LegacyFish) isAnimal() {}
+
+func hasField(name string) func(jsontext.Value) bool {
+	return func(obj jsontext.Value) bool {
+		_, err := fieldValue(obj, name)
+		return err == nil
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		fallback Animal
+		want     Animal
+		wantErr  string
+	}{
+		{
+			name: "discriminator takes precedence",
+			json: `{"type":"dog","Fins":2}`,
+			want: &Dog{},
+		},
+		{
+			name: "missing discriminator matched",
+			json: `{"Fins":2}`,
+			want: &LegacyFish{Fins: 2},
+		},
+		{
+			name:     "missing discriminator matched before fallback",
+			json:     `{"Fins":2}`,
+			fallback: (*OtherAnimal)(nil),
+			want:     &LegacyFish{Fins: 2},
+		},
+		{
+			name:     "missing discriminator not matched uses fallback",
+			json:     `{"Legs":2}`,
+			fallback: (*OtherAnimal)(nil),
+			want:     &OtherAnimal{OtherFields: jsontext.Value(`{"Legs":2}`)},
+		},
+		{
+			name:    "missing discriminator not matched without fallback",
+			json:    `{"Legs":2}`,
+			wantErr: `.*discriminator field "type" not found`,
+		},
+		{
+			name:    "unknown discriminator is not matched",
+			json:    `{"type":"fish","Fins":2}`,
+			wantErr: `.*unknown discriminator value "fish".*`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+				[]Option{
+					WithMatcher((*LegacyFish)(nil), hasField("Fins")),
+					WithMatcher((*OtherAnimal)(nil), hasField("Fins")),
+				},
+				tt.fallback,
+				(*Dog)(nil),
+				(*Cat)(nil),
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestMatcherOnly(t *testing.T) {
+	var got Animal
+	err := json.Unmarshal([]byte(`{"Fins":1}`), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+		[]Option{WithMatcher((*LegacyFish)(nil), hasField("Fins"))},
+		(*OtherAnimal)(nil),
+	)))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, Animal(&LegacyFish{Fins: 1})))
+}
+
+func TestMatcherNotImplementing(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[Animal](
+			[]Option{WithMatcher(LegacyFish{}, hasField("Fins"))},
+			nil,
+			(*Dog)(nil),
+		)
+	}, `choice jsondiscrim.LegacyFish does not implement jsondiscrim.Animal`))
+}
+
+func TestMatcherPanics(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		WithMatcher(nil, hasField("Fins"))
+	}, `nil choice provided to WithMatcher`))
+	qt.Assert(t, qt.PanicMatches(func() {
+		WithMatcher((*LegacyFish)(nil), nil)
+	}, `nil function provided to WithMatcher`))
+}
+
+func TestInnerOptions(t *testing.T) {
+	type Zoo struct {
+		Animals []Animal
+	}
+	tests := []struct {
+		name    string
+		json    string
+		want    Zoo
+		wantErr string
+	}{
+		{
+			name: "unknown member in envelope",
+			json: `{"Animals":[{"type":"dog","Bark":"woof"}],"Keeper":"Sam"}`,
+			want: Zoo{Animals: []Animal{&Dog{Bark: "woof"}}},
+		},
+		{
+			name:    "unknown member in choice",
+			json:    `{"Animals":[{"type":"dog","Bark":"woof","Meow":"?"}]}`,
+			wantErr: `.*unknown object member name "Meow" within "/Animals/0"`,
+		},
+		{
+			name:    "unknown member in choice with discriminator last",
+			json:    `{"Animals":[{"Bark":"woof","Meow":"?","type":"dog"}]}`,
+			wantErr: `.*unknown object member name "Meow" within "/Animals/0"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Zoo
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+				[]Option{WithInnerOptions(json.RejectUnknownMembers(true))},
+				nil,
+				(*Dog)(nil),
+				(*Cat)(nil),
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestInnerOptionsPrecedence(t *testing.T) {
+	// The inner options take precedence over the outer ones.
+	var got Animal
+	err := json.Unmarshal([]byte(`{"type":"dog","Bark":"woof","Extra":1}`), &got,
+		json.RejectUnknownMembers(true),
+		json.WithUnmarshalers(StructsWithOptions[Animal](
+			[]Option{WithInnerOptions(json.RejectUnknownMembers(false))},
+			nil,
+			(*Dog)(nil),
+			(*Cat)(nil),
+		)),
+	)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, Animal(&Dog{Bark: "woof"})))
+}
+
+func TestRejectUnknown(t *testing.T) {
+	type Zoo struct {
+		Animals []Animal
+	}
+	tests := []struct {
+		name    string
+		json    string
+		want    Zoo
+		wantErr string
+	}{
+		{
+			name: "known members",
+			json: `{"Animals":[{"type":"dog","Bark":"woof"},{"Neigh":"hee","type":"horse"},{"type":"cat","Meow":"meow"}]}`,
+			want: Zoo{Animals: []Animal{&Dog{Bark: "woof"}, &Horse{Neigh: "hee"}, &Cat{Meow: "meow"}}},
+		},
+		{
+			name: "unknown member in envelope",
+			json: `{"Animals":[],"Keeper":"Sam"}`,
+			want: Zoo{Animals: []Animal{}},
+		},
+		{
+			name:    "unknown member in choice",
+			json:    `{"Animals":[{"type":"dog","Bark":"woof","Meow":"?"}]}`,
+			wantErr: `.*unknown object member name "Meow" within "/Animals/0"`,
+		},
+		{
+			name:    "unknown member in literal choice",
+			json:    `{"Animals":[{"type":"horse","Neigh":"hee","Bark":"?"}]}`,
+			wantErr: `.*unknown object member name "Bark" within "/Animals/0"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Zoo
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+				[]Option{WithRejectUnknown(), WithVariantValue((*Horse)(nil), "horse")},
+				nil,
+				(*Dog)(nil),
+				(*Cat)(nil),
+				(*Horse)(nil),
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+// OffsetDog records the input offset of the decoder it is
+// unmarshaled from, which is zero when the value was buffered.
+type OffsetDog struct {
+	Type stringConst[struct {
+		string `const:"dog"`
+	}] `json:"type"`
+	Offset int64 `json:"-"`
+}
+
+func (*OffsetDog) isAnimal() {}
+
+func (dog *OffsetDog) UnmarshalJSONFrom(d *jsontext.Decoder) error {
+	dog.Offset = d.InputOffset()
+	return d.SkipValue()
+}
+
+func TestInnerOptionsDirect(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []json.Options
+		want []int64
+	}{
+		{"none", nil, []int64{1, 15}},
+		{"semantic", []json.Options{json.RejectUnknownMembers(true)}, []int64{1, 15}},
+		{"syntactic", []json.Options{jsontext.AllowDuplicateNames(false)}, []int64{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Animal
+			err := json.Unmarshal([]byte(`[{"type":"dog"},{"type":"dog"}]`), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+				[]Option{WithInnerOptions(tt.opts...)},
+				nil,
+				(*OffsetDog)(nil),
+				(*Cat)(nil),
+			)))
+			qt.Assert(t, qt.IsNil(err))
+			var offsets []int64
+			for _, a := range got {
+				offsets = append(offsets, a.(*OffsetDog).Offset)
+			}
+			qt.Assert(t, qt.DeepEquals(offsets, tt.want))
+		})
+	}
+}
+
+type DeepDog struct {
+	Kind stringConst[struct {
+		string `const:"dog"`
+	}] `json:"-"`
+	Bark string
+}
+
+func (DeepDog) isAnimal() {}
+
+type DeepCat struct {
+	Kind stringConst[struct {
+		string `const:"cat"`
+	}] `json:"-"`
+	Meow string
+}
+
+func (DeepCat) isAnimal() {}
+
+func TestDiscriminatorPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    Animal
+		wantErr string
+	}{
+		{
+			name: "dog",
+			json: `{"Bark":"woof","a":{"x":1,"b":{"c":{"d":{"e":"dog"}}}}}`,
+			want: &DeepDog{Bark: "woof"},
+		},
+		{
+			name: "cat",
+			json: `{"a":{"b":{"c":{"y":[1,2],"d":{"z":{},"e":"cat"}}}},"Meow":"purr"}`,
+			want: &DeepCat{Meow: "purr"},
+		},
+		{
+			name:    "intermediate missing",
+			json:    `{"a":{"b":{"x":{"d":{"e":"dog"}}}}}`,
+			wantErr: `.*discriminator field "a.b.c" not found`,
+		},
+		{
+			name:    "intermediate is array",
+			json:    `{"a":{"b":[{"c":{"d":{"e":"dog"}}}]}}`,
+			wantErr: `.*discriminator path element "a.b" is array not object`,
+		},
+		{
+			name:    "leaf is missing",
+			json:    `{"a":{"b":{"c":{"d":{}}}}}`,
+			wantErr: `.*discriminator field "a.b.c.d.e" not found`,
+		},
+		{
+			name:    "unknown value",
+			json:    `{"a":{"b":{"c":{"d":{"e":"bird"}}}}}`,
+			wantErr: `.*unknown discriminator value "bird".*`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+				[]Option{WithDiscriminatorPath("a", "b", "c", "d", "e")},
+				nil,
+				(*DeepDog)(nil),
+				(*DeepCat)(nil),
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestDiscriminatorPathWithCompositeKey(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[Animal](
+			[]Option{
+				WithDiscriminatorPath("a", "b"),
+				WithCompositeKey([]string{"a"}, func(v []any) any { return v[0] }),
+			},
+			nil,
+			(*DeepDog)(nil),
+		)
+	}, `WithCompositeKey and WithDiscriminatorPath cannot be used together`))
+}
+
+type Tram struct {
+	VehicleKind stringConst[struct {
+		string `const:"tram"`
+	}]
+	LineNumber int `json:"line_number"`
+}
+
+func (Tram) isVehicle() {}
+
+type Ferry struct {
+	VehicleKind stringConst[struct {
+		string `const:"ferry"`
+	}]
+	Route string `json:"route"`
+}
+
+func (Ferry) isVehicle() {}
+
+func snakeCase(s string) string {
+	var buf strings.Builder
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				buf.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+func TestFieldNameMapper(t *testing.T) {
+	o := applyOptions([]Option{WithFieldNameMapper(snakeCase)})
+	field, _, _, err := discriminator(o, []Vehicle{(*Tram)(nil), (*Ferry)(nil)})
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(field, "vehicle_kind"))
+
+	// Explicit JSON names are not mapped.
+	field, _, _, err = discriminator(o, []Item{(*Book)(nil), (*Movie)(nil)})
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(field, "type"))
+
+	var got Vehicle
+	err = json.Unmarshal([]byte(`{"line_number":5,"vehicle_kind":"tram"}`), &got, json.WithUnmarshalers(StructsWithOptions[Vehicle](
+		[]Option{WithFieldNameMapper(snakeCase)},
+		nil,
+		(*Tram)(nil),
+		(*Ferry)(nil),
+	)))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, Vehicle(&Tram{LineNumber: 5})))
+}
+
+// Horse and Cow have no Const fields, as if they were defined in
+// a package that cannot be changed.
+type Horse struct {
+	Neigh string
+}
+
+func (*Horse) isAnimal() {}
+
+type Cow struct {
+	Moo string
+}
+
+func (*Cow) isAnimal() {}
+
+func TestLiteral(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		choices []Animal
+		json    string
+		want    Animal
+		wantErr string
+	}{
+		{
+			name: "literals only",
+			opts: []Option{
+				WithDiscriminatorField("type"),
+				WithLiteral((*Horse)(nil), Literal("horse")),
+				WithLiteral((*Cow)(nil), Literal("cow")),
+			},
+			json: `{"Moo":"moo","type":"cow"}`,
+			want: &Cow{Moo: "moo"},
+		},
+		{
+			name: "mixed with const choices",
+			opts: []Option{
+				WithLiteral((*Horse)(nil), Literal("horse")),
+			},
+			choices: []Animal{(*Dog)(nil), (*Cat)(nil)},
+			json:    `{"type":"horse","Neigh":"neigh"}`,
+			want:    &Horse{Neigh: "neigh"},
+		},
+		{
+			name: "mixed with const choices selecting const choice",
+			opts: []Option{
+				WithLiteral((*Horse)(nil), Literal("horse")),
+			},
+			choices: []Animal{(*Dog)(nil), (*Cat)(nil)},
+			json:    `{"type":"dog","Bark":"woof"}`,
+			want:    &Dog{Bark: "woof"},
+		},
+		{
+			name: "unknown value",
+			opts: []Option{
+				WithLiteral((*Horse)(nil), Literal("horse")),
+			},
+			choices: []Animal{(*Dog)(nil)},
+			json:    `{"type":"cow"}`,
+			wantErr: `.*unknown discriminator value "cow".*`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](tt.opts, nil, tt.choices...)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestLiteralErrors(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[Animal]([]Option{WithLiteral((*Horse)(nil), Literal("horse"))}, nil)
+	}, `WithDiscriminatorField is required when no choices have Const fields`))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[Animal]([]Option{WithLiteral((*Horse)(nil), Literal("dog"))}, nil, (*Dog)(nil))
+	}, `discriminator value "dog" of \*jsondiscrim.Horse is already used by \*jsondiscrim.Dog`))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[Animal]([]Option{WithLiteral(Horse{}, Literal("horse"))}, nil, (*Dog)(nil))
+	}, `choice jsondiscrim.Horse does not implement jsondiscrim.Animal`))
+}
+
+func TestVariantValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		choices []Animal
+		json    string
+		want    Animal
+		wantErr string
+	}{
+		{
+			name:    "mapped choice",
+			opts:    []Option{WithVariantValue((*Horse)(nil), "horse")},
+			choices: []Animal{(*Dog)(nil), (*Horse)(nil), (*Cat)(nil)},
+			json:    `{"type":"horse","Neigh":"neigh"}`,
+			want:    &Horse{Neigh: "neigh"},
+		},
+		{
+			name:    "annotated choice",
+			opts:    []Option{WithVariantValue((*Horse)(nil), "horse")},
+			choices: []Animal{(*Dog)(nil), (*Horse)(nil), (*Cat)(nil)},
+			json:    `{"type":"cat","Meow":"purr"}`,
+			want:    &Cat{Meow: "purr"},
+		},
+		{
+			name: "mapped choices only",
+			opts: []Option{
+				WithDiscriminatorField("type"),
+				WithVariantValue((*Horse)(nil), "horse"),
+				WithVariantValue((*Cow)(nil), "cow"),
+			},
+			choices: []Animal{(*Horse)(nil), (*Cow)(nil)},
+			json:    `{"Moo":"moo","type":"cow"}`,
+			want:    &Cow{Moo: "moo"},
+		},
+		{
+			name:    "number value",
+			opts:    []Option{WithVariantValue((*Horse)(nil), 3)},
+			choices: []Animal{(*Horse)(nil), (*Dog)(nil)},
+			json:    `{"type":3.0,"Neigh":"neigh"}`,
+			want:    &Horse{Neigh: "neigh"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](tt.opts, nil, tt.choices...)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestVariantValueRejectUnknownMembers(t *testing.T) {
+	unmarshalers := json.WithUnmarshalers(StructsWithOptions[Animal](
+		[]Option{WithVariantValue((*Horse)(nil), "horse")},
+		nil,
+		(*Dog)(nil),
+		(*Horse)(nil),
+	))
+	data := []byte(`[{"type":"horse","Neigh":"neigh"},{"Bark":"woof","type":"dog"},{"Neigh":"hee","type":"horse"}]`)
+	want := []Animal{&Horse{Neigh: "neigh"}, &Dog{Bark: "woof"}, &Horse{Neigh: "hee"}}
+	for _, strict := range []bool{false, true} {
+		var got []Animal
+		err := json.Unmarshal(data, &got, unmarshalers, json.RejectUnknownMembers(strict))
+		qt.Assert(t, qt.IsNil(err), qt.Commentf("strict %v", strict))
+		qt.Assert(t, qt.DeepEquals(got, want))
+	}
+
+	// Other unknown members are still rejected.
+	var got Animal
+	err := json.Unmarshal([]byte(`{"type":"horse","Neigh":"neigh","Moo":"moo"}`), &got, unmarshalers, json.RejectUnknownMembers(true))
+	qt.Assert(t, qt.ErrorMatches(err, `.*unknown object member name "Moo".*`))
+}
+
+func TestVariantValueErrors(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		WithVariantValue(nil, "horse")
+	}, `nil choice provided to WithVariantValue`))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		WithVariantValue((*Horse)(nil), nil)
+	}, `nil value provided to WithVariantValue`))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		WithVariantValue((*Horse)(nil), []string{"horse"})
+	}, `non-comparable value provided to WithVariantValue`))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[Animal]([]Option{WithVariantValue((*Horse)(nil), "dog")}, nil, (*Dog)(nil), (*Horse)(nil))
+	}, `discriminator value "dog" of \*jsondiscrim.Horse is already used by \*jsondiscrim.Dog`))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[Animal]([]Option{WithVariantValue((*Horse)(nil), "horse")}, nil, (*Horse)(nil))
+	}, `WithDiscriminatorField is required when no choices have Const fields`))
+}
+
+func TestTypeNameDiscriminator(t *testing.T) {
+	unmarshalers := json.WithUnmarshalers(StructsWithOptions[Animal](
+		[]Option{WithTypeNameDiscriminator(LowerTypeName)},
+		nil,
+		(*Horse)(nil),
+		(*Cow)(nil),
+		(*Dog)(nil),
+	))
+	for _, test := range []struct {
+		json string
+		want Animal
+	}{
+		{`{"type":"horse","Neigh":"neigh"}`, &Horse{Neigh: "neigh"}},
+		{`{"type":"cow","Moo":"moo"}`, &Cow{Moo: "moo"}},
+		{`{"type":"dog","Bark":"woof"}`, &Dog{Bark: "woof"}},
+	} {
+		var got Animal
+		err := json.Unmarshal([]byte(test.json), &got, unmarshalers)
+		qt.Assert(t, qt.IsNil(err))
+		qt.Assert(t, qt.DeepEquals(got, test.want))
+	}
+}
+
+func TestTypeNameDiscriminatorOnly(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[Animal]([]Option{WithTypeNameDiscriminator(LowerTypeName)}, nil, (*Horse)(nil), (*Cow)(nil))
+	}, `WithDiscriminatorField is required when no choices have Const fields`))
+
+	var got Animal
+	err := json.Unmarshal([]byte(`{"kind":"cow"}`), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+		[]Option{
+			WithTypeNameDiscriminator(LowerTypeName),
+			WithDiscriminatorField("kind"),
+		},
+		nil,
+		(*Horse)(nil),
+		(*Cow)(nil),
+	)))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, Animal(&Cow{})))
+}
+
+func TestLowerTypeName(t *testing.T) {
+	qt.Assert(t, qt.Equals(LowerTypeName(reflect.TypeFor[Horse]()), "horse"))
+	qt.Assert(t, qt.Equals(LowerTypeName(reflect.TypeFor[*Horse]()), "horse"))
+}
+
+func TestBodyField(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		opts    []Option
+		want    Animal
+		wantErr string
+	}{
+		{
+			name: "body after kind",
+			json: `{"type":"dog","data":{"Bark":"woof"}}`,
+			want: &Dog{Bark: "woof"},
+		},
+		{
+			name: "body before kind",
+			json: `{"data":{"Meow":"purr"},"id":1,"type":"cat"}`,
+			want: &Cat{Meow: "purr"},
+		},
+		{
+			name: "envelope fields are not unmarshaled into the body",
+			json: `{"type":"dog","Bark":"outer","data":{}}`,
+			want: &Dog{},
+		},
+		{
+			name:    "missing body",
+			json:    `{"type":"dog"}`,
+			wantErr: `.*body field "data" not found`,
+		},
+		{
+			name: "missing optional body",
+			json: `{"type":"dog"}`,
+			opts: []Option{WithOptionalBody()},
+			want: &Dog{},
+		},
+		{
+			name:    "error within body",
+			json:    `{"type":"dog","data":{"Bark":true}}`,
+			wantErr: `json: (cannot|unable to) unmarshal JSON boolean into Go string within "/data/Bark"`,
+		},
+		{
+			name: "fallback uses envelope",
+			json: `{"type":"bird","data":{"Sing":"tweet"}}`,
+			want: &OtherAnimal{Type: "bird", OtherFields: jsontext.Value(`{"data":{"Sing":"tweet"}}`)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+				append([]Option{WithBodyField("data")}, tt.opts...),
+				(*OtherAnimal)(nil),
+				(*Dog)(nil),
+				(*Cat)(nil),
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestBareStringShorthand(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		fallback Animal
+		opts     []Option
+		want     Animal
+		wantErr  string
+	}{
+		{
+			name: "known value",
+			json: `"dog"`,
+			want: &Dog{},
+		},
+		{
+			name: "object still works",
+			json: `{"type":"cat","Meow":"purr"}`,
+			want: &Cat{Meow: "purr"},
+		},
+		{
+			name:    "unknown value",
+			json:    `"bird"`,
+			wantErr: `.*unknown discriminator value "bird".*`,
+		},
+		{
+			name:     "unknown value with fallback",
+			json:     `"bird"`,
+			fallback: (*OtherAnimal)(nil),
+			want:     &OtherAnimal{Type: "bird"},
+		},
+		{
+			name: "known value with object required",
+			json: `"cat"`,
+			opts: []Option{WithObjectRequired()},
+			want: &Cat{},
+		},
+		{
+			name:    "number is not shorthand",
+			json:    `1`,
+			wantErr: `.*cannot unmarshal JSON number into jsondiscrim.Animal: expected object`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+				append([]Option{WithBareStringShorthand()}, tt.opts...),
+				tt.fallback,
+				(*Dog)(nil),
+				(*Cat)(nil),
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestEmptyAsMissing(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		fallback Animal
+		opts     []Option
+		want     Animal
+		wantErr  string
+	}{
+		{
+			name:    "empty without fallback",
+			json:    `{"type":""}`,
+			wantErr: `.*discriminator field "type" not found`,
+		},
+		{
+			name:     "empty with fallback",
+			json:     `{"type":""}`,
+			fallback: (*OtherAnimal)(nil),
+			want:     &OtherAnimal{},
+		},
+		{
+			name: "empty with matcher",
+			json: `{"type":"","Fins":2}`,
+			opts: []Option{WithMatcher((*LegacyFish)(nil), hasField("Fins"))},
+			want: &LegacyFish{Fins: 2},
+		},
+		{
+			name:    "trimmed to empty",
+			json:    `{"Bark":"woof","type":"  "}`,
+			opts:    []Option{WithTrimDiscriminator()},
+			wantErr: `.*discriminator field "type" not found`,
+		},
+		{
+			name: "non-empty value",
+			json: `{"type":"dog","Bark":"woof"}`,
+			want: &Dog{Bark: "woof"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+				append([]Option{WithEmptyAsMissing()}, tt.opts...),
+				tt.fallback,
+				(*Dog)(nil),
+				(*Cat)(nil),
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				qt.Assert(t, qt.IsTrue(errors.As(err, new(*MissingFieldError))))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestEmptyAsMissingWithoutOption(t *testing.T) {
+	var got Animal
+	err := json.Unmarshal([]byte(`{"type":""}`), &got, json.WithUnmarshalers(Structs[Animal](
+		(*Dog)(nil),
+		(*Cat)(nil),
+	)))
+	qt.Assert(t, qt.ErrorMatches(err, `.*unknown discriminator value "".*`))
+}
+
+func TestEmptyAsMissingEmptyChoice(t *testing.T) {
+	type EmptyAnimal struct {
+		BaseAnimal[struct {
+			string `const:""`
+		}]
+	}
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[any](
+			[]Option{WithEmptyAsMissing()},
+			nil,
+			(*Dog)(nil),
+			(*EmptyAnimal)(nil),
+		)
+	}, `choice \*jsondiscrim.EmptyAnimal has empty discriminator value, which cannot be used with WithEmptyAsMissing`))
+}
+
+// Herd holds animals whose type is declared once for the whole herd,
+// although individual members may still declare their own.
+type Herd struct {
+	Kind    string
+	Members []Animal
+}
+
+func (h *Herd) UnmarshalJSONFrom(d *jsontext.Decoder) error {
+	var raw struct {
+		Kind    string         `json:"kind"`
+		Members jsontext.Value `json:"members"`
+	}
+	if err := json.UnmarshalDecode(d, &raw); err != nil {
+		return err
+	}
+	h.Kind = raw.Kind
+	unmarshalers := StructsWithOptions[Animal](
+		[]Option{WithInheritedDiscriminator(Literal(raw.Kind))},
+		nil,
+		(*Dog)(nil),
+		(*Cat)(nil),
+	)
+	return json.Unmarshal(raw.Members, &h.Members, json.WithUnmarshalers(unmarshalers))
+}
+
+func TestInheritedDiscriminator(t *testing.T) {
+	var got []Herd
+	err := json.Unmarshal([]byte(`[
+		{"kind":"dog","members":[{"Bark":"a"},{"type":"cat","Meow":"b"}]},
+		{"kind":"cat","members":[{"Meow":"c"}]}
+	]`), &got)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, []Herd{{
+		Kind:    "dog",
+		Members: []Animal{&Dog{Bark: "a"}, &Cat{Meow: "b"}},
+	}, {
+		Kind:    "cat",
+		Members: []Animal{&Cat{Meow: "c"}},
+	}}))
+
+	err = json.Unmarshal([]byte(`[{"kind":"bird","members":[{"Wings":2}]}]`), &got)
+	qt.Assert(t, qt.ErrorMatches(err, `.*unknown discriminator value "bird".*`))
+}
+
+func TestDefaultDiscriminator(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		json    string
+		want    []Animal
+		wantErr string
+	}{
+		{
+			name: "missing",
+			json: `[{"Bark":"a"},{"type":"cat","Meow":"b"},{}]`,
+			want: []Animal{&Dog{Bark: "a"}, &Cat{Meow: "b"}, &Dog{}},
+		},
+		{
+			name:    "null",
+			json:    `[{"type":null,"Bark":"a"}]`,
+			wantErr: `.*discriminator field "type" is null`,
+		},
+		{
+			name: "unknown",
+			json: `[{"type":"bird","Wings":2}]`,
+			want: []Animal{&OtherAnimal{Type: "bird", OtherFields: jsontext.Value(`{"Wings":2}`)}},
+		},
+		{
+			name: "empty as missing",
+			opts: []Option{WithEmptyAsMissing()},
+			json: `[{"type":"","Bark":"a"}]`,
+			want: []Animal{&Dog{Bark: "a"}},
+		},
+		{
+			name: "null as missing",
+			opts: []Option{WithNullDiscriminatorAsFallback()},
+			json: `[{"Bark":"a","type":null}]`,
+			want: []Animal{&Dog{Bark: "a"}},
+		},
+		{
+			name: "before missing variant",
+			opts: []Option{WithMissingVariant((*UntypedAnimal)(nil))},
+			json: `[{"Bark":"a"}]`,
+			want: []Animal{&Dog{Bark: "a"}},
+		},
+		{
+			name: "after inherited",
+			opts: []Option{WithInheritedDiscriminator(Literal("cat"))},
+			json: `[{"Meow":"a"}]`,
+			want: []Animal{&Cat{Meow: "a"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+				append([]Option{WithDefaultDiscriminator(Literal("dog"))}, tt.opts...),
+				(*OtherAnimal)(nil),
+				(*Dog)(nil),
+				(*Cat)(nil),
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestDefaultDiscriminatorInvalid(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[Animal]([]Option{WithDefaultDiscriminator(Literal("bird"))}, nil, (*Dog)(nil), (*Cat)(nil))
+	}, `WithDefaultDiscriminator value "bird" is not a discriminator value`))
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[Animal]([]Option{WithDefaultDiscriminator(Literal("dog"))}, (*OtherAnimal)(nil))
+	}, `WithDefaultDiscriminator requires a discriminator field`))
+}
+
+// GQLDog and GQLCat are GraphQL-style results that do not declare the
+// "__typename" member that identifies them.
+type GQLDog struct {
+	Bark string `json:"bark"`
+}
+
+func (*GQLDog) isAnimal() {}
+
+type GQLCat struct {
+	Meow string `json:"meow"`
+}
+
+func (*GQLCat) isAnimal() {}
+
+// GQLBird declares the "__typename" member.
+type GQLBird struct {
+	Typename string `json:"__typename"`
+	Wings    int    `json:"wings"`
+}
+
+func (*GQLBird) isAnimal() {}
+
+func TestTypenameField(t *testing.T) {
+	unmarshalers := json.WithUnmarshalers(StructsWithOptions[Animal](
+		[]Option{
+			WithTypenameField("__typename"),
+			WithTypeNameDiscriminator(func(t reflect.Type) string {
+				return strings.TrimPrefix(t.Elem().Name(), "GQL")
+			}),
+		},
+		nil,
+		(*GQLDog)(nil),
+		(*GQLCat)(nil),
+		(*GQLBird)(nil),
+	))
+	data := []byte(`[
+		{"__typename":"Dog","bark":"woof"},
+		{"meow":"purr","__typename":"Cat"},
+		{"__typename":"Bird","wings":2}
+	]`)
+	want := []Animal{
+		&GQLDog{Bark: "woof"},
+		&GQLCat{Meow: "purr"},
+		&GQLBird{Typename: "Bird", Wings: 2},
+	}
+	for _, strict := range []bool{false, true} {
+		var got []Animal
+		err := json.Unmarshal(data, &got, unmarshalers, json.RejectUnknownMembers(strict))
+		qt.Assert(t, qt.IsNil(err), qt.Commentf("strict %v", strict))
+		qt.Assert(t, qt.DeepEquals(got, want))
+	}
+
+	// Other unknown members are still rejected.
+	var got Animal
+	err := json.Unmarshal([]byte(`{"__typename":"Dog","bark":"woof","tail":true}`), &got, unmarshalers, json.RejectUnknownMembers(true))
+	qt.Assert(t, qt.ErrorMatches(err, `.*unknown object member name "tail".*`))
+}
+
+func TestAcceptsMember(t *testing.T) {
+	qt.Assert(t, qt.IsFalse(acceptsMember(reflect.TypeFor[*GQLDog](), "__typename")))
+	qt.Assert(t, qt.IsTrue(acceptsMember(reflect.TypeFor[*GQLBird](), "__typename")))
+	qt.Assert(t, qt.IsTrue(acceptsMember(reflect.TypeFor[OtherAnimal](), "__typename")))
+	qt.Assert(t, qt.IsTrue(acceptsMember(reflect.TypeFor[*Dog](), "type")))
+	qt.Assert(t, qt.IsFalse(acceptsMember(reflect.TypeFor[*Dog](), "__typename")))
+}
+
+func TestResultForm(t *testing.T) {
+	data := []byte(`[{"type":"dog","Bark":"a"},{"type":"cat","Meow":"b"},{"type":"bird"}]`)
+	tests := []struct {
+		name string
+		opts []Option
+		want []Animal
+	}{
+		{
+			name: "registered",
+			want: []Animal{&Dog{Bark: "a"}, Cat{Meow: "b"}, OtherAnimal{Type: "bird"}},
+		},
+		{
+			name: "values",
+			opts: []Option{WithValueResults()},
+			want: []Animal{Dog{Bark: "a"}, Cat{Meow: "b"}, OtherAnimal{Type: "bird"}},
+		},
+		{
+			name: "pointers",
+			opts: []Option{WithPointerResults()},
+			want: []Animal{&Dog{Bark: "a"}, &Cat{Meow: "b"}, &OtherAnimal{Type: "bird"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Animal
+			err := json.Unmarshal(data, &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+				tt.opts,
+				OtherAnimal{},
+				(*Dog)(nil),
+				Cat{},
+			)))
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestValueResultsNotImplemented(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[Animal](
+			[]Option{
+				WithValueResults(),
+				WithLiteral((*Horse)(nil), Literal("horse")),
+			},
+			nil,
+			(*Dog)(nil),
+		)
+	}, `result type jsondiscrim.Horse does not implement jsondiscrim.Animal`))
+}
+
+func TestEnvelopeSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		fields  []string
+		want    Animal
+		wantErr string
+	}{
+		{
+			name: "known members",
+			json: `{"type":"dog","Bark":"woof"}`,
+			want: &Dog{Bark: "woof"},
+		},
+		{
+			name: "member of another choice",
+			json: `{"type":"dog","Meow":"purr"}`,
+			want: &Dog{},
+		},
+		{
+			name:    "misspelled discriminator",
+			json:    `{"tyep":"dog","Bark":"woof"}`,
+			wantErr: `.*unknown member "tyep" not declared by any choice`,
+		},
+		{
+			name:    "unknown member with known discriminator",
+			json:    `{"type":"cat","Meow":"purr","Tail":true}`,
+			wantErr: `.*unknown member "Tail" not declared by any choice`,
+		},
+		{
+			name:   "explicitly allowed member",
+			json:   `{"type":"cat","Meow":"purr","Tail":true}`,
+			fields: []string{"Tail"},
+			want:   &Cat{Meow: "purr"},
+		},
+		{
+			name: "missing discriminator uses fallback",
+			json: `{"Bark":"woof"}`,
+			want: &OtherAnimal{OtherFields: jsontext.Value(`{"Bark":"woof"}`)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+				[]Option{WithEnvelopeSchema(tt.fields...)},
+				(*OtherAnimal)(nil),
+				(*Dog)(nil),
+				(*Cat)(nil),
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestJSONMembers(t *testing.T) {
+	type Inner struct {
+		C int `json:"c"`
+	}
+	type S struct {
+		A       int `json:"a"`
+		B       int
+		Skipped int `json:"-"`
+		private int
+		In      Inner          `json:",inline"`
+		Rest    jsontext.Value `json:",unknown"`
+	}
+	names, unknown := jsonMembers(reflect.TypeFor[*S]())
+	qt.Assert(t, qt.DeepEquals(names, []string{"a", "B", "c"}))
+	qt.Assert(t, qt.IsTrue(unknown))
+
+	// The embed option of encoding/json/v2 covers both.
+	type S2 struct {
+		A    int            `json:"a"`
+		In   Inner          `json:",embed"`
+		Rest jsontext.Value `json:",embed"`
+	}
+	names, unknown = jsonMembers(reflect.TypeFor[S2]())
+	qt.Assert(t, qt.DeepEquals(names, []string{"a", "c"}))
+	qt.Assert(t, qt.IsTrue(unknown))
+
+	names, unknown = jsonMembers(reflect.TypeFor[*Dog]())
+	qt.Assert(t, qt.DeepEquals(names, []string{"type", "Bark"}))
+	qt.Assert(t, qt.IsFalse(unknown))
+}
+
+// PlainFish holds its discriminator in a plain string field rather
+// than a Const field.
+type PlainFish struct {
+	Type string `json:"type"`
+	Fins int
+}
+
+func (*PlainFish) isAnimal() {}
+
+func TestPlainDiscriminatorField(t *testing.T) {
+	unmarshalers := json.WithUnmarshalers(StructsWithOptions[Animal](
+		[]Option{WithLiteral((*PlainFish)(nil), Literal("fish"))},
+		(*OtherAnimal)(nil),
+		(*Dog)(nil),
+		(*Cat)(nil),
+	))
+	var got []Animal
+	err := json.Unmarshal([]byte(`[
+		{"type":"fish","Fins":2},
+		{"Fins":3,"type":"fish"},
+		{"type":"dog","Bark":"woof"},
+		{"type":"bird"}
+	]`), &got, unmarshalers)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, []Animal{
+		&PlainFish{Type: "fish", Fins: 2},
+		&PlainFish{Type: "fish", Fins: 3},
+		&Dog{Bark: "woof"},
+		&OtherAnimal{Type: "bird"},
+	}))
+
+	// The plain field is not a Const field, so it does not take part
+	// in discriminator detection and must be set to marshal correctly.
+	qt.Assert(t, qt.HasLen(constFields(reflect.TypeFor[*PlainFish]()), 0))
+	data, err := json.Marshal(&PlainFish{Fins: 1})
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(data), `{"type":"","Fins":1}`))
+}
+
+func TestVariantUnmarshaler(t *testing.T) {
+	unmarshalLegacyDog := func(data jsontext.Value, dst any) error {
+		var legacy struct {
+			Sound string `json:"sound"`
+		}
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return err
+		}
+		dst.(*Dog).Bark = strings.ToLower(legacy.Sound)
+		return nil
+	}
+	for _, dog := range []Animal{(*Dog)(nil), Dog{}} {
+		unmarshalers := json.WithUnmarshalers(StructsWithOptions[Animal](
+			[]Option{WithVariantUnmarshaler(Literal("dog"), unmarshalLegacyDog)},
+			nil,
+			dog,
+			(*Cat)(nil),
+		))
+		var got []Animal
+		err := json.Unmarshal([]byte(`[{"type":"dog","sound":"WOOF"},{"type":"cat","Meow":"purr"}]`), &got, unmarshalers)
+		qt.Assert(t, qt.IsNil(err))
+		wantDog := Animal(&Dog{Bark: "woof"})
+		if _, ok := dog.(Dog); ok {
+			wantDog = Dog{Bark: "woof"}
+		}
+		qt.Assert(t, qt.DeepEquals(got, []Animal{wantDog, &Cat{Meow: "purr"}}))
+
+		err = json.Unmarshal([]byte(`[{"type":"dog","sound":1}]`), &got, unmarshalers)
+		qt.Assert(t, qt.ErrorMatches(err, `json: (cannot|unable to) unmarshal JSON number into Go string within "/0/sound"`))
+	}
+}
+
+func TestVariantUnmarshalerUnknownValue(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[Animal](
+			[]Option{WithVariantUnmarshaler(Literal("fish"), nil)},
+			nil,
+			(*Dog)(nil),
+		)
+	}, `WithVariantUnmarshaler value "fish" is not a discriminator value`))
+}
+
+func TestNullDiscriminator(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		fallback Animal
+		opts     []Option
+		want     Animal
+		wantErr  string
+	}{
+		{
+			name:    "null without option",
+			json:    `{"type":null,"Bark":"woof"}`,
+			wantErr: `.*discriminator field "type" is null`,
+		},
+		{
+			name:     "null without option with fallback",
+			json:     `{"Bark":"woof","type":null}`,
+			fallback: (*OtherAnimal)(nil),
+			wantErr:  `.*discriminator field "type" is null`,
+		},
+		{
+			name:     "null as fallback",
+			json:     `{"type":null}`,
+			fallback: (*OtherAnimal)(nil),
+			opts:     []Option{WithNullDiscriminatorAsFallback()},
+			want:     &OtherAnimal{},
+		},
+		{
+			name:    "null as fallback without fallback",
+			json:    `{"type":null}`,
+			opts:    []Option{WithNullDiscriminatorAsFallback()},
+			wantErr: `.*discriminator field "type" not found`,
+		},
+		{
+			name: "null as fallback with matcher",
+			json: `{"type":null,"Fins":2}`,
+			opts: []Option{WithNullDiscriminatorAsFallback(), WithMatcher((*LegacyFish)(nil), hasField("Fins"))},
+			want: &LegacyFish{Fins: 2},
+		},
+		{
+			name:    "null in path",
+			json:    `{"meta":{"kind":null}}`,
+			opts:    []Option{WithDiscriminatorPath("meta", "kind")},
+			wantErr: `.*discriminator field "meta.kind" is null`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+				tt.opts,
+				tt.fallback,
+				(*Dog)(nil),
+				(*Cat)(nil),
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+// AnimalList is an [Animal] represented as a JSON array of names.
+type AnimalList []string
+
+func (AnimalList) isAnimal() {}
+
+// AnimalFlag is an [Animal] represented as a JSON boolean.
+type AnimalFlag bool
+
+func (AnimalFlag) isAnimal() {}
+
+func TestKindDiscriminator(t *testing.T) {
+	kinds := map[jsontext.Kind]reflect.Type{
+		'"': reflect.TypeFor[*ScalarAnimal](),
+		'[': reflect.TypeFor[AnimalList](),
+		't': reflect.TypeFor[AnimalFlag](),
+	}
+	tests := []struct {
+		name     string
+		json     string
+		fallback Animal
+		choices  []Animal
+		want     Animal
+		wantErr  string
+	}{
+		{
+			name:    "string",
+			json:    `"rex"`,
+			choices: []Animal{(*Dog)(nil), (*Cat)(nil)},
+			want:    &ScalarAnimal{Name: "rex"},
+		},
+		{
+			name:    "array",
+			json:    `["rex","tom"]`,
+			choices: []Animal{(*Dog)(nil), (*Cat)(nil)},
+			want:    AnimalList{"rex", "tom"},
+		},
+		{
+			name:    "false uses true",
+			json:    `false`,
+			choices: []Animal{(*Dog)(nil), (*Cat)(nil)},
+			want:    AnimalFlag(false),
+		},
+		{
+			name:    "object uses discriminator",
+			json:    `{"type":"dog","Bark":"woof"}`,
+			choices: []Animal{(*Dog)(nil), (*Cat)(nil)},
+			want:    &Dog{Bark: "woof"},
+		},
+		{
+			name:    "object with unknown discriminator",
+			json:    `{"type":"bird"}`,
+			choices: []Animal{(*Dog)(nil), (*Cat)(nil)},
+			wantErr: `.*unknown discriminator value "bird" .*`,
+		},
+		{
+			name:    "kind not in map",
+			json:    `42`,
+			choices: []Animal{(*Dog)(nil), (*Cat)(nil)},
+			wantErr: `.*cannot unmarshal JSON number into jsondiscrim.Animal: no choice for that kind`,
+		},
+		{
+			name:    "object without choices",
+			json:    `{"type":"dog"}`,
+			wantErr: `.*cannot unmarshal JSON object into jsondiscrim.Animal: no choice for that kind`,
+		},
+		{
+			name:     "kind not in map with fallback",
+			json:     `{"type":"dog"}`,
+			fallback: (*OtherAnimal)(nil),
+			want:     &OtherAnimal{Type: "dog"},
+		},
+		{
+			name:     "kind in map with fallback",
+			json:     `["rex"]`,
+			fallback: (*OtherAnimal)(nil),
+			want:     AnimalList{"rex"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions(
+				[]Option{WithKindDiscriminator(kinds)},
+				tt.fallback,
+				tt.choices...,
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestKindDiscriminatorError(t *testing.T) {
+	var got Animal
+	err := json.Unmarshal([]byte(`[1, 2]`), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+		[]Option{WithKindDiscriminator(map[jsontext.Kind]reflect.Type{
+			'"': reflect.TypeFor[*ScalarAnimal](),
+		})},
+		nil,
+	)))
+	var kindErr *KindError
+	qt.Assert(t, qt.ErrorAs(err, &kindErr))
+	qt.Assert(t, qt.Equals(kindErr.Kind, '['))
+	qt.Assert(t, qt.Equals(kindErr.Type, reflect.TypeFor[Animal]()))
+}
+
+func TestKindDiscriminatorInvalid(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[Animal]([]Option{WithKindDiscriminator(map[jsontext.Kind]reflect.Type{
+			'[': reflect.TypeFor[[]string](),
+		})}, nil)
+	}, `choice \[\]string does not implement jsondiscrim.Animal`))
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[Animal]([]Option{WithKindDiscriminator(map[jsontext.Kind]reflect.Type{
+			'[': nil,
+		})}, nil)
+	}, `WithKindDiscriminator type for JSON array is nil`))
+}
+
+// OldDog is a deprecated [Animal].
+type OldDog struct {
+	Type stringConst[struct {
+		string `const:"olddog"`
+	}] `json:"type" jsondiscrim:"deprecated"`
+	Bark string
+}
+
+func (*OldDog) isAnimal() {}
+
+func TestDeprecationHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		json           string
+		opts           []Option
+		want           Animal
+		wantDeprecated []string
+	}{
+		{
+			name:           "tagged",
+			json:           `[{"type":"olddog","Bark":"woof"},{"type":"dog"},{"Bark":"yap","type":"olddog"}]`,
+			want:           &OldDog{Bark: "woof"},
+			wantDeprecated: []string{"*jsondiscrim.OldDog", "*jsondiscrim.OldDog"},
+		},
+		{
+			name:           "option",
+			json:           `[{"type":"cat"},{"type":"dog"}]`,
+			opts:           []Option{WithDeprecated(Cat{})},
+			want:           &Cat{},
+			wantDeprecated: []string{"*jsondiscrim.Cat"},
+		},
+		{
+			name: "none",
+			json: `[{"type":"dog"}]`,
+			want: &Dog{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deprecated []string
+			opts := append([]Option{WithDeprecationHandler(func(t reflect.Type) {
+				deprecated = append(deprecated, t.String())
+			})}, tt.opts...)
+			var got []Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+				opts,
+				nil,
+				(*Dog)(nil),
+				(*Cat)(nil),
+				(*OldDog)(nil),
+			)))
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got[0], tt.want))
+			qt.Assert(t, qt.DeepEquals(deprecated, tt.wantDeprecated))
+		})
+	}
+}
+
+func TestDeprecatedInvalid(t *testing.T) {
+	type BadTag struct {
+		Type stringConst[struct {
+			string `const:"bad"`
+		}] `json:"type" jsondiscrim:"obsolete"`
+	}
+	qt.Assert(t, qt.DeepEquals(CheckUnion[any](BadTag{}, Cat{}), []Diagnostic{{
+		Severity: SeverityError,
+		Message:  `invalid jsondiscrim tag "obsolete" on field Type of jsondiscrim.BadTag`,
+	}}))
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[Animal]([]Option{WithDeprecated((*Horse)(nil))}, nil, (*Dog)(nil), (*Cat)(nil))
+	}, `WithDeprecated type \*jsondiscrim.Horse is not a choice`))
+}
+
+func TestMaxElements(t *testing.T) {
+	unmarshalers := json.WithUnmarshalers(StructsWithOptions[Animal](
+		[]Option{WithMaxElements(2)},
+		nil,
+		(*Dog)(nil),
+		(*Cat)(nil),
+	))
+	var got []Animal
+	err := json.Unmarshal([]byte(`[{"type":"dog"},{"type":"cat"}]`), &got, unmarshalers)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, []Animal{&Dog{}, &Cat{}}))
+
+	err = json.Unmarshal([]byte(`[{"type":"dog"},{"type":"cat"},{"type":"dog"}]`), &got, unmarshalers)
+	qt.Assert(t, qt.ErrorMatches(err, `.* within "/2": array has more than 2 elements`))
+
+	// The limit applies to each array separately.
+	var nested map[string][]Animal
+	err = json.Unmarshal([]byte(`{"a":[{"type":"dog"},{"type":"dog"}],"b":[{"type":"cat"},{"type":"cat"}]}`), &nested, unmarshalers)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.HasLen(nested["b"], 2))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		WithMaxElements(0)
+	}, `non-positive limit provided to WithMaxElements`))
+}
+
+func TestReadLimitInvalid(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		WithReadLimit(0)
+	}, `non-positive limit provided to WithReadLimit`))
+}
+
+func TestBlockedValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		fallback Animal
+		opts     []Option
+		want     Animal
+		wantErr  string
+	}{
+		{
+			name:     "blocked with fallback",
+			json:     `{"type":"wolf"}`,
+			fallback: (*OtherAnimal)(nil),
+			wantErr:  `.*discriminator value "wolf" is blocked`,
+		},
+		{
+			name:     "blocked not first",
+			json:     `{"Bark":"woof","type":"hyena"}`,
+			fallback: (*OtherAnimal)(nil),
+			wantErr:  `.*discriminator value "hyena" is blocked`,
+		},
+		{
+			name:     "unknown uses fallback",
+			json:     `{"type":"bird"}`,
+			fallback: (*OtherAnimal)(nil),
+			want:     &OtherAnimal{Type: "bird"},
+		},
+		{
+			name:    "blocked without fallback",
+			json:    `{"type":"wolf"}`,
+			wantErr: `.*discriminator value "wolf" is blocked`,
+		},
+		{
+			name: "known",
+			json: `{"type":"dog"}`,
+			want: &Dog{},
+		},
+		{
+			name:     "blocked number",
+			json:     `{"type":7}`,
+			fallback: (*OtherAnimal)(nil),
+			wantErr:  `.*discriminator value 7 is blocked`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+				append([]Option{WithBlockedValues("wolf", "hyena", uint8(7))}, tt.opts...),
+				tt.fallback,
+				(*Dog)(nil),
+				(*Cat)(nil),
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestBlockedValuesInvalid(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[Animal]([]Option{WithBlockedValues("cat")}, nil, (*Dog)(nil), (*Cat)(nil))
+	}, `blocked value "cat" is the discriminator value of \*jsondiscrim.Cat`))
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[Animal]([]Option{WithBlockedValues([]string{"cat"})}, nil, (*Dog)(nil), (*Cat)(nil))
+	}, `blocked value \[\]string{"cat"} is not a scalar`))
+}
+
+func TestGoFieldNameAlias(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		opts    []Option
+		want    Animal
+		wantErr string
+	}{
+		{
+			name: "JSON name",
+			json: `{"type":"dog","Bark":"woof"}`,
+			opts: []Option{WithGoFieldNameAlias()},
+			want: &Dog{Bark: "woof"},
+		},
+		{
+			name: "Go name",
+			json: `{"Bark":"woof","Type":"dog"}`,
+			opts: []Option{WithGoFieldNameAlias()},
+			want: &Dog{Bark: "woof"},
+		},
+		{
+			name: "Go name other choice",
+			json: `{"Type":"cat","Meow":"purr"}`,
+			opts: []Option{WithGoFieldNameAlias()},
+			want: &Cat{Meow: "purr"},
+		},
+		{
+			name: "JSON name takes precedence",
+			json: `{"Type":"dog","type":"cat"}`,
+			opts: []Option{WithGoFieldNameAlias()},
+			want: &Cat{},
+		},
+		{
+			name:    "Go name unknown value",
+			json:    `{"Type":"bird"}`,
+			opts:    []Option{WithGoFieldNameAlias()},
+			wantErr: `.*unknown discriminator value "bird".*`,
+		},
+		{
+			name:    "Go name without option",
+			json:    `{"Type":"dog"}`,
+			wantErr: `.*discriminator field "type" not found`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+				tt.opts,
+				nil,
+				(*Dog)(nil),
+				(*Cat)(nil),
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+type UntypedAnimal struct {
+	Name string
+}
+
+func (*UntypedAnimal) isAnimal() {}
+
+type UnknownAnimal struct {
+	Type string `json:"type"`
+	Name string
+}
+
+func (*UnknownAnimal) isAnimal() {}
+
+func TestMissingAndUnknownVariants(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		fallback Animal
+		opts     []Option
+		want     Animal
+		wantErr  string
+	}{
+		{
+			name: "known",
+			json: `{"type":"dog","Bark":"woof"}`,
+			opts: []Option{WithMissingVariant((*UntypedAnimal)(nil)), WithUnknownVariant((*UnknownAnimal)(nil))},
+			want: &Dog{Bark: "woof"},
+		},
+		{
+			name: "missing",
+			json: `{"Name":"rex"}`,
+			opts: []Option{WithMissingVariant((*UntypedAnimal)(nil)), WithUnknownVariant((*UnknownAnimal)(nil))},
+			want: &UntypedAnimal{Name: "rex"},
+		},
+		{
+			name: "unknown",
+			json: `{"type":"bird","Name":"tweety"}`,
+			opts: []Option{WithMissingVariant((*UntypedAnimal)(nil)), WithUnknownVariant((*UnknownAnimal)(nil))},
+			want: &UnknownAnimal{Type: "bird", Name: "tweety"},
+		},
+		{
+			name: "unknown at end of object",
+			json: `{"Name":"tweety","type":"bird"}`,
+			opts: []Option{WithMissingVariant((*UntypedAnimal)(nil)), WithUnknownVariant((*UnknownAnimal)(nil))},
+			want: &UnknownAnimal{Type: "bird", Name: "tweety"},
+		},
+		{
+			name:    "missing without missing variant",
+			json:    `{"Name":"rex"}`,
+			opts:    []Option{WithUnknownVariant((*UnknownAnimal)(nil))},
+			wantErr: `.*discriminator field "type" not found`,
+		},
+		{
+			name:    "unknown without unknown variant",
+			json:    `{"type":"bird"}`,
+			opts:    []Option{WithMissingVariant((*UntypedAnimal)(nil))},
+			wantErr: `.*unknown discriminator value "bird".*`,
+		},
+		{
+			name:     "missing with fallback",
+			json:     `{"Name":"rex"}`,
+			fallback: (*OtherAnimal)(nil),
+			opts:     []Option{WithUnknownVariant((*UnknownAnimal)(nil))},
+			want:     &OtherAnimal{OtherFields: jsontext.Value(`{"Name":"rex"}`)},
+		},
+		{
+			name:     "unknown with fallback",
+			json:     `{"type":"bird"}`,
+			fallback: (*OtherAnimal)(nil),
+			opts:     []Option{WithMissingVariant((*UntypedAnimal)(nil))},
+			want:     &OtherAnimal{Type: "bird"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+				tt.opts,
+				tt.fallback,
+				(*Dog)(nil),
+				(*Cat)(nil),
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestMissingAndUnknownVariantsInvalid(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		WithMissingVariant(nil)
+	}, `nil choice provided to WithMissingVariant`))
+	qt.Assert(t, qt.PanicMatches(func() {
+		WithUnknownVariant(nil)
+	}, `nil choice provided to WithUnknownVariant`))
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[Animal]([]Option{WithUnknownVariant(UnknownAnimal{})}, nil, (*Dog)(nil))
+	}, `choice jsondiscrim.UnknownAnimal does not implement jsondiscrim.Animal`))
+	qt.Assert(t, qt.PanicMatches(func() {
+		StructsWithOptions[Animal]([]Option{WithMissingVariant((*UntypedAnimal)(nil))}, (*OtherAnimal)(nil))
+	}, `WithMissingVariant and WithUnknownVariant require a discriminator field`))
+}
+
+func TestDuplicateDiscriminator(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    Animal
+		wantErr string
+	}{
+		{
+			name: "consistent",
+			json: `{"type":"dog","type":"dog","Bark":"x"}`,
+			want: &Dog{Bark: "x"},
+		},
+		{
+			name: "consistent apart",
+			json: `{"type":"cat","Meow":"x","type":"cat"}`,
+			want: &Cat{Meow: "x"},
+		},
+		{
+			name:    "conflicting",
+			json:    `{"type":"dog","type":"cat","Bark":"x"}`,
+			wantErr: `.*duplicate field "type" has conflicting values "dog" and "cat"`,
+		},
+		{
+			name:    "conflicting apart",
+			json:    `{"type":"dog","Bark":"x","type":"cat"}`,
+			wantErr: `.*duplicate field "type" has conflicting values "dog" and "cat"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got,
+				jsontext.AllowDuplicateNames(true),
+				json.WithUnmarshalers(Structs[Animal]((*Dog)(nil), (*Cat)(nil))),
+			)
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+
+	// Without AllowDuplicateNames, duplicates are rejected as usual.
+	var got Animal
+	err := json.Unmarshal([]byte(`{"type":"dog","type":"dog"}`), &got, json.WithUnmarshalers(Structs[Animal]((*Dog)(nil), (*Cat)(nil))))
+	qt.Assert(t, qt.ErrorMatches(err, `.*duplicate object member name "type".*`))
+}
+
+func TestStrictDiscriminatorField(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		fallback Animal
+		opts     []Option
+		want     Animal
+		wantErr  string
+	}{
+		{
+			name:    "misspelled without option",
+			json:    `{"tpye":"dog"}`,
+			wantErr: `.*discriminator field "type" not found`,
+		},
+		{
+			name:    "transposed",
+			json:    `{"tpye":"dog"}`,
+			opts:    []Option{WithStrictDiscriminatorField()},
+			wantErr: `.*discriminator field "type" not found but "tpye" is present; did you mean "type"\?`,
+		},
+		{
+			name:    "inserted",
+			json:    `{"Bark":"woof","types":"dog"}`,
+			opts:    []Option{WithStrictDiscriminatorField()},
+			wantErr: `.*discriminator field "type" not found but "types" is present; did you mean "type"\?`,
+		},
+		{
+			name:    "deleted",
+			json:    `{"tpe":"dog"}`,
+			opts:    []Option{WithStrictDiscriminatorField()},
+			wantErr: `.*but "tpe" is present; did you mean "type"\?`,
+		},
+		{
+			name:    "substituted case",
+			json:    `{"Type":"dog"}`,
+			opts:    []Option{WithStrictDiscriminatorField()},
+			wantErr: `.*but "Type" is present; did you mean "type"\?`,
+		},
+		{
+			name:     "misspelled with fallback",
+			json:     `{"typ":"dog"}`,
+			fallback: (*OtherAnimal)(nil),
+			opts:     []Option{WithStrictDiscriminatorField()},
+			wantErr:  `.*but "typ" is present; did you mean "type"\?`,
+		},
+		{
+			name:     "dissimilar with fallback",
+			json:     `{"kind":"dog"}`,
+			fallback: (*OtherAnimal)(nil),
+			opts:     []Option{WithStrictDiscriminatorField()},
+			want:     &OtherAnimal{OtherFields: jsontext.Value(`{"kind":"dog"}`)},
+		},
+		{
+			name:    "two similar",
+			json:    `{"typo":"dog","tye":"dog"}`,
+			opts:    []Option{WithStrictDiscriminatorField()},
+			wantErr: `.*discriminator field "type" not found`,
+		},
+		{
+			name: "present",
+			json: `{"type":"dog","types":"x"}`,
+			opts: []Option{WithStrictDiscriminatorField()},
+			want: &Dog{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+				tt.opts,
+				tt.fallback,
+				(*Dog)(nil),
+				(*Cat)(nil),
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestDiscriminatorFirst(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		fallback Animal
+		want     []Animal
+		wantErr  string
+	}{
+		{
+			name: "first",
+			json: `[{"type":"dog","Bark":"woof"},{ "type" : "cat" }]`,
+			want: []Animal{&Dog{Bark: "woof"}, &Cat{}},
+		},
+		{
+			name:    "not first",
+			json:    `[{"type":"dog"},{"Bark":"woof","type":"dog"}]`,
+			wantErr: `.*discriminator field "type" is not the first member`,
+		},
+		{
+			name:     "not first with fallback",
+			json:     `[{"Bark":"woof","type":"bird"}]`,
+			fallback: (*OtherAnimal)(nil),
+			wantErr:  `.*discriminator field "type" is not the first member`,
+		},
+		{
+			name:     "missing",
+			json:     `[{"Bark":"woof"}]`,
+			fallback: (*OtherAnimal)(nil),
+			want:     []Animal{&OtherAnimal{OtherFields: jsontext.Value(`{"Bark":"woof"}`)}},
+		},
+		{
+			name:    "missing without fallback",
+			json:    `[{"Bark":"woof"}]`,
+			wantErr: `.*discriminator field "type" not found`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+				[]Option{WithDiscriminatorFirst()},
+				tt.fallback,
+				(*Dog)(nil),
+				(*Cat)(nil),
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestDiscriminatorFirstMarshalers(t *testing.T) {
+	// The marshalers always write the discriminator first,
+	// so their output is accepted and read without buffering.
+	choices := []Animal{(*TailDog)(nil), (*OffsetDog)(nil)}
+	data, err := json.Marshal([]Animal{&TailDog{Bark: "woof"}, &OffsetDog{}}, json.WithMarshalers(Marshalers(choices...)))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(data), `[{"type":"taildog","Bark":"woof"},{"type":"dog"}]`))
+
+	var got []Animal
+	err = json.Unmarshal(data, &got, json.WithUnmarshalers(StructsWithOptions[Animal]([]Option{WithDiscriminatorFirst()}, nil, choices...)))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, []Animal{&TailDog{Bark: "woof"}, &OffsetDog{Offset: 33}}))
+}
+
+func TestOneEdit(t *testing.T) {
+	tests := []struct {
+		s1, s2 string
+		want   bool
+	}{
+		{"type", "type", false},
+		{"type", "typ", true},
+		{"type", "ttype", true},
+		{"type", "tyxe", true},
+		{"type", "tpye", true},
+		{"type", "ytpe", true},
+		{"type", "tyep", true},
+		{"type", "kind", false},
+		{"type", "tp", false},
+		{"type", "eptyt", false},
+		{"type", "tépe", true},
+		{"", "a", true},
+		{"ab", "ba", true},
+		{"abc", "cba", false},
+	}
+	for _, tt := range tests {
+		qt.Check(t, qt.Equals(oneEdit(tt.s1, tt.s2), tt.want), qt.Commentf("%q %q", tt.s1, tt.s2))
+		qt.Check(t, qt.Equals(oneEdit(tt.s2, tt.s1), tt.want), qt.Commentf("%q %q", tt.s2, tt.s1))
+	}
+}
+
+func TestDecodeHook(t *testing.T) {
+	unwrap := WithDecodeHook(func(raw jsontext.Value) (jsontext.Value, error) {
+		var w struct {
+			Animal jsontext.Value `json:"animal"`
+		}
+		if err := json.Unmarshal(raw, &w); err != nil || w.Animal == nil {
+			return raw, err
+		}
+		return w.Animal, nil
+	})
+	rename := WithDecodeHook(func(raw jsontext.Value) (jsontext.Value, error) {
+		return renameField(raw, "Type", "type")
+	})
+	tests := []struct {
+		name     string
+		json     string
+		fallback Animal
+		opts     []Option
+		want     Animal
+		wantErr  string
+	}{
+		{
+			name: "wrapped",
+			json: `{"animal":{"type":"dog","Bark":"woof"}}`,
+			opts: []Option{unwrap},
+			want: &Dog{Bark: "woof"},
+		},
+		{
+			name: "unwrapped",
+			json: `{"type":"cat","Meow":"purr"}`,
+			opts: []Option{unwrap},
+			want: &Cat{Meow: "purr"},
+		},
+		{
+			name:    "wrapped without hook",
+			json:    `{"animal":{"type":"dog"}}`,
+			wantErr: `.*discriminator field "type" not found`,
+		},
+		{
+			name:     "wrapped fallback",
+			json:     `{"animal":{"type":"bird"}}`,
+			fallback: (*OtherAnimal)(nil),
+			opts:     []Option{unwrap},
+			want:     &OtherAnimal{Type: "bird"},
+		},
+		{
+			name: "renamed",
+			json: `{"Type":"dog","Bark":"woof"}`,
+			opts: []Option{rename},
+			want: &Dog{Bark: "woof"},
+		},
+		{
+			name: "error",
+			json: `{"type":"dog"}`,
+			opts: []Option{WithDecodeHook(func(raw jsontext.Value) (jsontext.Value, error) {
+				return nil, errors.New("rejected")
+			})},
+			wantErr: `.*rejected`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions[Animal](
+				tt.opts,
+				tt.fallback,
+				(*Dog)(nil),
+				(*Cat)(nil),
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+	qt.Assert(t, qt.PanicMatches(func() {
+		WithDecodeHook(nil)
+	}, `nil function provided to WithDecodeHook`))
+}
+
+func TestEmptyObjectAsNil(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		fallback Animal
+		// fallbackOnly causes there to be no choices
+		// other than the fallback.
+		fallbackOnly bool
+		opts         []Option
+		want         []Animal
+		wantErr      string
+	}{
+		{
+			name:    "without option",
+			json:    `[{}]`,
+			wantErr: `.*discriminator field "type" not found`,
+		},
+		{
+			name: "empty",
+			json: `[{"type":"dog"}, { }, {}]`,
+			opts: []Option{WithEmptyObjectAsNil()},
+			want: []Animal{&Dog{}, nil, nil},
+		},
+		{
+			name:     "empty with fallback",
+			json:     `[{},{"Bark":"woof"}]`,
+			fallback: (*OtherAnimal)(nil),
+			opts:     []Option{WithEmptyObjectAsNil()},
+			want:     []Animal{nil, &OtherAnimal{OtherFields: jsontext.Value(`{"Bark":"woof"}`)}},
+		},
+		{
+			name: "empty with missing variant",
+			json: `[{},{"Name":"rex"}]`,
+			opts: []Option{WithEmptyObjectAsNil(), WithMissingVariant((*UntypedAnimal)(nil))},
+			want: []Animal{nil, &UntypedAnimal{Name: "rex"}},
+		},
+		{
+			name:    "null unchanged",
+			json:    `[null]`,
+			opts:    []Option{WithEmptyObjectAsNil()},
+			wantErr: `.*cannot unmarshal JSON null into jsondiscrim.Animal: expected object`,
+		},
+		{
+			name:         "fallback only",
+			json:         `[{},{"type":"dog"}]`,
+			fallback:     (*OtherAnimal)(nil),
+			fallbackOnly: true,
+			opts:         []Option{WithEmptyObjectAsNil()},
+			want:         []Animal{nil, &OtherAnimal{Type: "dog"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			choices := []Animal{(*Dog)(nil), (*Cat)(nil)}
+			if tt.fallbackOnly {
+				choices = nil
+			}
+			var got []Animal
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsWithOptions(
+				tt.opts,
+				tt.fallback,
+				choices...,
+			)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package jsondiscrim
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+)
+
+// PositionError wraps an unmarshaling error with the line and column
+// in the input at which it occurred.
+type PositionError struct {
+	// Line holds the 1-based line number.
+	Line int
+	// Column holds the 1-based column number, counted in bytes.
+	Column int
+	// Err holds the underlying error.
+	Err error
+}
+
+func (e *PositionError) Error() string {
+	return fmt.Sprintf("%d:%d: %v", e.Line, e.Column, e.Err)
+}
+
+func (e *PositionError) Unwrap() error {
+	return e.Err
+}
+
+// AddPosition returns err wrapped in a [*PositionError] holding the
+// line and column within data of the byte offset recorded in err,
+// which should have resulted from unmarshaling data. If err does not
+// record a byte offset, it is returned unchanged.
+func AddPosition(data []byte, err error) error {
+	offset, ok := errorOffset(err)
+	if !ok {
+		return err
+	}
+	offset = min(offset, int64(len(data)))
+	line := 1 + bytes.Count(data[:offset], []byte("\n"))
+	column := int(offset) - (bytes.LastIndexByte(data[:offset], '\n') + 1) + 1
+	return &PositionError{Line: line, Column: column, Err: err}
+}
+
+// PositionReader wraps an [io.Reader], recording where lines start in
+// the data read through it so that errors from unmarshaling that data
+// can be annotated with line and column information.
+type PositionReader struct {
+	r io.Reader
+	n int64
+	// newlines holds the offsets of all newline characters read.
+	newlines []int64
+}
+
+// NewPositionReader returns a [PositionReader] reading from r.
+func NewPositionReader(r io.Reader) *PositionReader {
+	return &PositionReader{r: r}
+}
+
+// Read implements [io.Reader].
+func (r *PositionReader) Read(buf []byte) (int, error) {
+	n, err := r.r.Read(buf)
+	for i, c := range buf[:n] {
+		if c == '\n' {
+			r.newlines = append(r.newlines, r.n+int64(i))
+		}
+	}
+	r.n += int64(n)
+	return n, err
+}
+
+// AddPosition is like the [AddPosition] function except that it
+// uses the data read so far through r as the input.
+func (r *PositionReader) AddPosition(err error) error {
+	offset, ok := errorOffset(err)
+	if !ok {
+		return err
+	}
+	// The number of newlines before offset gives the line.
+	i, _ := slices.BinarySearch(r.newlines, offset)
+	lineStart := int64(0)
+	if i > 0 {
+		lineStart = r.newlines[i-1] + 1
+	}
+	return &PositionError{Line: i + 1, Column: int(offset-lineStart) + 1, Err: err}
+}
+
+// errorOffset returns the byte offset recorded in err, if any.
+func errorOffset(err error) (int64, bool) {
+	var semErr *json.SemanticError
+	if errors.As(err, &semErr) {
+		return semErr.ByteOffset, true
+	}
+	var synErr *jsontext.SyntacticError
+	if errors.As(err, &synErr) {
+		return synErr.ByteOffset, true
+	}
+	return 0, false
+}
@@ -0,0 +1,49 @@
+package jsondiscrim
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/go-quicktest/qt"
+)
+
+const positionTestData = `{
+	"animals": [
+		{"type": "dog", "Bark": "woof"},
+		{"type": "cat", "Meow": "purr"},
+		{"Meow": 1, "type": "cat"}
+	]
+}
+`
+
+func TestPositionErrorFromReader(t *testing.T) {
+	var got struct {
+		Animals []Animal `json:"animals"`
+	}
+	r := NewPositionReader(iotest.HalfReader(strings.NewReader(positionTestData)))
+	err := json.UnmarshalRead(r, &got, json.WithUnmarshalers(Structs[Animal]((*Dog)(nil), (*Cat)(nil))))
+	err = r.AddPosition(err)
+	qt.Assert(t, qt.ErrorMatches(err, `5:12: json: (cannot|unable to) unmarshal JSON number into Go string within "/animals/2/Meow"`))
+	var posErr *PositionError
+	qt.Assert(t, qt.IsTrue(errors.As(err, &posErr)))
+	qt.Assert(t, qt.Equals(posErr.Line, 5))
+}
+
+func TestPositionErrorFromBytes(t *testing.T) {
+	var got struct {
+		Animals []Animal `json:"animals"`
+	}
+	data := []byte(strings.Replace(positionTestData, `"cat", "Meow": "purr"`, `"cow"`, 1))
+	err := json.Unmarshal(data, &got, json.WithUnmarshalers(Structs[Animal]((*Dog)(nil), (*Cat)(nil))))
+	err = AddPosition(data, err)
+	qt.Assert(t, qt.ErrorMatches(err, `4:3: json: (cannot|unable to) unmarshal into Go \*jsondiscrim.Animal within "/animals/1": unknown discriminator value "cow".*`))
+}
+
+func TestAddPositionWithoutOffset(t *testing.T) {
+	err := errors.New("other")
+	qt.Assert(t, qt.Equals(AddPosition(nil, err), err))
+	qt.Assert(t, qt.Equals(NewPositionReader(strings.NewReader("")).AddPosition(err), err))
+}
@@ -0,0 +1,82 @@
+package jsondiscrim
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+)
+
+// Registry accumulates the choices of a union over the interface type
+// T, so that packages that each define some of the choices can
+// register them independently, typically from their init functions,
+// rather than needing a single place that lists them all. For example:
+//
+//	var Animals jsondiscrim.Registry[Animal]
+//
+//	func init() {
+//		Animals.Register((*Dog)(nil))
+//	}
+//
+// The zero Registry is empty and ready to use. It is safe to use
+// concurrently.
+type Registry[T any] struct {
+	mu        sync.Mutex
+	choices   []T
+	fallbacks []T
+}
+
+// Register adds choice to the choices of the union, following the
+// rules documented in [Structs]. It panics if choice is nil.
+func (r *Registry[T]) Register(choice T) {
+	if isNil(choice) {
+		panic("nil choice provided to Register")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.choices = append(r.choices, choice)
+}
+
+// RegisterFallback sets the fallback choice of the union, following
+// the rules documented in [StructsWithFallback]. It panics if fallback
+// is nil. Without it, the fallback is any registered for T with the
+// [RegisterFallback] function, as for [Structs].
+func (r *Registry[T]) RegisterFallback(fallback T) {
+	if isNil(fallback) {
+		panic("nil fallback provided to RegisterFallback")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallbacks = append(r.fallbacks, fallback)
+}
+
+// Build returns unmarshalers for the union over the choices registered
+// so far, in the order in which they were registered. Choices
+// registered later do not affect the returned unmarshalers.
+//
+// It returns an error if the union is not well formed, including when
+// more than one fallback has been registered.
+func (r *Registry[T]) Build() (*json.Unmarshalers, error) {
+	t := reflect.TypeFor[T]()
+	if t.Kind() != reflect.Interface {
+		return nil, fmt.Errorf("type %v is not an interface type", t)
+	}
+	r.mu.Lock()
+	choices, fallbacks := slices.Clone(r.choices), slices.Clone(r.fallbacks)
+	r.mu.Unlock()
+	fallback := registeredFallback[T]()
+	switch len(fallbacks) {
+	case 0:
+	case 1:
+		fallback = fallbacks[0]
+	default:
+		return nil, fmt.Errorf("more than one fallback registered: %v and %v", reflect.TypeOf(fallbacks[0]), reflect.TypeOf(fallbacks[1]))
+	}
+	u, err := newUnion(&options{}, t, fallback, choices)
+	if err != nil {
+		return nil, err
+	}
+	return unionUnmarshalers[T](u), nil
+}
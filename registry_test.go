@@ -0,0 +1,80 @@
+package jsondiscrim
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/go-quicktest/qt"
+)
+
+func TestRegistry(t *testing.T) {
+	var r Registry[Animal]
+	var wg sync.WaitGroup
+	for _, choice := range []Animal{(*Dog)(nil), (*Cat)(nil)} {
+		wg.Go(func() {
+			r.Register(choice)
+		})
+	}
+	wg.Wait()
+	r.RegisterFallback((*OtherAnimal)(nil))
+	unmarshalers, err := r.Build()
+	qt.Assert(t, qt.IsNil(err))
+
+	// Choices registered after Build are not used.
+	r.Register((*Bird)(nil))
+
+	var got []Animal
+	err = json.Unmarshal([]byte(`[{"type":"dog"},{"type":"cat"},{"type":"bird"}]`), &got, json.WithUnmarshalers(unmarshalers))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, []Animal{&Dog{}, &Cat{}, &OtherAnimal{Type: "bird"}}))
+
+	unmarshalers, err = r.Build()
+	qt.Assert(t, qt.IsNil(err))
+	err = json.Unmarshal([]byte(`[{"type":"bird"}]`), &got, json.WithUnmarshalers(unmarshalers))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, []Animal{&Bird{}}))
+}
+
+func TestRegistryGlobalFallback(t *testing.T) {
+	RegisterFallback[Animal]((*OtherAnimal)(nil))
+	defer ClearFallback[Animal]()
+
+	var r Registry[Animal]
+	r.Register((*Dog)(nil))
+	unmarshalers, err := r.Build()
+	qt.Assert(t, qt.IsNil(err))
+	var got Animal
+	err = json.Unmarshal([]byte(`{"type":"cat"}`), &got, json.WithUnmarshalers(unmarshalers))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, Animal(&OtherAnimal{Type: "cat"})))
+}
+
+func TestRegistryErrors(t *testing.T) {
+	var r Registry[Animal]
+	_, err := r.Build()
+	qt.Assert(t, qt.ErrorMatches(err, `no choices provided to Structs`))
+
+	r.Register((*Dog)(nil))
+	r.Register((*Dog)(nil))
+	_, err = r.Build()
+	qt.Assert(t, qt.ErrorMatches(err, `choices 0 and 1 have the same type \*jsondiscrim.Dog`))
+
+	var r1 Registry[Animal]
+	r1.Register((*Dog)(nil))
+	r1.RegisterFallback((*OtherAnimal)(nil))
+	r1.RegisterFallback(OtherAnimal{})
+	_, err = r1.Build()
+	qt.Assert(t, qt.ErrorMatches(err, `more than one fallback registered: \*jsondiscrim.OtherAnimal and jsondiscrim.OtherAnimal`))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		r.Register(nil)
+	}, `nil choice provided to Register`))
+	qt.Assert(t, qt.PanicMatches(func() {
+		r.RegisterFallback(nil)
+	}, `nil fallback provided to RegisterFallback`))
+
+	var r2 Registry[Dog]
+	_, err = r2.Build()
+	qt.Assert(t, qt.ErrorMatches(err, `type jsondiscrim.Dog is not an interface type`))
+}
@@ -0,0 +1,72 @@
+// Package stdjson adapts the discriminated unions of package
+// jsondiscrim for use with the standard library's encoding/json
+// package, for code that has not yet moved to encoding/json/v2 or
+// github.com/go-json-experiment/json.
+//
+// A union is registered once for its interface type with [Register],
+// after which values of type [Value] holding that interface type are
+// unmarshaled by choosing the concrete type just as the unmarshalers
+// built from the union would. For example:
+//
+//	func init() {
+//		stdjson.Register(jsondiscrim.Union[Animal]{
+//			Choices: []Animal{(*Dog)(nil), (*Cat)(nil)},
+//		})
+//	}
+//
+//	type Zoo struct {
+//		Animals []stdjson.Value[Animal]
+//	}
+//
+// A Zoo can then be unmarshaled with [encoding/json.Unmarshal].
+package stdjson
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/cue-exp/jsondiscrim"
+	"github.com/cue-exp/jsondiscrim/internal/json"
+)
+
+var unmarshalersByType sync.Map // reflect.Type -> *json.Unmarshalers
+
+// Register registers u as the union used by [Value] values holding
+// the interface type T, replacing any union registered for T before.
+// Like [jsondiscrim.Union.Build], it panics if u is not well formed.
+//
+// The registration is global to the program, so it is best done
+// during initialization by the package that defines T.
+func Register[T any](u jsondiscrim.Union[T]) {
+	unmarshalersByType.Store(reflect.TypeFor[T](), u.Build())
+}
+
+// Value holds a value of the interface type T, which must have been
+// registered with [Register] before a Value is unmarshaled.
+type Value[T any] struct {
+	V T
+}
+
+// UnmarshalJSON implements [encoding/json.Unmarshaler]. The concrete
+// type of the value is chosen by the union registered for T, and the
+// value is then unmarshaled with the semantics of encoding/json. As is
+// conventional, unmarshaling null has no effect.
+func (v *Value[T]) UnmarshalJSON(data []byte) error {
+	unmarshalers, ok := unmarshalersByType.Load(reflect.TypeFor[T]())
+	if !ok {
+		return fmt.Errorf("no union registered for %v", reflect.TypeFor[T]())
+	}
+	if string(data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(data, &v.V, json.DefaultOptionsV1(), json.WithUnmarshalers(unmarshalers.(*json.Unmarshalers)))
+}
+
+// MarshalJSON implements [encoding/json.Marshaler]. The value is
+// marshaled with the semantics of encoding/json, so its discriminator
+// member is written by its [jsondiscrim.Const] field as usual. A nil
+// value is marshaled as null.
+func (v Value[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.V, json.DefaultOptionsV1())
+}
@@ -0,0 +1,89 @@
+package stdjson
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"github.com/cue-exp/jsondiscrim"
+)
+
+type Shape interface {
+	isShape()
+}
+
+type Square struct {
+	Kind jsondiscrim.Const[string, struct {
+		string `const:"square"`
+	}] `json:"kind"`
+	Side float64 `json:"side"`
+}
+
+func (*Square) isShape() {}
+
+type Circle struct {
+	Kind jsondiscrim.Const[string, struct {
+		string `const:"circle"`
+	}] `json:"kind"`
+	Radius float64 `json:"radius"`
+}
+
+func (*Circle) isShape() {}
+
+type OtherShape struct {
+	Kind string `json:"kind"`
+}
+
+func (*OtherShape) isShape() {}
+
+type Drawing struct {
+	Title  string         `json:"title"`
+	Shapes []Value[Shape] `json:"shapes"`
+	Main   Value[Shape]   `json:"main"`
+}
+
+func init() {
+	Register(jsondiscrim.Union[Shape]{
+		Choices:  []Shape{(*Square)(nil), (*Circle)(nil)},
+		Fallback: (*OtherShape)(nil),
+	})
+}
+
+func TestValue(t *testing.T) {
+	// Member names are matched without regard to case,
+	// as usual for encoding/json, except for the discriminator.
+	data := `{
+		"title": "shapes",
+		"shapes": [{"kind":"square","Side":2}, {"radius":1,"kind":"circle"}, {"kind":"star"}],
+		"main": null
+	}`
+	var got Drawing
+	err := json.Unmarshal([]byte(data), &got)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, Drawing{
+		Title: "shapes",
+		Shapes: []Value[Shape]{
+			{&Square{Side: 2}},
+			{&Circle{Radius: 1}},
+			{&OtherShape{Kind: "star"}},
+		},
+	}))
+
+	out, err := json.Marshal(got)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(out), `{"title":"shapes","shapes":[{"kind":"square","side":2},{"kind":"circle","radius":1},{"kind":"star"}],"main":null}`))
+}
+
+func TestValueErrors(t *testing.T) {
+	var got Drawing
+	err := json.Unmarshal([]byte(`{"main":"square"}`), &got)
+	qt.Assert(t, qt.ErrorMatches(err, `json: cannot unmarshal string into Go value of type stdjson.OtherShape`))
+
+	err = json.Unmarshal([]byte(`{"main":{"kind":"square","side":"2"}}`), &got)
+	qt.Assert(t, qt.ErrorMatches(err, `.*cannot unmarshal string into Go .* of type float64`))
+
+	var v Value[error]
+	err = json.Unmarshal([]byte(`{}`), &v)
+	qt.Assert(t, qt.ErrorMatches(err, `no union registered for error`))
+}
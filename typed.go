@@ -0,0 +1,62 @@
+package jsondiscrim
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+)
+
+// StructsTyped returns an unmarshaler that unmarshals the given type T
+// (which should be an interface type) by unmarshaling the value of the
+// given discriminator field as type D and calling choose with the
+// result to obtain the choice to unmarshal into. This lets the caller
+// decide the Go type of the discriminator, so that, for example, it
+// can be a named enum type with its own UnmarshalJSONFrom method.
+//
+// The concrete type of the choice returned by choose is used; if it is
+// nil, the value is unknown and fallback is used instead, as it is
+// when the discriminator field is absent or the value is not a JSON
+// object. The fallback may be nil, in which case those are errors.
+//
+// [Const] fields are not used to find the discriminator field or its
+// value, but the chosen type is unmarshaled as usual, so any Const
+// fields it has must accept the JSON.
+func StructsTyped[T any, D comparable](field string, choose func(D) T, fallback T) *json.Unmarshalers {
+	ifaceType := reflect.TypeFor[T]()
+	if ifaceType.Kind() != reflect.Interface {
+		panic(fmt.Errorf("type %v is not an interface type", ifaceType))
+	}
+	return json.UnmarshalFromFunc(func(d *jsontext.Decoder, src *T) error {
+		raw, err := d.ReadValue()
+		if err != nil {
+			return err
+		}
+		start, ptr := d.InputOffset()-int64(len(raw)), d.StackPointer()
+		choice := fallback
+		if k := raw.Kind(); k != '{' {
+			if isNil(fallback) {
+				return &NotObjectError{Type: ifaceType, Kind: k}
+			}
+		} else if fieldRaw, offset, err := fieldRawValue(raw, field); err == nil {
+			var discrimValue D
+			if err := json.Unmarshal(fieldRaw, &discrimValue, d.Options()); err != nil {
+				return relocateError(err, start+offset, ptr.AppendToken(field))
+			}
+			if c := choose(discrimValue); !isNil(c) {
+				choice = c
+			} else if isNil(fallback) {
+				return &UnknownValueError{Field: field, Got: discrimValue}
+			}
+		} else if isNil(fallback) {
+			return err
+		}
+		dst := reflect.New(reflect.TypeOf(choice))
+		if err := json.Unmarshal(raw, dst.Interface(), d.Options()); err != nil {
+			return relocateError(err, start, ptr)
+		}
+		reflect.ValueOf(src).Elem().Set(dst.Elem())
+		return nil
+	})
+}
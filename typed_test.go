@@ -0,0 +1,117 @@
+package jsondiscrim
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/go-quicktest/qt"
+)
+
+type Message interface {
+	isMessage()
+}
+
+type MessageV1 struct {
+	Version Const[int, struct {
+		int `const:"1"`
+	}] `json:"version"`
+	Text string
+}
+
+func (*MessageV1) isMessage() {}
+
+type MessageV2 struct {
+	Version Const[int, struct {
+		int `const:"2"`
+	}] `json:"version"`
+	Lines []string
+}
+
+func (*MessageV2) isMessage() {}
+
+type OtherMessage struct {
+	Version int `json:"version"`
+}
+
+func (*OtherMessage) isMessage() {}
+
+func chooseMessage(version int) Message {
+	switch version {
+	case 1:
+		return (*MessageV1)(nil)
+	case 2:
+		return (*MessageV2)(nil)
+	}
+	return nil
+}
+
+func TestStructsTyped(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		fallback Message
+		want     Message
+		wantErr  string
+	}{
+		{
+			name: "v1",
+			json: `{"version":1,"Text":"hello"}`,
+			want: &MessageV1{Text: "hello"},
+		},
+		{
+			name: "v2",
+			json: `{"Lines":["a","b"],"version":2}`,
+			want: &MessageV2{Lines: []string{"a", "b"}},
+		},
+		{
+			name:    "unknown",
+			json:    `{"version":3}`,
+			wantErr: `.*unknown discriminator value 3`,
+		},
+		{
+			name:     "unknown with fallback",
+			json:     `{"version":3}`,
+			fallback: (*OtherMessage)(nil),
+			want:     &OtherMessage{Version: 3},
+		},
+		{
+			name:    "missing",
+			json:    `{"Text":"hello"}`,
+			wantErr: `.*discriminator field "version" not found`,
+		},
+		{
+			name:    "wrong type",
+			json:    `{"version":"1"}`,
+			wantErr: `json: (cannot|unable to) unmarshal JSON string into Go int within "/version"`,
+		},
+		{
+			name:    "fractional",
+			json:    `{"version":1.5}`,
+			wantErr: `json: (cannot|unable to) unmarshal JSON number 1.5 into Go int within "/version".*`,
+		},
+		{
+			name:    "not object",
+			json:    `1`,
+			wantErr: `.*cannot unmarshal JSON number into jsondiscrim.Message: expected object`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Message
+			err := json.Unmarshal([]byte(tt.json), &got, json.WithUnmarshalers(StructsTyped("version", chooseMessage, tt.fallback)))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestStructsTypedNotObjectError(t *testing.T) {
+	var got Message
+	err := json.Unmarshal([]byte(`[]`), &got, json.WithUnmarshalers(StructsTyped("version", chooseMessage, nil)))
+	qt.Assert(t, qt.IsTrue(errors.As(err, new(*NotObjectError))))
+}
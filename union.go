@@ -0,0 +1,162 @@
+package jsondiscrim
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+)
+
+// Union describes a discriminated union over the interface type T. It
+// is an alternative to calling [StructsWithOptions] directly that can
+// be easier to read for large unions.
+//
+// For example:
+//
+//	jsondiscrim.Union[Animal]{
+//		Choices: []Animal{
+//			(*Dog)(nil),
+//			(*Cat)(nil),
+//		},
+//		Fallback: (*OtherAnimal)(nil),
+//		Options: []jsondiscrim.Option{
+//			jsondiscrim.WithTrimDiscriminator(),
+//		},
+//	}.Build()
+type Union[T any] struct {
+	// Choices holds the choices for the union,
+	// as documented in [Structs].
+	Choices []T
+
+	// Fallback holds the fallback choice, if any,
+	// as documented in [StructsWithFallback].
+	Fallback T
+
+	// Options holds any options for the union.
+	Options []Option
+}
+
+// Build returns the unmarshalers for the union. Like [Structs], it
+// panics if the union is not well formed.
+func (u Union[T]) Build() *json.Unmarshalers {
+	return StructsWithOptions(u.Options, u.Fallback, u.Choices...)
+}
+
+// Merge returns a union holding the choices of both u and other, so
+// that unions defined separately, for example by different packages,
+// can be unmarshaled as one. The options of u come before those of
+// other. A choice given by both is included only once.
+//
+// It returns an error if either union is not well formed, if they use
+// different discriminator fields, if they map the same discriminator
+// value to different types, or if they have different fallbacks.
+func (u Union[T]) Merge(other Union[T]) (Union[T], error) {
+	typ := reflect.TypeFor[T]()
+	if typ.Kind() != reflect.Interface {
+		return Union[T]{}, fmt.Errorf("type %v is not an interface type", typ)
+	}
+	u1, err := newUnion(applyOptions(u.Options), typ, u.Fallback, u.Choices)
+	if err != nil {
+		return Union[T]{}, err
+	}
+	u2, err := newUnion(applyOptions(other.Options), typ, other.Fallback, other.Choices)
+	if err != nil {
+		return Union[T]{}, err
+	}
+	if u1.discrimField != "" && u2.discrimField != "" && u1.discrimField != u2.discrimField {
+		return Union[T]{}, fmt.Errorf("unions have different discriminator fields %q and %q", u1.discrimField, u2.discrimField)
+	}
+	if u1.fallbackType != nil && u2.fallbackType != nil && u1.fallbackType != u2.fallbackType {
+		return Union[T]{}, fmt.Errorf("unions have different fallbacks %v and %v", u1.fallbackType, u2.fallbackType)
+	}
+	// Check the values in a fixed order so that the
+	// error is deterministic.
+	for _, v := range slices.SortedFunc(maps.Keys(u1.discrimByValue), compareValues) {
+		if t2, ok := u2.discrimByValue[v]; ok && t2 != u1.discrimByValue[v] {
+			return Union[T]{}, fmt.Errorf("discriminator value %v is used by %v in one union and %v in the other", v, u1.discrimByValue[v], t2)
+		}
+	}
+	merged := Union[T]{
+		Fallback: u.Fallback,
+		Options:  append(slices.Clip(u.Options), other.Options...),
+	}
+	if isNil(merged.Fallback) {
+		merged.Fallback = other.Fallback
+	}
+	seen := make(map[reflect.Type]bool)
+	for _, choice := range append(slices.Clip(u.Choices), other.Choices...) {
+		if t := reflect.TypeOf(choice); !seen[t] {
+			seen[t] = true
+			merged.Choices = append(merged.Choices, choice)
+		}
+	}
+	if _, err := newUnion(applyOptions(merged.Options), typ, merged.Fallback, merged.Choices); err != nil {
+		return Union[T]{}, err
+	}
+	return merged, nil
+}
+
+// AnyUnion is implemented by [Union] for every type argument, so that
+// unions over different interface types can be given to [TryUnions].
+type AnyUnion interface {
+	unmarshalAny(data []byte) (any, error)
+}
+
+func (u Union[T]) unmarshalAny(data []byte) (any, error) {
+	typ := reflect.TypeFor[T]()
+	if typ.Kind() != reflect.Interface {
+		return nil, fmt.Errorf("type %v is not an interface type", typ)
+	}
+	u1, err := newUnion(applyOptions(u.Options), typ, u.Fallback, u.Choices)
+	if err != nil {
+		return nil, err
+	}
+	var value T
+	if err := json.Unmarshal(data, &value, json.WithUnmarshalers(unionUnmarshalers[T](u1))); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// TryUnions unmarshals data as a value of each of the given unions in
+// turn and returns the value from the first that succeeds, for
+// formats where the union that applies is not known in advance. For
+// example:
+//
+//	v, err := jsondiscrim.TryUnions(data,
+//		jsondiscrim.Union[Animal]{Choices: animals},
+//		jsondiscrim.Union[Vehicle]{Choices: vehicles},
+//	)
+//
+// The unions are independent, so any union that recognizes the
+// discriminator of data wins over later ones, and a union with a
+// fallback, which accepts any object, should come last. A union that
+// recognizes the discriminator but fails to unmarshal the rest of data
+// does not succeed, so later unions are still tried.
+//
+// If no union succeeds, the error joins the errors from all of them,
+// in order.
+func TryUnions(data []byte, unions ...AnyUnion) (any, error) {
+	if len(unions) == 0 {
+		return nil, fmt.Errorf("no unions provided to TryUnions")
+	}
+	var errs []error
+	for i, u := range unions {
+		v, err := u.unmarshalAny(data)
+		if err == nil {
+			return v, nil
+		}
+		errs = append(errs, fmt.Errorf("union %d: %w", i, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// compareValues orders canonical discriminator values by their
+// printed form.
+func compareValues(v1, v2 any) int {
+	return cmp.Compare(fmt.Sprint(v1), fmt.Sprint(v2))
+}
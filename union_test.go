@@ -0,0 +1,170 @@
+package jsondiscrim
+
+import (
+	"testing"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestUnionBuild(t *testing.T) {
+	unmarshalers := Union[Animal]{
+		Choices: []Animal{
+			(*Dog)(nil),
+			(*Cat)(nil),
+		},
+		Fallback: (*OtherAnimal)(nil),
+		Options: []Option{
+			WithTrimDiscriminator(),
+		},
+	}.Build()
+
+	var got []Animal
+	err := json.Unmarshal([]byte(`[{"type":" dog "},{"type":"cat"},{"type":"bird"}]`), &got, json.WithUnmarshalers(unmarshalers))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, []Animal{
+		&Dog{},
+		&Cat{},
+		&OtherAnimal{Type: "bird"},
+	}))
+}
+
+func TestUnionBuildPanics(t *testing.T) {
+	qt.Assert(t, qt.PanicMatches(func() {
+		Union[Animal]{}.Build()
+	}, `no choices provided to Structs`))
+}
+
+func TestUnionMerge(t *testing.T) {
+	pets := Union[Animal]{
+		Choices: []Animal{(*Dog)(nil), (*Cat)(nil)},
+	}
+	legacy := Union[Animal]{
+		Choices:  []Animal{(*OldDog)(nil), (*Cat)(nil)},
+		Fallback: (*OtherAnimal)(nil),
+	}
+	merged, err := pets.Merge(legacy)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.HasLen(merged.Choices, 3))
+
+	var got []Animal
+	err = json.Unmarshal([]byte(`[{"type":"dog"},{"type":"cat"},{"type":"olddog"},{"type":"bird"}]`), &got, json.WithUnmarshalers(merged.Build()))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, []Animal{
+		&Dog{},
+		&Cat{},
+		&OldDog{},
+		&OtherAnimal{Type: "bird"},
+	}))
+}
+
+func TestUnionMergeConflict(t *testing.T) {
+	tests := []struct {
+		name    string
+		u1, u2  Union[any]
+		wantErr string
+	}{{
+		name:    "same value",
+		u1:      Union[any]{Choices: []any{(*Dog)(nil), (*Cat)(nil)}},
+		u2:      Union[any]{Choices: []any{DogV2{}}},
+		wantErr: `discriminator value dog is used by \*jsondiscrim.Dog in one union and jsondiscrim.DogV2 in the other`,
+	}, {
+		name:    "different fields",
+		u1:      Union[any]{Choices: []any{(*Dog)(nil)}},
+		u2:      Union[any]{Choices: []any{(*Tri1)(nil)}, Options: []Option{WithDiscriminatorField("a")}},
+		wantErr: `unions have different discriminator fields "type" and "a"`,
+	}, {
+		name:    "different fallbacks",
+		u1:      Union[any]{Choices: []any{(*Dog)(nil)}, Fallback: (*OtherAnimal)(nil)},
+		u2:      Union[any]{Choices: []any{(*Cat)(nil)}, Fallback: (*ScalarAnimal)(nil)},
+		wantErr: `unions have different fallbacks \*jsondiscrim.OtherAnimal and \*jsondiscrim.ScalarAnimal`,
+	}, {
+		name:    "invalid union",
+		u1:      Union[any]{Choices: []any{(*Dog)(nil)}},
+		u2:      Union[any]{},
+		wantErr: `no choices provided to Structs`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.u1.Merge(tt.u2)
+			qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+		})
+	}
+}
+
+func TestTryUnions(t *testing.T) {
+	animals := Union[Animal]{Choices: []Animal{(*Dog)(nil), (*Cat)(nil)}}
+	vehicles := Union[Vehicle]{Choices: []Vehicle{Car{}, Bike{}}}
+	shapes := Union[Shape]{Choices: []Shape{Square{}, Circle{}}}
+	tests := []struct {
+		name    string
+		json    string
+		unions  []AnyUnion
+		want    any
+		wantErr string
+	}{
+		{
+			name:   "first",
+			json:   `{"type":"dog","Bark":"woof"}`,
+			unions: []AnyUnion{animals, vehicles},
+			want:   &Dog{Bark: "woof"},
+		},
+		{
+			name:   "second",
+			json:   `{"Kind":"bike","Gears":3}`,
+			unions: []AnyUnion{animals, vehicles},
+			want:   Bike{Gears: 3},
+		},
+		{
+			name:   "same field in both",
+			json:   `{"type":"circle","schemaVersion":"1","radius":2}`,
+			unions: []AnyUnion{animals, shapes},
+			want:   Circle{Radius: 2},
+		},
+		{
+			name: "fallback wins",
+			json: `{"Kind":"car"}`,
+			unions: []AnyUnion{
+				Union[Animal]{Choices: []Animal{(*Dog)(nil)}, Fallback: (*OtherAnimal)(nil)},
+				vehicles,
+			},
+			want: &OtherAnimal{OtherFields: jsontext.Value(`{"Kind":"car"}`)},
+		},
+		{
+			name:    "recognized but invalid",
+			json:    `{"type":"cat","Meow":1}`,
+			unions:  []AnyUnion{animals, vehicles},
+			wantErr: `union 0: .*unmarshal JSON number into Go string.*\nunion 1: .*discriminator field "Kind" not found`,
+		},
+		{
+			name:    "none",
+			json:    `{"type":"bird"}`,
+			unions:  []AnyUnion{animals, shapes},
+			wantErr: `union 0: .*unknown discriminator value "bird".*\nunion 1: .*unknown discriminator value "bird".*`,
+		},
+		{
+			name:   "invalid union",
+			json:   `{"type":"dog"}`,
+			unions: []AnyUnion{Union[Animal]{}, animals},
+			want:   &Dog{},
+		},
+		{
+			name:    "no unions",
+			json:    `{"type":"dog"}`,
+			wantErr: `no unions provided to TryUnions`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TryUnions([]byte(tt.json), tt.unions...)
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				qt.Assert(t, qt.IsNil(got))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
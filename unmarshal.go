@@ -0,0 +1,195 @@
+package jsondiscrim
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+)
+
+// UnmarshalWithInfo unmarshals data as a single value of the union
+// described by fallback and choices, following the rules documented in
+// [StructsWithFallback]. It also reports whether one of the choices
+// matched, as opposed to the fallback being used.
+func UnmarshalWithInfo[T any](data []byte, fallback T, choices ...T) (value T, matched bool, err error) {
+	t := reflect.TypeFor[T]()
+	if t.Kind() != reflect.Interface {
+		return value, false, fmt.Errorf("type %v is not an interface type", t)
+	}
+	u, err := newUnion(&options{}, t, fallback, choices)
+	if err != nil {
+		return value, false, err
+	}
+	err = json.Unmarshal(data, &value, json.WithUnmarshalers(json.UnmarshalFromFunc(func(d *jsontext.Decoder, dst *T) error {
+		v, err := u.unmarshal(d)
+		if err != nil {
+			return err
+		}
+		matched = v.Type() != u.fallbackType
+		reflect.ValueOf(dst).Elem().Set(v)
+		return nil
+	})))
+	if err != nil {
+		return *new(T), false, err
+	}
+	return value, matched, nil
+}
+
+// UnmarshalWithDiscriminator unmarshals data as a single value of the
+// union described by choices, following the rules documented in
+// [Structs]. It also returns the discriminator value that selected the
+// choice, in the canonical form described for [DiscriminatorValue], so
+// that callers such as logging or routing layers need not extract it
+// again.
+func UnmarshalWithDiscriminator[T any](data []byte, choices ...T) (value T, discrim any, err error) {
+	t := reflect.TypeFor[T]()
+	if t.Kind() != reflect.Interface {
+		return value, nil, fmt.Errorf("type %v is not an interface type", t)
+	}
+	u, err := newUnion(&options{}, t, registeredFallback[T](), choices)
+	if err != nil {
+		return value, nil, err
+	}
+	err = json.Unmarshal(data, &value, json.WithUnmarshalers(json.UnmarshalFromFunc(func(d *jsontext.Decoder, dst *T) error {
+		// Values of the union may be nested within the choices,
+		// so only record the discriminator of the outermost one.
+		depth := d.StackDepth()
+		var vdiscrim any
+		v, err := u.unmarshalDiscrim(d, &vdiscrim)
+		if err != nil {
+			return err
+		}
+		if depth == 0 {
+			discrim = vdiscrim
+		}
+		reflect.ValueOf(dst).Elem().Set(v)
+		return nil
+	})))
+	if err != nil {
+		return *new(T), nil, err
+	}
+	return value, discrim, nil
+}
+
+// UnmarshalSlice unmarshals data, which must be a JSON array or null,
+// as a slice of values of the union described by choices, following the
+// rules documented in [Structs]. It is the counterpart of
+// [MarshalSlice].
+func UnmarshalSlice[T any](data []byte, choices ...T) ([]T, error) {
+	t := reflect.TypeFor[T]()
+	if t.Kind() != reflect.Interface {
+		return nil, fmt.Errorf("type %v is not an interface type", t)
+	}
+	u, err := newUnion(&options{}, t, registeredFallback[T](), choices)
+	if err != nil {
+		return nil, err
+	}
+	var values []T
+	if err := json.Unmarshal(data, &values, json.WithUnmarshalers(unionUnmarshalers[T](u))); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// UnmarshalDecodeEach reads a JSON array from dec and unmarshals each
+// of its elements as a value of the union described by choices,
+// following the rules documented in [Structs]. It calls fn with each
+// value in turn, so that large arrays can be processed without holding
+// all their elements at once. A JSON null is treated as an empty
+// array.
+//
+// The elements are unmarshaled with the options of dec followed by
+// opts, so the caller can supply options such as
+// [json.RejectUnknownMembers] in either place. Any unmarshalers among
+// them are used for values other than those of type T.
+//
+// It stops at the first error, whether from reading an element or
+// returned by fn, and returns it along with the index of the failing
+// element. Otherwise dec is left positioned after the array.
+func UnmarshalDecodeEach[T any](dec *jsontext.Decoder, choices []T, fn func(T) error, opts ...json.Options) error {
+	t := reflect.TypeFor[T]()
+	if t.Kind() != reflect.Interface {
+		return fmt.Errorf("type %v is not an interface type", t)
+	}
+	u, err := newUnion(&options{}, t, registeredFallback[T](), choices)
+	if err != nil {
+		return err
+	}
+	unmarshalers := unionUnmarshalers[T](u)
+	if other, ok := json.GetOption(json.JoinOptions(append([]json.Options{dec.Options()}, opts...)...), json.WithUnmarshalers); ok {
+		unmarshalers = json.JoinUnmarshalers(unmarshalers, other)
+	}
+	opts = append(slices.Clip(opts), json.WithUnmarshalers(unmarshalers))
+	tok, err := dec.ReadToken()
+	if err != nil {
+		return err
+	}
+	switch tok.Kind() {
+	case 'n':
+		return nil
+	case '[':
+	default:
+		return fmt.Errorf("expected array, got %v", tok.Kind())
+	}
+	for i := 0; dec.PeekKind() != ']'; i++ {
+		var value T
+		if err := json.UnmarshalDecode(dec, &value, opts...); err != nil {
+			return fmt.Errorf("element %d: %w", i, err)
+		}
+		if err := fn(value); err != nil {
+			return fmt.Errorf("element %d: %w", i, err)
+		}
+	}
+	_, err = dec.ReadToken()
+	return err
+}
+
+// UnmarshalStream reads successive top-level JSON values, separated
+// by optional white space as in newline-delimited JSON, from r and
+// unmarshals each as a value of the union described by choices,
+// following the rules documented in [Structs]. It calls fn with each
+// value in turn.
+//
+// It returns nil when r is exhausted. Otherwise it stops at the first
+// error, whether from reading a value or returned by fn, and returns
+// it along with the index of the failing value.
+//
+// The options modify the union as for [StructsWithOptions]. With
+// [WithMaxElements], it is an error for r to hold more values than
+// the limit, and with [WithReadLimit], it is an error for it to hold
+// more bytes.
+func UnmarshalStream[T any](r io.Reader, choices []T, fn func(T) error, opts ...Option) error {
+	t := reflect.TypeFor[T]()
+	if t.Kind() != reflect.Interface {
+		return fmt.Errorf("type %v is not an interface type", t)
+	}
+	o := applyOptions(opts)
+	u, err := newUnion(o, t, registeredFallback[T](), choices)
+	if err != nil {
+		return err
+	}
+	unmarshalers := json.WithUnmarshalers(unionUnmarshalers[T](u))
+	if o.readLimit > 0 {
+		r = LimitReader(r, o.readLimit)
+	}
+	d := jsontext.NewDecoder(r)
+	for i := 0; ; i++ {
+		if o.maxElements > 0 && i == o.maxElements && d.PeekKind() != 0 {
+			return fmt.Errorf("value %d: stream has more than %d values", i, o.maxElements)
+		}
+		var value T
+		if err := json.UnmarshalDecode(d, &value, unmarshalers); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("value %d: %w", i, err)
+		}
+		if err := fn(value); err != nil {
+			return fmt.Errorf("value %d: %w", i, err)
+		}
+	}
+}
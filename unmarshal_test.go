@@ -0,0 +1,381 @@
+package jsondiscrim
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+	"github.com/go-quicktest/qt"
+)
+
+func TestUnmarshalWithInfo(t *testing.T) {
+	tests := []struct {
+		name        string
+		json        string
+		want        Animal
+		wantMatched bool
+		wantErr     string
+	}{
+		{
+			name:        "choice",
+			json:        `{"type":"cat","Meow":"purr"}`,
+			want:        &Cat{Meow: "purr"},
+			wantMatched: true,
+		},
+		{
+			name: "fallback",
+			json: `{"type":"bird","Sing":"tweet"}`,
+			want: &OtherAnimal{Type: "bird", OtherFields: jsontext.Value(`{"Sing":"tweet"}`)},
+		},
+		{
+			name:    "error",
+			json:    `{"type":"cat","Meow":1}`,
+			wantErr: `json: (cannot|unable to) unmarshal JSON number into Go string within "/Meow"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, matched, err := UnmarshalWithInfo[Animal]([]byte(tt.json), (*OtherAnimal)(nil), (*Dog)(nil), (*Cat)(nil))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				qt.Assert(t, qt.IsNil(got))
+				qt.Assert(t, qt.IsFalse(matched))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+			qt.Assert(t, qt.Equals(matched, tt.wantMatched))
+		})
+	}
+}
+
+func TestUnmarshalWithInfoInvalidChoices(t *testing.T) {
+	_, _, err := UnmarshalWithInfo[Animal]([]byte(`{}`), nil)
+	qt.Assert(t, qt.ErrorMatches(err, `no choices provided to Structs`))
+}
+
+func TestUnmarshalWithDiscriminator(t *testing.T) {
+	long := strings.Repeat("x", maxPeek)
+	tests := []struct {
+		name        string
+		json        string
+		want        Animal
+		wantDiscrim any
+		wantErr     string
+	}{
+		{
+			name:        "discriminator first",
+			json:        `{"type":"cat","Meow":"purr"}`,
+			want:        &Cat{Meow: "purr"},
+			wantDiscrim: "cat",
+		},
+		{
+			name:        "discriminator after long member",
+			json:        `{"Bark":"` + long + `","type":"dog"}`,
+			want:        &Dog{Bark: long},
+			wantDiscrim: "dog",
+		},
+		{
+			name:    "unknown",
+			json:    `{"type":"bird"}`,
+			wantErr: `.*unknown discriminator value "bird".*`,
+		},
+		{
+			name:    "missing",
+			json:    `{"Meow":"purr"}`,
+			wantErr: `.*discriminator field "type" not found`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, discrim, err := UnmarshalWithDiscriminator[Animal]([]byte(tt.json), (*Dog)(nil), (*Cat)(nil))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				qt.Assert(t, qt.IsNil(got))
+				qt.Assert(t, qt.IsNil(discrim))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+			qt.Assert(t, qt.Equals(discrim, tt.wantDiscrim))
+		})
+	}
+}
+
+// Expr is a recursive union: its choices hold values of the union.
+type Expr interface {
+	isExpr()
+}
+
+type ExprA struct {
+	Kind stringConst[struct {
+		string `const:"a"`
+	}] `json:"kind"`
+	Child Expr `json:"child,omitempty"`
+}
+
+func (*ExprA) isExpr() {}
+
+type ExprB struct {
+	Kind stringConst[struct {
+		string `const:"b"`
+	}] `json:"kind"`
+	Child Expr `json:"child,omitempty"`
+}
+
+func (*ExprB) isExpr() {}
+
+func TestUnmarshalWithDiscriminatorRecursive(t *testing.T) {
+	for _, choices := range [][]Expr{
+		{(*ExprA)(nil), (*ExprB)(nil)},
+		{(*ExprB)(nil), (*ExprA)(nil)},
+	} {
+		for _, data := range []string{
+			`{"kind":"a","child":{"kind":"b"}}`,
+			`{"child":{"kind":"b"},"kind":"a"}`,
+		} {
+			got, discrim, err := UnmarshalWithDiscriminator([]byte(data), choices...)
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(got, Expr(&ExprA{Child: &ExprB{}})))
+			qt.Assert(t, qt.Equals(discrim, any("a")))
+		}
+	}
+}
+
+func TestUnmarshalWithDiscriminatorRegisteredFallback(t *testing.T) {
+	RegisterFallback[Animal]((*OtherAnimal)(nil))
+	defer ClearFallback[Animal]()
+	got, discrim, err := UnmarshalWithDiscriminator[Animal]([]byte(`{"type":"bird"}`), (*Dog)(nil), (*Cat)(nil))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, Animal(&OtherAnimal{Type: "bird"})))
+	qt.Assert(t, qt.Equals(discrim, any("bird")))
+}
+
+func TestUnmarshalWithDiscriminatorInvalidChoices(t *testing.T) {
+	_, _, err := UnmarshalWithDiscriminator[Animal]([]byte(`{}`))
+	qt.Assert(t, qt.ErrorMatches(err, `no choices provided to Structs`))
+}
+
+func TestUnmarshalStream(t *testing.T) {
+	choices := []Animal{(*Dog)(nil), (*Cat)(nil)}
+	tests := []struct {
+		name    string
+		json    string
+		opts    []Option
+		want    []Animal
+		wantErr string
+	}{
+		{
+			name: "newline delimited",
+			json: "{\"type\":\"dog\",\"Bark\":\"a\"}\n{\"type\":\"cat\",\"Meow\":\"b\"}\n",
+			want: []Animal{&Dog{Bark: "a"}, &Cat{Meow: "b"}},
+		},
+		{
+			name: "concatenated",
+			json: `{"type":"cat"}{"type":"dog"}  {"type":"cat"}`,
+			want: []Animal{&Cat{}, &Dog{}, &Cat{}},
+		},
+		{
+			name: "empty",
+			json: " \n",
+		},
+		{
+			name:    "unknown value",
+			json:    `{"type":"dog"} {"type":"bird"} {"type":"cat"}`,
+			want:    []Animal{&Dog{}},
+			wantErr: `value 1: .*unknown discriminator value "bird".*`,
+		},
+		{
+			name:    "truncated",
+			json:    `{"type":"dog"} {"type":`,
+			want:    []Animal{&Dog{}},
+			wantErr: `value 1: .*unexpected EOF.*`,
+		},
+		{
+			name:    "not object",
+			json:    `{"type":"dog"} [1]`,
+			want:    []Animal{&Dog{}},
+			wantErr: `value 1: .*expected object`,
+		},
+		{
+			name: "at limit",
+			json: `{"type":"dog"} {"type":"cat"} `,
+			opts: []Option{WithMaxElements(2)},
+			want: []Animal{&Dog{}, &Cat{}},
+		},
+		{
+			name:    "over limit",
+			json:    `{"type":"dog"} {"type":"cat"} {"type":"dog"}`,
+			opts:    []Option{WithMaxElements(2)},
+			want:    []Animal{&Dog{}, &Cat{}},
+			wantErr: `value 2: stream has more than 2 values`,
+		},
+		{
+			name:    "over read limit",
+			json:    `{"type":"dog"} {"type":"cat"} {"type":"dog"}`,
+			opts:    []Option{WithReadLimit(20)},
+			want:    []Animal{&Dog{}},
+			wantErr: `value 1: .*read limit of 20 bytes exceeded`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Animal
+			err := UnmarshalStream(strings.NewReader(tt.json), choices, func(a Animal) error {
+				got = append(got, a)
+				return nil
+			}, tt.opts...)
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+			} else {
+				qt.Assert(t, qt.IsNil(err))
+			}
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+		})
+	}
+}
+
+func TestUnmarshalStreamCallbackError(t *testing.T) {
+	errStop := errors.New("stop")
+	n := 0
+	err := UnmarshalStream(strings.NewReader(`{"type":"dog"} {"type":"cat"} {"type":"dog"}`), []Animal{(*Dog)(nil), (*Cat)(nil)}, func(a Animal) error {
+		n++
+		if _, ok := a.(*Cat); ok {
+			return errStop
+		}
+		return nil
+	})
+	qt.Assert(t, qt.ErrorIs(err, errStop))
+	qt.Assert(t, qt.ErrorMatches(err, `value 1: stop`))
+	qt.Assert(t, qt.Equals(n, 2))
+}
+
+func TestUnmarshalStreamRegisteredFallback(t *testing.T) {
+	RegisterFallback[Animal]((*OtherAnimal)(nil))
+	defer ClearFallback[Animal]()
+	var got []Animal
+	err := UnmarshalStream(strings.NewReader(`{"type":"dog"} {"type":"bird"}`), []Animal{(*Dog)(nil), (*Cat)(nil)}, func(a Animal) error {
+		got = append(got, a)
+		return nil
+	})
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, []Animal{&Dog{}, &OtherAnimal{Type: "bird"}}))
+}
+
+func TestUnmarshalDecodeEach(t *testing.T) {
+	choices := []Animal{(*Dog)(nil), (*Cat)(nil)}
+	upper := json.WithUnmarshalers(json.UnmarshalFromFunc(func(d *jsontext.Decoder, s *string) error {
+		tok, err := d.ReadToken()
+		if err != nil {
+			return err
+		}
+		*s = strings.ToUpper(tok.String())
+		return nil
+	}))
+	tests := []struct {
+		name     string
+		json     string
+		decOpts  []jsontext.Options
+		opts     []json.Options
+		want     []Animal
+		wantErr  string
+		wantRest string
+	}{
+		{
+			name:     "array",
+			json:     `[{"type":"dog","Bark":"a"}, {"Meow":"b","type":"cat"}] 42`,
+			want:     []Animal{&Dog{Bark: "a"}, &Cat{Meow: "b"}},
+			wantRest: `42`,
+		},
+		{
+			name: "empty",
+			json: `[]`,
+		},
+		{
+			name: "null",
+			json: `null`,
+		},
+		{
+			name:    "not array",
+			json:    `{"type":"dog"}`,
+			wantErr: `expected array, got {`,
+		},
+		{
+			name:    "strict decoder",
+			json:    `[{"type":"dog"},{"type":"cat","Tail":true}]`,
+			decOpts: []jsontext.Options{json.RejectUnknownMembers(true)},
+			want:    []Animal{&Dog{}},
+			wantErr: `element 1: .*unknown object member name "Tail".*`,
+		},
+		{
+			name:    "strict decoder relaxed by options",
+			json:    `[{"type":"dog"},{"type":"cat","Tail":true}]`,
+			decOpts: []jsontext.Options{json.RejectUnknownMembers(true)},
+			opts:    []json.Options{json.RejectUnknownMembers(false)},
+			want:    []Animal{&Dog{}, &Cat{}},
+		},
+		{
+			name:    "decoder unmarshalers",
+			json:    `[{"type":"dog","Bark":"woof"}]`,
+			decOpts: []jsontext.Options{upper},
+			want:    []Animal{&Dog{Bark: "WOOF"}},
+		},
+		{
+			name: "unmarshalers in options",
+			json: `[{"Bark":"woof","type":"dog"}]`,
+			opts: []json.Options{upper},
+			want: []Animal{&Dog{Bark: "WOOF"}},
+		},
+		{
+			name:    "unknown value",
+			json:    `[{"type":"dog"},{"type":"bird"}]`,
+			want:    []Animal{&Dog{}},
+			wantErr: `element 1: .*unknown discriminator value "bird".*`,
+		},
+		{
+			name:    "truncated",
+			json:    `[{"type":"dog"},`,
+			want:    []Animal{&Dog{}},
+			wantErr: `element 1: .*unexpected EOF.*`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec := jsontext.NewDecoder(strings.NewReader(tt.json), tt.decOpts...)
+			var got []Animal
+			err := UnmarshalDecodeEach(dec, choices, func(a Animal) error {
+				got = append(got, a)
+				return nil
+			}, tt.opts...)
+			qt.Assert(t, qt.DeepEquals(got, tt.want))
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+			if tt.wantRest != "" {
+				rest, err := dec.ReadValue()
+				qt.Assert(t, qt.IsNil(err))
+				qt.Assert(t, qt.Equals(string(rest), tt.wantRest))
+			}
+		})
+	}
+}
+
+func TestUnmarshalDecodeEachCallbackError(t *testing.T) {
+	errStop := errors.New("stop")
+	dec := jsontext.NewDecoder(strings.NewReader(`[{"type":"dog"},{"type":"cat"},{"type":"dog"}]`))
+	n := 0
+	err := UnmarshalDecodeEach(dec, []Animal{(*Dog)(nil), (*Cat)(nil)}, func(a Animal) error {
+		n++
+		if _, ok := a.(*Cat); ok {
+			return errStop
+		}
+		return nil
+	})
+	qt.Assert(t, qt.ErrorIs(err, errStop))
+	qt.Assert(t, qt.ErrorMatches(err, `element 1: stop`))
+	qt.Assert(t, qt.Equals(n, 2))
+}
@@ -0,0 +1,134 @@
+package jsondiscrim
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+)
+
+var variantsByKey sync.Map // reflect.Type -> *union
+
+// RegisterVariants registers the choices of u as those of [Variant]
+// values with the key type K. The choices need not share any methods,
+// which allows unions of types that cannot implement a common
+// interface. Registering again for K replaces the previous choices.
+// Like [Structs], it panics if u is not well formed.
+//
+// The registration is global to the program, so it is best done during
+// initialization by the package that defines K.
+func RegisterVariants[K any](u Union[any]) {
+	un, err := newUnion(applyOptions(u.Options), reflect.TypeFor[any](), u.Fallback, u.Choices)
+	if err != nil {
+		panic(err)
+	}
+	variantsByKey.Store(reflect.TypeFor[K](), un)
+}
+
+// Variant holds a value of one of the choices registered for the key
+// type K with [RegisterVariants], along with the discriminator value
+// that selected it. K is used only to identify the choices, so it is
+// typically an empty struct type. For example:
+//
+//	type shape struct{}
+//
+//	func init() {
+//		jsondiscrim.RegisterVariants[shape](jsondiscrim.Union[any]{
+//			Choices: []any{Circle{}, Square{}},
+//		})
+//	}
+//
+//	type Drawing struct {
+//		Shapes []jsondiscrim.Variant[shape] `json:"shapes"`
+//	}
+//
+// The zero Variant holds no value, as does one unmarshaled from null.
+type Variant[K any] struct {
+	value   any
+	discrim any
+}
+
+// UnmarshalJSONFrom implements [json.UnmarshalerFrom] by unmarshaling
+// the next value from d into the registered choice that it selects.
+func (v *Variant[K]) UnmarshalJSONFrom(d *jsontext.Decoder) error {
+	un, ok := variantsByKey.Load(reflect.TypeFor[K]())
+	if !ok {
+		return fmt.Errorf("no variants registered for %v", reflect.TypeFor[K]())
+	}
+	u := un.(*union)
+	raw, err := d.ReadValue()
+	if err != nil {
+		return err
+	}
+	if raw.Kind() == 'n' {
+		*v = Variant[K]{}
+		return nil
+	}
+	start, ptr := d.InputOffset()-int64(len(raw)), d.StackPointer()
+	var discrim any
+	value, err := u.unmarshalDiscrim(jsontext.NewDecoder(bytes.NewReader(raw), d.Options()), &discrim)
+	if err != nil {
+		return relocateError(err, start, ptr)
+	}
+	*v = Variant[K]{value: value.Interface(), discrim: discrim}
+	return nil
+}
+
+// MarshalJSONTo implements [json.MarshalerTo] by marshaling the value
+// held by v, or null if there is none.
+func (v Variant[K]) MarshalJSONTo(e *jsontext.Encoder) error {
+	return json.MarshalEncode(e, v.value)
+}
+
+// Value returns the value held by v, or nil if there is none.
+func (v Variant[K]) Value() any {
+	return v.value
+}
+
+// Discriminator returns the discriminator value that selected the
+// choice held by v, in the canonical form described for
+// [DiscriminatorValue], or nil if there was none.
+func (v Variant[K]) Discriminator() any {
+	return v.discrim
+}
+
+// As reports whether the value held by v can be assigned to the value
+// pointed to by target and, if so, assigns it. Like [errors.As], it
+// panics if target is not a non-nil pointer. For example:
+//
+//	var c Circle
+//	if v.As(&c) {
+//		...
+//	}
+func (v Variant[K]) As(target any) bool {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		panic("target provided to Variant.As is not a non-nil pointer")
+	}
+	if v.value == nil || !reflect.TypeOf(v.value).AssignableTo(rv.Type().Elem()) {
+		return false
+	}
+	rv.Elem().Set(reflect.ValueOf(v.value))
+	return true
+}
+
+// FilterVariant returns the elements of vals that hold values of type
+// C, in order, saving a type switch when only one choice of a union is
+// of interest. For example, given animals of type []Animal:
+//
+//	dogs := jsondiscrim.FilterVariant[Animal, *Dog](animals)
+//
+// C may also be an interface type, in which case the elements that
+// implement it are returned. Nil elements are never returned.
+func FilterVariant[T, C any](vals []T) []C {
+	var filtered []C
+	for _, v := range vals {
+		if c, ok := any(v).(C); ok {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
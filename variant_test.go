@@ -0,0 +1,105 @@
+package jsondiscrim
+
+import (
+	"testing"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/go-quicktest/qt"
+)
+
+// Disc and Tile share no methods,
+// so they cannot form an interface union.
+type Disc struct {
+	Kind stringConst[struct {
+		string `const:"disc"`
+	}] `json:"kind"`
+	Radius float64 `json:"radius"`
+}
+
+type Tile struct {
+	Kind stringConst[struct {
+		string `const:"tile"`
+	}] `json:"kind"`
+	Side float64 `json:"side"`
+}
+
+type OtherShape struct {
+	Kind string `json:"kind"`
+}
+
+type shapeKey struct{}
+
+type Drawing struct {
+	Shapes []Variant[shapeKey] `json:"shapes"`
+}
+
+func init() {
+	RegisterVariants[shapeKey](Union[any]{
+		Choices:  []any{Disc{}, (*Tile)(nil)},
+		Fallback: OtherShape{},
+	})
+}
+
+func TestVariant(t *testing.T) {
+	var d Drawing
+	err := json.Unmarshal([]byte(`{"shapes":[{"kind":"disc","radius":2},{"side":3,"kind":"tile"},{"kind":"hexagon"},{},null]}`), &d)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.HasLen(d.Shapes, 5))
+
+	qt.Assert(t, qt.DeepEquals(d.Shapes[0].Value(), any(Disc{Radius: 2})))
+	qt.Assert(t, qt.Equals(d.Shapes[0].Discriminator(), any("disc")))
+	var c Disc
+	qt.Assert(t, qt.IsTrue(d.Shapes[0].As(&c)))
+	qt.Assert(t, qt.Equals(c.Radius, 2.0))
+	var s *Tile
+	qt.Assert(t, qt.IsFalse(d.Shapes[0].As(&s)))
+
+	qt.Assert(t, qt.IsTrue(d.Shapes[1].As(&s)))
+	qt.Assert(t, qt.DeepEquals(s, &Tile{Side: 3}))
+	qt.Assert(t, qt.Equals(d.Shapes[1].Discriminator(), any("tile")))
+
+	qt.Assert(t, qt.DeepEquals(d.Shapes[2].Value(), any(OtherShape{Kind: "hexagon"})))
+	qt.Assert(t, qt.Equals(d.Shapes[2].Discriminator(), any("hexagon")))
+
+	qt.Assert(t, qt.DeepEquals(d.Shapes[3].Value(), any(OtherShape{})))
+	qt.Assert(t, qt.IsNil(d.Shapes[3].Discriminator()))
+
+	qt.Assert(t, qt.IsNil(d.Shapes[4].Value()))
+	qt.Assert(t, qt.IsFalse(d.Shapes[4].As(&c)))
+
+	data, err := json.Marshal(d, json.Deterministic(true))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.Equals(string(data), `{"shapes":[{"kind":"disc","radius":2},{"kind":"tile","side":3},{"kind":"hexagon"},{"kind":""},null]}`))
+}
+
+func TestVariantError(t *testing.T) {
+	var d Drawing
+	err := json.Unmarshal([]byte(`{"shapes":[{"kind":"disc","radius":"big"}]}`), &d)
+	qt.Assert(t, qt.ErrorMatches(err, `.* within "/shapes/0/radius"`))
+
+	type unregistered struct{}
+	var v Variant[unregistered]
+	err = json.Unmarshal([]byte(`{}`), &v)
+	qt.Assert(t, qt.ErrorMatches(err, `.*no variants registered for jsondiscrim.unregistered`))
+
+	qt.Assert(t, qt.PanicMatches(func() {
+		v.As(Disc{})
+	}, `target provided to Variant.As is not a non-nil pointer`))
+}
+
+func TestFilterVariant(t *testing.T) {
+	animals := []Animal{
+		&Dog{Bark: "a"},
+		&Cat{Meow: "b"},
+		nil,
+		&Dog{Bark: "c"},
+		&OtherAnimal{Type: "bird"},
+	}
+	qt.Assert(t, qt.DeepEquals(FilterVariant[Animal, *Dog](animals), []*Dog{{Bark: "a"}, {Bark: "c"}}))
+	qt.Assert(t, qt.DeepEquals(FilterVariant[Animal, *Cat](animals), []*Cat{{Meow: "b"}}))
+	qt.Assert(t, qt.IsNil(FilterVariant[Animal, *Bird](animals)))
+	// A value type does not match a pointer element.
+	qt.Assert(t, qt.IsNil(FilterVariant[Animal, Dog](animals)))
+	// An interface type matches all non-nil elements implementing it.
+	qt.Assert(t, qt.HasLen(FilterVariant[Animal, Animal](animals), 4))
+}
@@ -0,0 +1,190 @@
+package jsondiscrim
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+)
+
+// VerifyRoundTrip checks that each of the given choices, following the
+// rules documented in [Structs], marshals to JSON with the expected
+// discriminator value and that unmarshaling the result chooses the
+// same type again. It returns an error describing every choice that
+// fails the check.
+//
+// It is intended to be called from tests to catch inconsistencies
+// between the [Const] fields of the choices and the way the choices
+// actually marshal, for example due to custom MarshalJSON methods.
+func VerifyRoundTrip[T any](choices ...T) error {
+	ifaceType := reflect.TypeFor[T]()
+	if ifaceType.Kind() != reflect.Interface {
+		return fmt.Errorf("type %v is not an interface type", ifaceType)
+	}
+	u, err := newUnion(&options{}, ifaceType, registeredFallback[T](), choices)
+	if err != nil {
+		return err
+	}
+	unmarshalers := json.WithUnmarshalers(unionUnmarshalers[T](u))
+	var errs []error
+	for _, choice := range choices {
+		t := reflect.TypeOf(choice)
+		value := constFields(t)[u.discrimField]
+		if err := verifyRoundTrip[T](t, u.discrimField, value, unmarshalers); err != nil {
+			errs = append(errs, fmt.Errorf("choice %v: %v", t, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func verifyRoundTrip[T any](t reflect.Type, discrimField string, value any, unmarshalers json.Options) error {
+	v := reflect.New(t).Elem()
+	if t.Kind() == reflect.Pointer {
+		v.Set(reflect.New(t.Elem()))
+	}
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return fmt.Errorf("cannot marshal: %v", err)
+	}
+	got, err := fieldValue(data, discrimField)
+	if err != nil {
+		return fmt.Errorf("marshaled as %s: %v", data, err)
+	}
+	if canonicalValue(got) != canonicalValue(value) {
+		return fmt.Errorf("marshaled as %s: discriminator is %#v but want %#v", data, got, value)
+	}
+	var x T
+	if err := json.Unmarshal(data, &x, unmarshalers); err != nil {
+		return fmt.Errorf("cannot unmarshal %s: %v", data, err)
+	}
+	if gotType := reflect.TypeOf(x); gotType != t {
+		return fmt.Errorf("unmarshaled %s as %v", data, gotType)
+	}
+	return nil
+}
+
+// Validate checks that the given choices form a well formed union
+// following the rules documented in [Structs]. Unlike [Structs], which
+// stops at the first problem, it returns an error joining all the
+// problems found, including nil or invalid choices, choices with the
+// same type, choices lacking the discriminator field, choices sharing
+// a discriminator value or alias and ambiguous discriminator fields.
+//
+// It is intended to be called from tests to help fix a newly defined
+// union in one go.
+func Validate[T any](choices ...T) error {
+	ifaceType := reflect.TypeFor[T]()
+	if ifaceType.Kind() != reflect.Interface {
+		return fmt.Errorf("type %v is not an interface type", ifaceType)
+	}
+	if len(choices) == 0 {
+		return fmt.Errorf("no choices provided")
+	}
+	var errs []error
+	// types holds the type of each valid choice.
+	var types []reflect.Type
+	// valuesByField holds, for each field, the value of that
+	// field in each choice in types that has it.
+	valuesByField := make(map[string]map[reflect.Type]any)
+	firstIndex := make(map[reflect.Type]int)
+	for i, choice := range choices {
+		if isNil(choice) {
+			errs = append(errs, fmt.Errorf("choice %d is nil", i))
+			continue
+		}
+		t := reflect.TypeOf(choice)
+		if j, ok := firstIndex[t]; ok {
+			errs = append(errs, fmt.Errorf("choices %d and %d have the same type %v", j, i, t))
+			continue
+		}
+		firstIndex[t] = i
+		fields, err := checkedConstFields(t)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("choice %v: %v", t, err))
+			continue
+		}
+		types = append(types, t)
+		for name, v := range fields {
+			if valuesByField[name] == nil {
+				valuesByField[name] = make(map[reflect.Type]any)
+			}
+			valuesByField[name][t] = v
+		}
+	}
+	if len(types) == 0 {
+		return errors.Join(errs...)
+	}
+	distinct := func(field string) int {
+		seen := make(map[any]bool)
+		for _, v := range valuesByField[field] {
+			seen[v] = true
+		}
+		return len(seen)
+	}
+	// checkValues reports the choices that lack the given field or
+	// that share a value for it with an earlier choice, comparing
+	// values in their canonical form and including aliases, as
+	// [Structs] does.
+	checkValues := func(field string) {
+		values := valuesByField[field]
+		typeByValue := make(map[any]reflect.Type)
+		for _, t := range types {
+			v, ok := values[t]
+			if !ok {
+				errs = append(errs, fmt.Errorf("choice %v has no Const field %q", t, field))
+				continue
+			}
+			for _, v := range append([]any{v}, constAliases(t, field, nil)...) {
+				cv := canonicalValue(v)
+				if t1, ok := typeByValue[cv]; ok {
+					errs = append(errs, fmt.Errorf("choices %v and %v have the same value %#v for field %q", t1, t, v, field))
+					continue
+				}
+				typeByValue[cv] = t
+			}
+		}
+	}
+	var qualified []string
+	for field, values := range valuesByField {
+		if len(values) == len(types) && distinct(field) == len(types) {
+			qualified = append(qualified, field)
+		}
+	}
+	slices.Sort(qualified)
+	switch {
+	case len(qualified) > 1:
+		errs = append(errs, fmt.Errorf("ambiguous discriminator fields %v", qualified))
+	case len(qualified) == 1:
+		checkValues(qualified[0])
+	case len(valuesByField) == 0:
+		errs = append(errs, fmt.Errorf("no choices have Const fields"))
+	default:
+		// Report the problems with the field that comes closest
+		// to being the discriminator.
+		checkValues(slices.MaxFunc(slices.Sorted(maps.Keys(valuesByField)), func(f1, f2 string) int {
+			return cmp.Or(
+				cmp.Compare(len(valuesByField[f1]), len(valuesByField[f2])),
+				cmp.Compare(distinct(f1), distinct(f2)),
+				// Prefer earlier names.
+				strings.Compare(f2, f1),
+			)
+		}))
+	}
+	return errors.Join(errs...)
+}
+
+// checkedConstFields is like constFields except that it returns an
+// error instead of panicking.
+func checkedConstFields(t reflect.Type) (_ map[string]any, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+	return constFields(t), nil
+}
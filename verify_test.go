@@ -0,0 +1,147 @@
+package jsondiscrim
+
+import (
+	"testing"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/go-quicktest/qt"
+)
+
+func TestVerifyRoundTrip(t *testing.T) {
+	err := VerifyRoundTrip[Animal]((*Dog)(nil), Cat{}, (*Bird)(nil))
+	qt.Assert(t, qt.IsNil(err))
+}
+
+func TestVerifyRoundTripNumeric(t *testing.T) {
+	err := VerifyRoundTrip[Coded]((*Code12)(nil), (*Code123)(nil))
+	qt.Assert(t, qt.IsNil(err))
+}
+
+// Lizard marshals itself with a discriminator that does not agree
+// with its Const field.
+type Lizard struct {
+	BaseAnimal[struct {
+		string `const:"lizard"`
+	}]
+}
+
+func (Lizard) isAnimal() {}
+
+func (Lizard) MarshalJSON() ([]byte, error) {
+	return []byte(`{"type":"gecko"}`), nil
+}
+
+// Snake marshals itself without any discriminator.
+type Snake struct {
+	BaseAnimal[struct {
+		string `const:"snake"`
+	}]
+}
+
+func (Snake) isAnimal() {}
+
+func (Snake) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct{ Hiss bool }{})
+}
+
+func TestVerifyRoundTripMismatch(t *testing.T) {
+	err := VerifyRoundTrip[Animal]((*Dog)(nil), (*Lizard)(nil), Snake{})
+	qt.Assert(t, qt.ErrorMatches(err, `(?s).*choice \*jsondiscrim.Lizard: marshaled as {"type":"gecko"}: discriminator is "gecko" but want "lizard".*`))
+	qt.Assert(t, qt.ErrorMatches(err, `(?s).*choice jsondiscrim.Snake: marshaled as {"Hiss":false}: discriminator field "type" not found.*`))
+	qt.Assert(t, qt.Not(qt.ErrorMatches(err, `(?s).*Dog.*`)))
+}
+
+func TestVerifyRoundTripInvalidChoices(t *testing.T) {
+	err := VerifyRoundTrip[Animal]((*Dog)(nil), (*Dog)(nil))
+	qt.Assert(t, qt.ErrorMatches(err, `choices 0 and 1 have the same type \*jsondiscrim.Dog`))
+}
+
+// Line2Float has the same discriminator value as [Line2] when it is
+// compared as a JSON number.
+type Line2Float struct {
+	Dims Const[float64, struct {
+		float64 `const:"1.0"`
+	}] `json:"dims"`
+}
+
+func (*Line2Float) isShape2() {}
+
+func TestVerifyRoundTripCollision(t *testing.T) {
+	err := VerifyRoundTrip[Shape2]((*Line2)(nil), (*Line2Float)(nil))
+	qt.Assert(t, qt.ErrorMatches(err, `discriminator value 1 of \*jsondiscrim.Line2(Float)? is already used by \*jsondiscrim.Line2(Float)?`))
+}
+
+func TestValidate(t *testing.T) {
+	type NoConst struct {
+		Name string
+	}
+	type NotStruct int
+	type Kitten struct {
+		BaseAnimal[struct {
+			string `const:"cat"`
+		}]
+	}
+	type CatHound struct {
+		BaseAnimal[struct {
+			string `const:"cathound" const:"hound"`
+		}]
+	}
+	tests := []struct {
+		name    string
+		choices []any
+		wantErr string
+	}{{
+		name:    "valid",
+		choices: []any{(*Dog)(nil), (*Cat)(nil), Bird{}},
+	}, {
+		name:    "no choices",
+		wantErr: `no choices provided`,
+	}, {
+		name:    "no const fields",
+		choices: []any{NoConst{}},
+		wantErr: `no choices have Const fields`,
+	}, {
+		name:    "ambiguous",
+		choices: []any{Tri1{}, Tri2{}},
+		wantErr: `ambiguous discriminator fields \[a b c\]`,
+	}, {
+		name:    "same number",
+		choices: []any{(*Line2)(nil), (*Line2Float)(nil)},
+		wantErr: `choices \*jsondiscrim.Line2 and \*jsondiscrim.Line2Float have the same value 1 for field "dims"`,
+	}, {
+		name:    "same alias",
+		choices: []any{(*AliasDog)(nil), (*CatHound)(nil)},
+		wantErr: `choices \*jsondiscrim.AliasDog and \*jsondiscrim.CatHound have the same value "hound" for field "type"`,
+	}, {
+		name: "several problems",
+		choices: []any{
+			(*Dog)(nil),
+			nil,
+			(*Cat)(nil),
+			(*Dog)(nil),
+			NoConst{},
+			NotStruct(0),
+			(*Kitten)(nil),
+		},
+		wantErr: `choice 1 is nil
+choices 0 and 3 have the same type \*jsondiscrim.Dog
+choice jsondiscrim.NotStruct: argument to Structs is jsondiscrim.NotStruct not struct or pointer-to-struct
+choice jsondiscrim.NoConst has no Const field "type"
+choices \*jsondiscrim.Cat and \*jsondiscrim.Kitten have the same value "cat" for field "type"`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.choices...)
+			if tt.wantErr == "" {
+				qt.Assert(t, qt.IsNil(err))
+				return
+			}
+			qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+		})
+	}
+}
+
+func TestValidateNotInterface(t *testing.T) {
+	err := Validate(Dog{})
+	qt.Assert(t, qt.ErrorMatches(err, `type jsondiscrim.Dog is not an interface type`))
+}
@@ -0,0 +1,357 @@
+// Package yamldiscrim applies the discriminated unions of package
+// jsondiscrim to YAML documents, such as configuration files, which
+// often have the same tagged-union shape as JSON.
+//
+// A YAML document is unmarshaled by converting it to the equivalent
+// JSON, keeping the order of mapping keys, and unmarshaling that with
+// the unmarshalers of a union, so [jsondiscrim.Const] fields and all
+// the options of [jsondiscrim.StructsWithOptions] work just as they do
+// for JSON. For the same reason, the names of fields are given by their
+// json tags rather than their yaml tags. For example:
+//
+//	var cfg Config
+//	err := yamldiscrim.Unmarshal(data, &cfg, json.WithUnmarshalers(
+//		jsondiscrim.Structs[Source]((*GitSource)(nil), (*HTTPSource)(nil)),
+//	))
+package yamldiscrim
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+)
+
+// Unmarshal unmarshals the first YAML document in data into v. It is
+// like [json.Unmarshal] with the given options applied to the JSON
+// equivalent of the document, as returned by [NodeToJSON], except that
+// errors about a particular value report the line on which it appears.
+func Unmarshal(data []byte, v any, opts ...json.Options) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	return UnmarshalNode(&doc, v, opts...)
+}
+
+// UnmarshalNode is like [Unmarshal] except that it unmarshals a YAML
+// node that has already been parsed, such as one passed to an
+// UnmarshalYAML method or read with a [yaml.Decoder].
+func UnmarshalNode(n *yaml.Node, v any, opts ...json.Options) error {
+	data, err := NodeToJSON(n)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v, opts...); err != nil {
+		return nodeError(n, err)
+	}
+	return nil
+}
+
+// NodeToJSON returns the JSON equivalent of the YAML node n. Aliases
+// are expanded, the members of mappings merged with the "<<" key are
+// included, and mapping keys are written in order. Scalars are written
+// as JSON strings unless they resolve to null, a boolean or a number;
+// an empty document is written as null.
+//
+// It returns an error for mapping keys that are not scalars and for
+// numbers that JSON cannot represent, such as .inf.
+func NodeToJSON(n *yaml.Node) (jsontext.Value, error) {
+	var buf bytes.Buffer
+	ne := &nodeEncoder{
+		e:         jsontext.NewEncoder(&buf),
+		expanding: make(map[*yaml.Node]bool),
+	}
+	if err := ne.encode(n); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(buf.Bytes()), nil
+}
+
+// nodeEncoder writes the JSON equivalent of YAML nodes.
+type nodeEncoder struct {
+	e *jsontext.Encoder
+
+	// expanding holds the anchored nodes being expanded, so that
+	// an alias within its own anchor can be reported rather than
+	// expanded forever.
+	expanding map[*yaml.Node]bool
+
+	// nodes counts the nodes encoded, and aliased counts those
+	// encoded within an alias or a merged mapping, which are
+	// encoded once for every reference to them.
+	nodes, aliased int
+
+	// aliasDepth holds the number of aliases and merged
+	// mappings that the current node is within.
+	aliasDepth int
+}
+
+// The limits on the proportion of encoded nodes that come from aliases
+// are those used by [yaml.Unmarshal]. They allow 99% for documents of
+// up to aliasRatioRangeLow nodes, falling to 10% for documents of at
+// least aliasRatioRangeHigh nodes.
+const (
+	aliasRatioRangeLow  = 400000
+	aliasRatioRangeHigh = 4000000
+)
+
+// allowedAliasRatio returns the greatest proportion of the given number
+// of encoded nodes that may come from aliases.
+func allowedAliasRatio(nodes int) float64 {
+	switch {
+	case nodes <= aliasRatioRangeLow:
+		return 0.99
+	case nodes >= aliasRatioRangeHigh:
+		return 0.10
+	default:
+		return 0.99 - 0.89*float64(nodes-aliasRatioRangeLow)/(aliasRatioRangeHigh-aliasRatioRangeLow)
+	}
+}
+
+// encode writes the JSON equivalent of n. It returns an error when
+// so much of the output comes from expanding aliases that it would be
+// out of all proportion to the size of the document.
+func (ne *nodeEncoder) encode(n *yaml.Node) error {
+	ne.nodes++
+	if ne.aliasDepth > 0 {
+		ne.aliased++
+	}
+	if ne.aliased > 100 && ne.nodes > 1000 && float64(ne.aliased)/float64(ne.nodes) > allowedAliasRatio(ne.nodes) {
+		return fmt.Errorf("line %d: document contains excessive aliasing", n.Line)
+	}
+	e := ne.e
+	switch n.Kind {
+	case 0:
+		return e.WriteToken(jsontext.Null)
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return e.WriteToken(jsontext.Null)
+		}
+		return ne.encode(n.Content[0])
+	case yaml.AliasNode:
+		if ne.expanding[n.Alias] {
+			return fmt.Errorf("line %d: alias %q refers to a value containing it", n.Line, n.Value)
+		}
+		ne.expanding[n.Alias] = true
+		defer delete(ne.expanding, n.Alias)
+		return ne.encodeAliased(n.Alias)
+	case yaml.ScalarNode:
+		tok, err := scalarToken(n)
+		if err != nil {
+			return err
+		}
+		return e.WriteToken(tok)
+	case yaml.SequenceNode:
+		if err := e.WriteToken(jsontext.BeginArray); err != nil {
+			return err
+		}
+		for _, elem := range n.Content {
+			if err := ne.encode(elem); err != nil {
+				return err
+			}
+		}
+		return e.WriteToken(jsontext.EndArray)
+	case yaml.MappingNode:
+		ms, err := members(n)
+		if err != nil {
+			return err
+		}
+		if err := e.WriteToken(jsontext.BeginObject); err != nil {
+			return err
+		}
+		for _, m := range ms {
+			if err := e.WriteToken(jsontext.String(m.name)); err != nil {
+				return fmt.Errorf("line %d: %w", m.line, err)
+			}
+			encode := ne.encode
+			if m.merged {
+				encode = ne.encodeAliased
+			}
+			if err := encode(m.value); err != nil {
+				return err
+			}
+		}
+		return e.WriteToken(jsontext.EndObject)
+	}
+	return fmt.Errorf("line %d: unexpected YAML node kind %v", n.Line, n.Kind)
+}
+
+// encodeAliased is like encode for a node that is referred to
+// rather than written in place.
+func (ne *nodeEncoder) encodeAliased(n *yaml.Node) error {
+	ne.aliasDepth++
+	defer func() { ne.aliasDepth-- }()
+	return ne.encode(n)
+}
+
+// scalarToken returns the JSON token for the scalar node n.
+func scalarToken(n *yaml.Node) (jsontext.Token, error) {
+	switch n.ShortTag() {
+	case "!!null":
+		return jsontext.Null, nil
+	case "!!bool":
+		var b bool
+		if err := n.Decode(&b); err != nil {
+			return jsontext.Token{}, err
+		}
+		return jsontext.Bool(b), nil
+	case "!!int", "!!float":
+		var v any
+		if err := n.Decode(&v); err != nil {
+			return jsontext.Token{}, err
+		}
+		switch v := v.(type) {
+		case int:
+			return jsontext.Int(int64(v)), nil
+		case int64:
+			return jsontext.Int(v), nil
+		case uint64:
+			return jsontext.Uint(v), nil
+		case float64:
+			if math.IsInf(v, 0) || math.IsNaN(v) {
+				return jsontext.Token{}, fmt.Errorf("line %d: number %s cannot be represented in JSON", n.Line, n.Value)
+			}
+			return jsontext.Float(v), nil
+		}
+	}
+	return jsontext.String(n.Value), nil
+}
+
+// member holds a member of a YAML mapping.
+type member struct {
+	name  string
+	line  int
+	value *yaml.Node
+	// merged reports whether the member comes from
+	// a mapping merged with the "<<" key.
+	merged bool
+}
+
+// members returns the members of the mapping node n in order. The
+// members of any mappings merged into n with the "<<" key take the
+// place of that key, except for those whose keys n gives itself; when
+// several mappings are merged, the earlier ones take precedence.
+func members(n *yaml.Node) ([]member, error) {
+	own := make(map[string]bool)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key := resolve(n.Content[i])
+		if key.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("line %d: mapping key is not a scalar", key.Line)
+		}
+		if !isMerge(key) {
+			own[key.Value] = true
+		}
+	}
+	var ms []member
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := resolve(n.Content[i]), n.Content[i+1]
+		if !isMerge(key) {
+			ms = append(ms, member{name: key.Value, line: key.Line, value: value})
+			continue
+		}
+		merged := []*yaml.Node{resolve(value)}
+		if merged[0].Kind == yaml.SequenceNode {
+			merged = merged[0].Content
+		}
+		for _, mn := range merged {
+			mn = resolve(mn)
+			if mn.Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("line %d: merged value is not a mapping", mn.Line)
+			}
+			mms, err := members(mn)
+			if err != nil {
+				return nil, err
+			}
+			for _, m := range mms {
+				if !own[m.name] && !seen[m.name] {
+					seen[m.name] = true
+					m.merged = true
+					ms = append(ms, m)
+				}
+			}
+		}
+	}
+	return ms, nil
+}
+
+// isMerge reports whether the scalar node key is the merge key.
+func isMerge(key *yaml.Node) bool {
+	return key.ShortTag() == "!!merge"
+}
+
+// resolve returns the node that n stands for, following aliases and
+// looking inside documents.
+func resolve(n *yaml.Node) *yaml.Node {
+	for {
+		switch {
+		case n.Kind == yaml.AliasNode:
+			n = n.Alias
+		case n.Kind == yaml.DocumentNode && len(n.Content) > 0:
+			n = n.Content[0]
+		default:
+			return n
+		}
+	}
+}
+
+// nodeError returns err annotated with the line of the node within n
+// that it applies to, if that can be found.
+func nodeError(n *yaml.Node, err error) error {
+	var ptr jsontext.Pointer
+	var semErr *json.SemanticError
+	var synErr *jsontext.SyntacticError
+	switch {
+	case errors.As(err, &semErr):
+		ptr = semErr.JSONPointer
+	case errors.As(err, &synErr):
+		ptr = synErr.JSONPointer
+	default:
+		return err
+	}
+	if n := nodeAt(n, ptr); n != nil && n.Line > 0 {
+		return fmt.Errorf("line %d: %w", n.Line, err)
+	}
+	return err
+}
+
+// nodeAt returns the node within n found by the JSON pointer ptr into
+// the JSON equivalent of n, or nil if there is none.
+func nodeAt(n *yaml.Node, ptr jsontext.Pointer) *yaml.Node {
+	for tok := range ptr.Tokens() {
+		switch n = resolve(n); n.Kind {
+		case yaml.MappingNode:
+			ms, err := members(n)
+			if err != nil {
+				return nil
+			}
+			var next *yaml.Node
+			for _, m := range ms {
+				if m.name == tok {
+					next = m.value
+					break
+				}
+			}
+			if next == nil {
+				return nil
+			}
+			n = next
+		case yaml.SequenceNode:
+			i, err := strconv.Atoi(tok)
+			if err != nil || i < 0 || i >= len(n.Content) {
+				return nil
+			}
+			n = n.Content[i]
+		default:
+			return nil
+		}
+	}
+	return resolve(n)
+}
@@ -0,0 +1,223 @@
+package yamldiscrim
+
+import (
+	"testing"
+
+	"github.com/go-quicktest/qt"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cue-exp/jsondiscrim"
+	"github.com/cue-exp/jsondiscrim/internal/json"
+	"github.com/cue-exp/jsondiscrim/internal/jsontext"
+)
+
+type Source interface {
+	isSource()
+}
+
+type GitSource struct {
+	Kind jsondiscrim.Const[string, struct {
+		string `const:"git"`
+	}] `json:"kind"`
+	URL string `json:"url"`
+	Ref string `json:"ref,omitempty"`
+}
+
+func (*GitSource) isSource() {}
+
+type HTTPSource struct {
+	Kind jsondiscrim.Const[string, struct {
+		string `const:"http"`
+	}] `json:"kind"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+func (*HTTPSource) isSource() {}
+
+type Config struct {
+	Name    string   `json:"name"`
+	Sources []Source `json:"sources"`
+}
+
+var unmarshalers = json.WithUnmarshalers(jsondiscrim.Structs[Source]((*GitSource)(nil), (*HTTPSource)(nil)))
+
+func TestUnmarshal(t *testing.T) {
+	data := `
+name: example
+sources:
+  - kind: git
+    url: https://example.com/repo.git
+    ref: main
+  - url: https://example.com/archive.tar.gz
+    kind: http
+    headers: &headers
+      Accept: "*/*"
+  - <<: {kind: http, url: https://example.com/other}
+    headers: *headers
+`
+	var got Config
+	err := Unmarshal([]byte(data), &got, unmarshalers)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, Config{
+		Name: "example",
+		Sources: []Source{
+			&GitSource{URL: "https://example.com/repo.git", Ref: "main"},
+			&HTTPSource{URL: "https://example.com/archive.tar.gz", Headers: map[string]string{"Accept": "*/*"}},
+			&HTTPSource{URL: "https://example.com/other", Headers: map[string]string{"Accept": "*/*"}},
+		},
+	}))
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{
+			name: "unknown discriminator value",
+			yaml: `
+name: example
+sources:
+  - kind: git
+    url: a
+  - kind: svn
+    url: b
+`,
+			wantErr: `line 6: .*unknown discriminator value "svn".*`,
+		},
+		{
+			name: "wrong type in choice",
+			yaml: `
+sources:
+  - kind: http
+    headers:
+      Accept: [a, b]
+`,
+			wantErr: `line 5: .*(cannot|unable to) unmarshal JSON array into Go string.*`,
+		},
+		{
+			name: "missing discriminator",
+			yaml: `
+sources:
+  - url: a
+`,
+			wantErr: `line 3: .*discriminator field "kind" not found`,
+		},
+		{
+			name:    "invalid YAML",
+			yaml:    "sources: [",
+			wantErr: `yaml: .*`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Config
+			err := Unmarshal([]byte(tt.yaml), &got, unmarshalers)
+			qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+		})
+	}
+}
+
+// Pipeline unmarshals its sources itself from the YAML node,
+// as it might when its YAML is decoded by other code.
+type Pipeline struct {
+	Source Source
+}
+
+func (p *Pipeline) UnmarshalYAML(n *yaml.Node) error {
+	var raw struct {
+		Source Source `json:"source"`
+	}
+	if err := UnmarshalNode(n, &raw, unmarshalers); err != nil {
+		return err
+	}
+	p.Source = raw.Source
+	return nil
+}
+
+func TestUnmarshalNode(t *testing.T) {
+	var got []Pipeline
+	err := yaml.Unmarshal([]byte(`
+- source: {kind: git, url: a}
+- source: {kind: http, url: b}
+`), &got)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.DeepEquals(got, []Pipeline{
+		{Source: &GitSource{URL: "a"}},
+		{Source: &HTTPSource{URL: "b"}},
+	}))
+}
+
+// aliasBomb expands to about 10^9 values.
+const aliasBomb = `
+a: &a [x, x, x, x, x, x, x, x, x, x]
+b: &b [*a, *a, *a, *a, *a, *a, *a, *a, *a, *a]
+c: &c [*b, *b, *b, *b, *b, *b, *b, *b, *b, *b]
+d: &d [*c, *c, *c, *c, *c, *c, *c, *c, *c, *c]
+e: &e [*d, *d, *d, *d, *d, *d, *d, *d, *d, *d]
+f: &f [*e, *e, *e, *e, *e, *e, *e, *e, *e, *e]
+g: &g [*f, *f, *f, *f, *f, *f, *f, *f, *f, *f]
+h: &h [*g, *g, *g, *g, *g, *g, *g, *g, *g, *g]
+i: &i [*h, *h, *h, *h, *h, *h, *h, *h, *h, *h]
+`
+
+// mergeBomb is like aliasBomb with the aliases merged into mappings.
+const mergeBomb = `
+a: &a {a0: [x, x, x, x, x, x, x, x, x, x]}
+b: &b {<<: *a, b0: *a, b1: *a, b2: *a, b3: *a, b4: *a, b5: *a, b6: *a, b7: *a, b8: *a}
+c: &c {<<: *b, c0: *b, c1: *b, c2: *b, c3: *b, c4: *b, c5: *b, c6: *b, c7: *b, c8: *b}
+d: &d {<<: *c, d0: *c, d1: *c, d2: *c, d3: *c, d4: *c, d5: *c, d6: *c, d7: *c, d8: *c}
+e: &e {<<: *d, e0: *d, e1: *d, e2: *d, e3: *d, e4: *d, e5: *d, e6: *d, e7: *d, e8: *d}
+f: &f {<<: *e, f0: *e, f1: *e, f2: *e, f3: *e, f4: *e, f5: *e, f6: *e, f7: *e, f8: *e}
+g: &g {<<: *f, g0: *f, g1: *f, g2: *f, g3: *f, g4: *f, g5: *f, g6: *f, g7: *f, g8: *f}
+h: &h {<<: *g, h0: *g, h1: *g, h2: *g, h3: *g, h4: *g, h5: *g, h6: *g, h7: *g, h8: *g}
+i: &i {<<: *h, i0: *h, i1: *h, i2: *h, i3: *h, i4: *h, i5: *h, i6: *h, i7: *h, i8: *h}
+`
+
+func TestNodeToJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    string
+		wantErr string
+	}{
+		{"empty", ``, `null`, ""},
+		{"scalars", `[a, "1", 1, 0x10, -2, 1.5, 1e3, true, no, null, ~, 2001-12-14, 18446744073709551615]`, `[
+			"a", "1", 1, 16, -2, 1.5, 1000, true, "no", null, null, "2001-12-14", 18446744073709551615
+		]`, ""},
+		{"key order", "b: 1\na: 2\nc: {z: 1, y: 2}", `{"b":1,"a":2,"c":{"z":1,"y":2}}`, ""},
+		{"non-string keys", "1: a\ntrue: b", `{"1":"a","true":"b"}`, ""},
+		{"alias", "a: &x [1, 2]\nb: *x", `{"a":[1,2],"b":[1,2]}`, ""},
+		{"merge", "base: &base {a: 1, b: 2}\nderived:\n  b: 3\n  <<: *base\n  c: 4", `{"base":{"a":1,"b":2},"derived":{"b":3,"a":1,"c":4}}`, ""},
+		{"merge sequence", "x: &x {a: 1}\ny: &y {a: 2, b: 2}\nz: {<<: [*x, *y]}", `{"x":{"a":1},"y":{"a":2,"b":2},"z":{"a":1,"b":2}}`, ""},
+		{"multiline string", "a: |\n  one\n  two\n", `{"a":"one\ntwo\n"}`, ""},
+		{"infinity", `[.inf]`, ``, `line 1: number .inf cannot be represented in JSON`},
+		{"complex key", "? [a]\n: b", ``, `line 1: mapping key is not a scalar`},
+		{"merge not mapping", "a: {<<: 1}", ``, `line 1: merged value is not a mapping`},
+		{"alias bomb", aliasBomb, ``, `line \d+: document contains excessive aliasing`},
+		{"merge bomb", mergeBomb, ``, `line \d+: document contains excessive aliasing`},
+		{"duplicate key", "a: 1\na: 2", ``, `line 2: jsontext: duplicate object member name "a"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var n yaml.Node
+			err := yaml.Unmarshal([]byte(tt.yaml), &n)
+			if err == nil {
+				var got jsontext.Value
+				got, err = NodeToJSON(&n)
+				if err == nil {
+					want := jsontext.Value(tt.want)
+					qt.Assert(t, qt.IsNil(want.Compact()))
+					qt.Assert(t, qt.Equals(string(got), string(want)))
+				}
+			}
+			if tt.wantErr != "" {
+				qt.Assert(t, qt.ErrorMatches(err, tt.wantErr))
+				return
+			}
+			qt.Assert(t, qt.IsNil(err))
+		})
+	}
+}